@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+)
+
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+
+	// maxJobs - скільки завершених завдань зберігається для запитів статусу
+	maxJobs = 32
+)
+
+// adminJob - фонове завдання, запущене через /admin; статус доступний за URL з поля url.
+type adminJob struct {
+	ID       string     `json:"id"`
+	Type     string     `json:"type"`
+	State    string     `json:"state"`
+	URL      string     `json:"url"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+type jobRegistry struct {
+	lock sync.Mutex
+	next uint64
+	jobs map[string]*adminJob
+	// order - ідентифікатори в порядку запуску, щоб видаляти найстаріші
+	order []string
+}
+
+var jobs = &jobRegistry{jobs: make(map[string]*adminJob)}
+
+// start запускає run у фоні. Якщо завдання того ж типу ще виконується, повертає його і false,
+// бо дві компакції одночасно лише чекали б одна на одну.
+func (reg *jobRegistry) start(typ string, run func() error) (adminJob, bool) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	for _, id := range reg.order {
+		if job := reg.jobs[id]; job.Type == typ && job.State == jobRunning {
+			return *job, false
+		}
+	}
+
+	reg.next++
+	id := strconv.FormatUint(reg.next, 10)
+	job := &adminJob{ID: id, Type: typ, State: jobRunning, URL: "/admin/jobs/" + id, Started: time.Now()}
+	reg.jobs[id] = job
+	reg.order = append(reg.order, id)
+	reg.trim()
+
+	go func() {
+		err := run()
+		reg.lock.Lock()
+		defer reg.lock.Unlock()
+		finished := time.Now()
+		job.Finished = &finished
+		job.State = jobSucceeded
+		if err != nil {
+			job.State = jobFailed
+			job.Error = err.Error()
+		}
+	}()
+	return *job, true
+}
+
+// trim видаляє найстаріші завершені завдання понад maxJobs.
+func (reg *jobRegistry) trim() {
+	for i := 0; len(reg.order) > maxJobs && i < len(reg.order); {
+		id := reg.order[i]
+		if reg.jobs[id].State == jobRunning {
+			i++
+			continue
+		}
+		delete(reg.jobs, id)
+		reg.order = append(reg.order[:i], reg.order[i+1:]...)
+	}
+}
+
+func (reg *jobRegistry) get(id string) (adminJob, bool) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	job, ok := reg.jobs[id]
+	if !ok {
+		return adminJob{}, false
+	}
+	return *job, true
+}
+
+// adminOnly вимагає scope admin. Без -auth-config службовий API вимкнено, щоб його не можна
+// було викликати анонімно.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	protected := requireScope(scopeAdmin, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil {
+			httpError(w, "admin API requires -auth-config", http.StatusForbidden)
+			return
+		}
+		protected(w, r)
+	}
+}
+
+// handleAdminCompact: POST /admin/compact запускає компакцію у фоні і відповідає 202
+// з адресою статусу завдання в Location.
+func handleAdminCompact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job, _ := jobs.start("compact", db.Compact)
+	w.Header().Set("Location", job.URL)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+func handleAdminJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job, ok := jobs.get(strings.TrimPrefix(r.URL.Path, "/admin/jobs/"))
+	if !ok {
+		httpError(w, "job does not exist", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+func handleAdminSegments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	segments, err := db.Segments()
+	if err != nil {
+		storeError(w, err, "failed to list segments")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"segments": segments})
+}
+
+// adminStats доповнює статистику бази відомостями про вузол.
+type adminStats struct {
+	datastore.Stats
+	Role    string  `json:"role"`
+	Uptime  float64 `json:"uptime_seconds"`
+	LastSeq uint64  `json:"last_seq"`
+}
+
+// startTime - момент запуску процесу для поля uptime_seconds.
+var startTime = time.Now()
+
+func handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(adminStats{
+		Stats:   db.Stats(),
+		Role:    nodeRole(),
+		Uptime:  time.Since(startTime).Seconds(),
+		LastSeq: db.LastSeq(),
+	})
+}
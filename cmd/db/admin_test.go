@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin_Compact(t *testing.T) {
+	setupAuth(t)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Put("key", "value"))
+	}
+	compact := adminOnly(handleAdminCompact)
+	job := adminOnly(handleAdminJob)
+
+	assert.Equal(t, http.StatusUnauthorized, doAuth(compact, http.MethodPost, "/admin/compact", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, doAuth(compact, http.MethodPost, "/admin/compact", "w-secret", "").Code)
+
+	rec := doAuth(compact, http.MethodPost, "/admin/compact", "a-secret", "")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var started adminJob
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&started))
+	assert.Equal(t, started.URL, rec.Header().Get("Location"))
+
+	var status adminJob
+	require.Eventually(t, func() bool {
+		rec := doAuth(job, http.MethodGet, started.URL, "a-secret", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+		return status.State != jobRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, jobSucceeded, status.State)
+	assert.NotNil(t, status.Finished)
+	assert.EqualValues(t, 1, db.Stats().Compactions.Count)
+
+	assert.Equal(t, http.StatusNotFound, doAuth(job, http.MethodGet, "/admin/jobs/999", "a-secret", "").Code)
+}
+
+func TestAdmin_SegmentsAndStats(t *testing.T) {
+	setupAuth(t)
+	require.NoError(t, db.Put("key", "value"))
+
+	rec := doAuth(adminOnly(handleAdminSegments), http.MethodGet, "/admin/segments", "a-secret", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var segments struct {
+		Segments []struct {
+			Name         string  `json:"name"`
+			Records      int     `json:"records"`
+			LiveKeyRatio float64 `json:"live_key_ratio"`
+		} `json:"segments"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&segments))
+	require.NotEmpty(t, segments.Segments)
+	last := segments.Segments[len(segments.Segments)-1]
+	assert.Equal(t, 1, last.Records)
+	assert.Equal(t, 1.0, last.LiveKeyRatio)
+
+	rec = doAuth(adminOnly(handleAdminStats), http.MethodGet, "/admin/stats", "a-secret", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stats map[string]any
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&stats))
+	assert.Equal(t, "standalone", stats["role"])
+	assert.EqualValues(t, 1, stats["keys"])
+	assert.Contains(t, stats, "uptime_seconds")
+}
+
+func TestAdmin_DisabledWithoutAuth(t *testing.T) {
+	prev := auth
+	t.Cleanup(func() {
+		auth = prev
+	})
+	auth = nil
+	rec := doAuth(adminOnly(handleAdminCompact), http.MethodPost, "/admin/compact", "", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code, "admin API must not be anonymous")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+	// scopeAdmin дає доступ до службових endpoint'ів і включає read та write
+	scopeAdmin = "admin"
+)
+
+// authConfig - вміст файлу -auth-config:
+//
+//	{"tokens": [{"name": "server", "token": "...", "scopes": ["read", "write"], "prefixes": ["default/"]}]}
+//
+// Префікси задаються для рядка bucket/key; порожній список дозволяє всі ключі.
+type authConfig struct {
+	Tokens []tokenConfig `json:"tokens"`
+}
+
+type tokenConfig struct {
+	Name     string   `json:"name"`
+	Token    string   `json:"token"`
+	Scopes   []string `json:"scopes"`
+	Prefixes []string `json:"prefixes"`
+}
+
+// principal - власник токена; в журнал аудиту потрапляє лише його назва.
+type principal struct {
+	name     string
+	scopes   []string
+	prefixes []string
+}
+
+// allows перевіряє scope для ключа bucket/key; для списків key - префікс, який переглядають,
+// тож він має лежати всередині одного з дозволених префіксів.
+func (p *principal) allows(scope, key string) bool {
+	if !slices.Contains(p.scopes, scope) && !slices.Contains(p.scopes, scopeAdmin) {
+		return false
+	}
+	if len(p.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticator зберігає SHA-256 токенів, тож пошук не залежить від того, скільки символів токена вгадано.
+type authenticator struct {
+	tokens map[[sha256.Size]byte]*principal
+}
+
+// auth дорівнює nil, якщо -auth-config не задано; тоді всі запити дозволені.
+var auth *authenticator
+
+func loadAuth(path string) (*authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg authConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return newAuthenticator(cfg)
+}
+
+func newAuthenticator(cfg authConfig) (*authenticator, error) {
+	a := &authenticator{tokens: make(map[[sha256.Size]byte]*principal)}
+	for i, t := range cfg.Tokens {
+		if t.Name == "" || t.Token == "" {
+			return nil, fmt.Errorf("token %d: name and token are required", i)
+		}
+		for _, scope := range t.Scopes {
+			if scope != scopeRead && scope != scopeWrite && scope != scopeAdmin {
+				return nil, fmt.Errorf("token %s: unknown scope %q", t.Name, scope)
+			}
+		}
+		sum := sha256.Sum256([]byte(t.Token))
+		if _, exists := a.tokens[sum]; exists {
+			return nil, fmt.Errorf("token %s: duplicate token", t.Name)
+		}
+		a.tokens[sum] = &principal{name: t.Name, scopes: t.Scopes, prefixes: t.Prefixes}
+	}
+	return a, nil
+}
+
+func (a *authenticator) lookup(token string) *principal {
+	return a.tokens[sha256.Sum256([]byte(token))]
+}
+
+type principalKey struct{}
+
+// authenticated перевіряє заголовок Authorization: Bearer і передає власника токена далі в контексті.
+// Права на конкретні ключі перевіряє обробник через permit.
+func authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil {
+			next(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		p := auth.lookup(strings.TrimSpace(token))
+		if !ok || p == nil {
+			audit(r, "", "", "invalid or missing token")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="db"`)
+			jsonError(w, http.StatusUnauthorized, codeUnauthorized, "valid bearer token required")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// requireScope пропускає лише токени зі scope для всіх ключів.
+func requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return authenticated(func(w http.ResponseWriter, r *http.Request) {
+		if permit(w, r, scope, "") {
+			next(w, r)
+		}
+	})
+}
+
+// permit повертає true, якщо запит може виконати scope над ключем bucket/key; інакше відповідає 403
+// і пише рядок аудиту.
+func permit(w http.ResponseWriter, r *http.Request, scope, key string) bool {
+	if auth == nil {
+		return true
+	}
+	p, _ := r.Context().Value(principalKey{}).(*principal)
+	if p != nil && p.allows(scope, key) {
+		return true
+	}
+	name := ""
+	if p != nil {
+		name = p.name
+	}
+	audit(r, name, scope, key)
+	jsonError(w, http.StatusForbidden, codeForbidden, fmt.Sprintf("token has no %s access to %q", scope, key))
+	return false
+}
+
+func audit(r *http.Request, name, scope, detail string) {
+	log.Printf("AUDIT denied token=%q remote=%s method=%s path=%q scope=%s key=%q",
+		name, r.RemoteAddr, r.Method, r.URL.Path, scope, detail)
+}
+
+// tokenTransport додає токен вузла до запитів до лідера реплікації та інших вузлів Raft.
+type tokenTransport struct {
+	base  http.RoundTripper
+	token string
+}
+
+func (t *tokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(r)
+}
+
+// newPeerClient повертає клієнт для запитів до інших вузлів db, який за наявності token автентифікується ним.
+func newPeerClient(token string) *http.Client {
+	if token == "" {
+		return &http.Client{}
+	}
+	return &http.Client{Transport: &tokenTransport{base: http.DefaultTransport, token: token}}
+}
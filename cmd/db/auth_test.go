@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAuth(t *testing.T) {
+	t.Helper()
+	a, err := newAuthenticator(authConfig{Tokens: []tokenConfig{
+		{Name: "reader", Token: "r-secret", Scopes: []string{scopeRead}, Prefixes: []string{"default/pub"}},
+		{Name: "writer", Token: "w-secret", Scopes: []string{scopeRead, scopeWrite}, Prefixes: []string{"users/"}},
+		{Name: "ops", Token: "a-secret", Scopes: []string{scopeAdmin}},
+	}})
+	require.NoError(t, err)
+	prevAuth, prevDb := auth, db
+	t.Cleanup(func() {
+		auth, db = prevAuth, prevDb
+	})
+	auth = a
+	db, err = datastore.Open(t.TempDir(), 4096)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+}
+
+func doAuth(h http.HandlerFunc, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestAuth_HTTP(t *testing.T) {
+	setupAuth(t)
+	require.NoError(t, db.Put("pub1", "v"))
+	require.NoError(t, db.Put("secret", "v"))
+	h := authenticated(handleDb)
+
+	rec := doAuth(h, http.MethodGet, "/db/pub1", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+	assert.Equal(t, http.StatusUnauthorized, doAuth(h, http.MethodGet, "/db/pub1", "wrong", "").Code)
+
+	assert.Equal(t, http.StatusOK, doAuth(h, http.MethodGet, "/db/pub1", "r-secret", "").Code)
+	assert.Equal(t, http.StatusForbidden, doAuth(h, http.MethodGet, "/db/secret", "r-secret", "").Code)
+	assert.Equal(t, http.StatusForbidden, doAuth(h, http.MethodPost, "/db/pub1", "r-secret", `{"value": "x"}`).Code)
+	assert.Equal(t, http.StatusOK, doAuth(h, http.MethodGet, "/db/default/?prefix=pub", "r-secret", "").Code)
+	assert.Equal(t, http.StatusForbidden, doAuth(h, http.MethodGet, "/db/default/", "r-secret", "").Code,
+		"listing the whole bucket reveals keys outside the prefix")
+
+	assert.Equal(t, http.StatusNoContent, doAuth(h, http.MethodPost, "/db/users/alice", "w-secret", `{"value": "x"}`).Code)
+	assert.Equal(t, http.StatusForbidden, doAuth(h, http.MethodDelete, "/db/secret", "w-secret", "").Code)
+	assert.Equal(t, http.StatusNoContent, doAuth(h, http.MethodDelete, "/db/secret", "a-secret", "").Code)
+
+	batch := authenticated(handleBatch)
+	rec = doAuth(batch, http.MethodPost, "/db-batch", "w-secret",
+		`[{"op": "put", "bucket": "users", "key": "bob", "value": "1"}, {"op": "put", "key": "other", "value": "1"}]`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	_, err := db.Bucket("users").Get("bob")
+	assert.ErrorIs(t, err, datastore.ErrNotFound, "a denied batch must not write anything")
+
+	stats := requireScope(scopeAdmin, handleStats)
+	assert.Equal(t, http.StatusForbidden, doAuth(stats, http.MethodGet, "/stats", "w-secret", "").Code)
+	assert.Equal(t, http.StatusOK, doAuth(stats, http.MethodGet, "/stats", "a-secret", "").Code)
+}
+
+func TestAuth_Config(t *testing.T) {
+	_, err := newAuthenticator(authConfig{Tokens: []tokenConfig{{Name: "x", Token: "t", Scopes: []string{"root"}}}})
+	assert.ErrorContains(t, err, "unknown scope")
+	_, err = newAuthenticator(authConfig{Tokens: []tokenConfig{{Name: "x"}}})
+	assert.Error(t, err)
+	_, err = newAuthenticator(authConfig{Tokens: []tokenConfig{{Name: "a", Token: "t"}, {Name: "b", Token: "t"}}})
+	assert.ErrorContains(t, err, "duplicate")
+}
+
+func TestAuth_Resp(t *testing.T) {
+	a, err := newAuthenticator(authConfig{Tokens: []tokenConfig{
+		{Name: "reader", Token: "r-secret", Scopes: []string{scopeRead}, Prefixes: []string{"default/pub"}},
+	}})
+	require.NoError(t, err)
+	prev := auth
+	t.Cleanup(func() {
+		auth = prev
+	})
+	auth = a
+	_, store, c := startResp(t)
+	require.NoError(t, store.Put("pub1", "v"))
+
+	assert.Equal(t, "PONG", c.do("PING"))
+	assert.ErrorContains(t, c.do("GET", "pub1").(error), "NOAUTH")
+	assert.ErrorContains(t, c.do("AUTH", "wrong").(error), "WRONGPASS")
+	assert.Equal(t, "OK", c.do("AUTH", "default", "r-secret"))
+	assert.Equal(t, "v", c.do("GET", "pub1"))
+	assert.ErrorContains(t, c.do("GET", "secret").(error), "NOPERM")
+	assert.ErrorContains(t, c.do("SET", "pub2", "v").(error), "NOPERM")
+	assert.ErrorContains(t, c.do("EXISTS", "pub1", "secret").(error), "NOPERM")
+	assert.ErrorContains(t, c.do("SCAN", "0").(error), "NOPERM")
+}
+
+func TestAuth_RespExpire(t *testing.T) {
+	a, err := newAuthenticator(authConfig{Tokens: []tokenConfig{
+		{Name: "foo", Token: "foo-secret", Scopes: []string{scopeRead, scopeWrite}, Prefixes: []string{"default/foo"}},
+		{Name: "foo-ttl", Token: "ttl-secret", Scopes: []string{scopeRead, scopeWrite},
+			Prefixes: []string{"default/foo", respExpireBucket + "/foo"}},
+	}})
+	require.NoError(t, err)
+	prev := auth
+	t.Cleanup(func() {
+		auth = prev
+	})
+	auth = a
+	_, store, c := startResp(t)
+	require.NoError(t, store.Bucket(respExpireBucket).Put("foo", "1"))
+
+	// без права на TTL ключа токен не може ні задати, ні скинути його
+	assert.Equal(t, "OK", c.do("AUTH", "foo-secret"))
+	for _, cmd := range [][]string{{"SET", "foo", "v"}, {"EXPIRE", "foo", "100"}, {"DEL", "foo"}, {"INCR", "foo"}} {
+		assert.ErrorContains(t, c.do(cmd...).(error), "NOPERM", "%v", cmd)
+	}
+	v, err := store.Bucket(respExpireBucket).Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "1", v, "TTL must stay untouched")
+
+	assert.Equal(t, "OK", c.do("AUTH", "ttl-secret"))
+	assert.Equal(t, "OK", c.do("SET", "foo", "v"))
+	assert.Equal(t, int64(1), c.do("EXPIRE", "foo", "100"))
+	assert.Equal(t, "v", c.do("GET", "foo"))
+}
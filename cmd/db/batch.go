@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+)
+
+// maxBatchOps обмежує кількість операцій в одному запиті /db-batch.
+const maxBatchOps = 1000
+
+const (
+	batchGet    = "get"
+	batchPut    = "put"
+	batchDelete = "delete"
+)
+
+type batchOp struct {
+	Op     string `json:"op"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+}
+
+// batchResult - результат операції з тим самим індексом; Status, Code і Error мають ті самі значення,
+// що й відповідь на окремий запит /db/, а 424 означає, що запис скасовано через помилку іншої операції.
+type batchResult struct {
+	Status  int     `json:"status"`
+	Value   *string `json:"value,omitempty"`
+	Version uint64  `json:"version,omitempty"`
+	Code    string  `json:"code,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Committed bool          `json:"committed"`
+	Results   []batchResult `json:"results"`
+}
+
+// handleBatch: POST /db-batch з масивом операцій get/put/delete. Усі put і delete записуються
+// атомарно: якщо одна з них неможлива, не записується жодна. Читання виконуються після запису,
+// тож бачать зміни пакета.
+func handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// пакет має вміститися в сегмент; запас на екранування значень у JSON
+	var ops []batchOp
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*db.Options().SegmentSize)).Decode(&ops); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			httpError(w, "batch is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		httpError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(ops) > maxBatchOps {
+		httpError(w, fmt.Sprintf("batch has more than %d operations", maxBatchOps), http.StatusBadRequest)
+		return
+	}
+
+	var writes []int
+	for i := range ops {
+		op := &ops[i]
+		if op.Bucket == "" {
+			op.Bucket = datastore.DefaultBucket
+		}
+		var msg string
+		switch {
+		case op.Op != batchGet && op.Op != batchPut && op.Op != batchDelete:
+			msg = fmt.Sprintf("unknown op %q", op.Op)
+		case !datastore.ValidBucketName(op.Bucket):
+			msg = "invalid bucket"
+		case op.Key == "":
+			msg = "key required"
+		}
+		if msg != "" {
+			httpError(w, fmt.Sprintf("operation %d: %s", i, msg), http.StatusBadRequest)
+			return
+		}
+		if op.Op != batchGet {
+			writes = append(writes, i)
+		}
+	}
+	for _, op := range ops {
+		scope := scopeWrite
+		if op.Op == batchGet {
+			scope = scopeRead
+		}
+		if !permit(w, r, scope, op.Bucket+"/"+op.Key) {
+			return
+		}
+	}
+
+	resp := batchResponse{Committed: true, Results: make([]batchResult, len(ops))}
+	err := writeBatch(r.Context(), ops, writes)
+	var batchErr *datastore.BatchError
+	switch {
+	case errors.As(err, &batchErr):
+		resp.Committed = false
+		for _, i := range writes {
+			resp.Results[i] = batchResult{Status: http.StatusFailedDependency, Code: codeAborted, Error: "batch aborted"}
+		}
+		resp.Results[writes[batchErr.Index]] = batchError(batchErr.Err, "failed to write batch")
+	case err != nil:
+		storeError(w, err, "failed to write batch")
+		return
+	default:
+		for _, i := range writes {
+			resp.Results[i] = batchResult{Status: http.StatusNoContent}
+		}
+	}
+
+	for i, op := range ops {
+		if op.Op != batchGet {
+			continue
+		}
+		value, version, err := db.Bucket(op.Bucket).GetWithSeqContext(r.Context(), op.Key)
+		if err != nil {
+			resp.Results[i] = batchError(err, "failed to read")
+			continue
+		}
+		resp.Results[i] = batchResult{Status: http.StatusOK, Value: &value, Version: version}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func batchError(err error, msg string) batchResult {
+	status, code, message := classifyError(err, msg)
+	return batchResult{Status: status, Code: code, Error: message}
+}
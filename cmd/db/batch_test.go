@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func doBatch(t *testing.T, body string) (int, batchResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleBatch(rec, httptest.NewRequest(http.MethodPost, "/db-batch", strings.NewReader(body)))
+	var resp batchResponse
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	}
+	return rec.Code, resp
+}
+
+func TestBatch(t *testing.T) {
+	prev := db
+	t.Cleanup(func() {
+		db = prev
+	})
+	var err error
+	db, err = datastore.Open(t.TempDir(), 4096)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	require.NoError(t, db.Put("old", "x"))
+
+	code, resp := doBatch(t, `[
+		{"op": "put", "key": "a", "value": "1"},
+		{"op": "put", "bucket": "users", "key": "alice", "value": "admin"},
+		{"op": "delete", "key": "old"},
+		{"op": "get", "key": "a"},
+		{"op": "get", "key": "old"},
+		{"op": "put", "key": "empty"}
+	]`)
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Committed)
+	require.Len(t, resp.Results, 6)
+	assert.Equal(t, http.StatusNoContent, resp.Results[0].Status)
+	assert.Equal(t, http.StatusOK, resp.Results[3].Status)
+	require.NotNil(t, resp.Results[3].Value)
+	assert.Equal(t, "1", *resp.Results[3].Value)
+	assert.Positive(t, resp.Results[3].Version)
+	assert.Equal(t, http.StatusNotFound, resp.Results[4].Status)
+	v, err := db.Get("empty")
+	require.NoError(t, err)
+	assert.Empty(t, v)
+
+	code, resp = doBatch(t, `[
+		{"op": "put", "key": "b", "value": "2"},
+		{"op": "delete", "key": "missing"},
+		{"op": "get", "key": "b"}
+	]`)
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, resp.Committed)
+	assert.Equal(t, http.StatusFailedDependency, resp.Results[0].Status)
+	assert.Equal(t, http.StatusNotFound, resp.Results[1].Status)
+	assert.Equal(t, http.StatusNotFound, resp.Results[2].Status, "aborted put must not be visible")
+
+	code, _ = doBatch(t, `[{"op": "rename", "key": "a"}]`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = doBatch(t, `[{"op": "get", "bucket": "a/b", "key": "a"}]`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = doBatch(t, `{"op": "get"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestBatch_Cluster(t *testing.T) {
+	nodes := newClusterNodes(t, 3)
+	for _, n := range nodes {
+		n.startRaft(t, urls(nodes), 0)
+	}
+	leader := waitClusterLeader(t, nodes)
+
+	prevDb, prevCluster := db, cluster
+	t.Cleanup(func() {
+		db, cluster = prevDb, prevCluster
+	})
+	db, cluster = leader.store, leader.c
+
+	code, resp := doBatch(t, `[
+		{"op": "put", "key": "a", "value": "1"},
+		{"op": "put", "key": "b", "value": "2"},
+		{"op": "delete", "key": "a"}
+	]`)
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Committed)
+	for _, n := range nodes {
+		waitReplicated(t, n.store, datastore.DefaultBucket, "b", "2")
+		_, err := n.store.Get("a")
+		assert.ErrorIs(t, err, datastore.ErrNotFound)
+	}
+
+	code, resp = doBatch(t, `[{"op": "put", "key": "c", "value": "3"}, {"op": "delete", "key": "missing"}]`)
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, resp.Committed)
+	assert.Equal(t, http.StatusNotFound, resp.Results[1].Status)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/bohdanbulakh/kpi-lab5/raft"
+)
+
+// messageBatch - команда Raft з пакетом змін, які застосовуються атомарно під одним індексом журналу.
+const messageBatch = "batch"
+
+// messageSnapshotStart відкриває знімок автомата Raft і містить номер останнього запису бази.
+const messageSnapshotStart = "snapshot-start"
+
+// dbCluster записує зміни через журнал Raft: запит завершується, коли запис підтвердила
+// більшість вузлів і його застосовано до локальної бази. Читання обслуговуються локально,
+// тож на послідовниках вони можуть трохи відставати від лідера.
+type dbCluster struct {
+	db      *datastore.Db
+	node    *raft.Node
+	storage *raft.FileStorage
+}
+
+var cluster *dbCluster
+
+// newDbCluster запускає вузол Raft над store; журнал і знімки зберігаються в dir.
+// cfg має містити ID, Members і Transport.
+func newDbCluster(store *datastore.Db, dir string, cfg raft.Config) (*dbCluster, error) {
+	storage, err := raft.NewFileStorage(dir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Storage = storage
+	cfg.StateMachine = &dbStateMachine{db: store}
+	node, err := raft.New(cfg)
+	if err != nil {
+		_ = storage.Close()
+		return nil, err
+	}
+	return &dbCluster{db: store, node: node, storage: storage}, nil
+}
+
+func (c *dbCluster) close() error {
+	c.node.Shutdown()
+	return c.storage.Close()
+}
+
+// propose додає зміну до журналу і чекає, доки її буде застосовано.
+func (c *dbCluster) propose(ctx context.Context, msg replicationMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.node.Propose(ctx, data)
+}
+
+// redirectToLeader повертає true, якщо цей вузол лідер; інакше відповідає 307 на ту саму
+// адресу лідера або 503, поки лідер невідомий.
+func (c *dbCluster) redirectToLeader(w http.ResponseWriter, r *http.Request) bool {
+	leader := c.node.Leader()
+	switch leader {
+	case c.node.ID():
+		return true
+	case "":
+		httpError(w, "cluster leader is unknown", http.StatusServiceUnavailable)
+	default:
+		http.Redirect(w, r, leader+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	}
+	return false
+}
+
+// dbStateMachine застосовує записи журналу Raft до бази, використовуючи індекс запису як номер
+// послідовності. Тому повторне застосування після перезапуску пропускає вже записані зміни.
+type dbStateMachine struct {
+	db *datastore.Db
+}
+
+// Apply відхиляє запис, якщо його не можна застосувати на жодному вузлі: невідома операція,
+// недопустимий бакет чи розмір або версія, що не збігається. Решта помилок бази - збої вузла,
+// і Raft повторює такий запис, не пропускаючи його.
+func (m *dbStateMachine) Apply(e raft.Entry) (error, error) {
+	if e.Index <= m.db.LastSeq() {
+		return nil, nil
+	}
+	var msg replicationMessage
+	if err := json.Unmarshal(e.Data, &msg); err != nil {
+		return fmt.Errorf("decode entry %d: %w", e.Index, err), nil
+	}
+	if msg.Type == messageBatch {
+		recs := make([]datastore.LogRecord, len(msg.Ops))
+		for i, op := range msg.Ops {
+			rec, err := op.record()
+			if err != nil {
+				return err, nil
+			}
+			if rec.Type != datastore.EventPut && rec.Type != datastore.EventDelete {
+				return fmt.Errorf("cannot apply %s record in a batch", rec.Type), nil
+			}
+			rec.Seq = e.Index
+			recs[i] = rec
+		}
+		return applyResult(m.db.ApplyBatch(context.Background(), recs))
+	}
+	rec, err := msg.record()
+	if err != nil {
+		return err, nil
+	}
+	if msg.Expect != nil {
+		var current uint64
+		if versions := m.db.Bucket(rec.Bucket).Versions(rec.Key); len(versions) > 0 {
+			current = versions[len(versions)-1]
+		}
+		if current != *msg.Expect {
+			return datastore.ErrVersionMismatch, nil
+		}
+	}
+	rec.Seq = e.Index
+	return applyResult(m.db.Apply(context.Background(), rec))
+}
+
+// applyResult розділяє помилку бази на детерміновану відмову і збій вузла.
+func applyResult(err error) (error, error) {
+	for _, rejected := range []error{datastore.ErrInvalidBucket, datastore.ErrKeyTooLarge, datastore.ErrRecordTooLarge} {
+		if errors.Is(err, rejected) {
+			return err, nil
+		}
+	}
+	return nil, err
+}
+
+// Snapshot записує знімок у форматі /replication/snapshot з додатковим першим рядком snapshot-start.
+// Raft не застосовує записи під час знімка, тож LastSeq не змінюється до його завершення.
+func (m *dbStateMachine) Snapshot(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(replicationMessage{Seq: m.db.LastSeq(), Type: messageSnapshotStart}); err != nil {
+		return err
+	}
+	seq, err := m.db.Snapshot(func(rec datastore.LogRecord) error {
+		return enc.Encode(newRecordMessage(rec))
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Encode(replicationMessage{Seq: seq, Type: messageSnapshotEnd})
+}
+
+// Restore пропускає знімок, якщо база вже містить усі його зміни, як буває після перезапуску вузла.
+func (m *dbStateMachine) Restore(r io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var start replicationMessage
+	if err := dec.Decode(&start); err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	if start.Type != messageSnapshotStart {
+		return fmt.Errorf("read snapshot: unexpected %q message", start.Type)
+	}
+	if m.db.LastSeq() >= start.Seq {
+		return nil
+	}
+	_, err := restoreSnapshot(context.Background(), m.db, dec)
+	return err
+}
+
+// putValue, swapValue, deleteValue і dropBucket записують зміну локально або, в режимі кластера, через Raft.
+// Записи з журналу Raft база застосовує з призначеним seq і не обмежує квотами, тож у кластері
+// квота перевіряється до запису в журнал.
+func putValue(ctx context.Context, bucket *datastore.Bucket, key string, value []byte) error {
+	if cluster == nil {
+		return bucket.PutContext(ctx, key, string(value))
+	}
+	if err := bucket.CheckQuota(key, int64(len(value))); err != nil {
+		return err
+	}
+	return cluster.propose(ctx, replicationMessage{Type: datastore.EventPut.String(), Bucket: bucket.Name(), Key: key, Value: value})
+}
+
+// swapValue у кластері перевіряє версію під час застосування запису, тож результат однаковий на всіх вузлах.
+func swapValue(ctx context.Context, bucket *datastore.Bucket, key string, version uint64, value []byte) error {
+	if cluster == nil {
+		return bucket.CompareAndSwapContext(ctx, key, version, string(value))
+	}
+	if err := bucket.CheckQuota(key, int64(len(value))); err != nil {
+		return err
+	}
+	return cluster.propose(ctx, replicationMessage{Type: datastore.EventPut.String(), Bucket: bucket.Name(), Key: key, Value: value, Expect: &version})
+}
+
+// deleteValue у кластері перевіряє наявність ключа до запису в журнал, щоб повернути ErrNotFound.
+func deleteValue(ctx context.Context, bucket *datastore.Bucket, key string) error {
+	if cluster == nil {
+		return bucket.DeleteContext(ctx, key)
+	}
+	if _, err := bucket.GetContext(ctx, key); err != nil {
+		return err
+	}
+	return cluster.propose(ctx, replicationMessage{Type: datastore.EventDelete.String(), Bucket: bucket.Name(), Key: key})
+}
+
+// writeBatch записує операції ops з індексами writes одним пакетом. У кластері відсутні ключі
+// і квоти перевіряються до запису в журнал, як у deleteValue, для кожного put окремо.
+func writeBatch(ctx context.Context, ops []batchOp, writes []int) error {
+	if len(writes) == 0 {
+		return nil
+	}
+	if cluster == nil {
+		var batch datastore.Batch
+		for _, i := range writes {
+			if ops[i].Op == batchDelete {
+				batch.Delete(ops[i].Bucket, ops[i].Key)
+			} else {
+				batch.Put(ops[i].Bucket, ops[i].Key, ops[i].Value)
+			}
+		}
+		return db.WriteBatch(ctx, &batch)
+	}
+
+	msg := replicationMessage{Type: messageBatch}
+	exists := make(map[[2]string]bool)
+	for n, i := range writes {
+		op := ops[i]
+		k := [2]string{op.Bucket, op.Key}
+		found, ok := exists[k]
+		if !ok {
+			_, err := db.Bucket(op.Bucket).GetContext(ctx, op.Key)
+			if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+				return err
+			}
+			found = err == nil
+		}
+		if op.Op == batchDelete && !found {
+			return &datastore.BatchError{Index: n, Err: datastore.ErrNotFound}
+		}
+		exists[k] = op.Op == batchPut
+		if op.Op == batchPut {
+			if err := db.Bucket(op.Bucket).CheckQuota(op.Key, int64(len(op.Value))); err != nil {
+				return &datastore.BatchError{Index: n, Err: err}
+			}
+		}
+
+		typ := datastore.EventPut
+		if op.Op == batchDelete {
+			typ = datastore.EventDelete
+		}
+		msg.Ops = append(msg.Ops, replicationMessage{Type: typ.String(), Bucket: op.Bucket, Key: op.Key, Value: []byte(op.Value)})
+	}
+	return cluster.propose(ctx, msg)
+}
+
+func dropBucket(ctx context.Context, name string) error {
+	if cluster == nil {
+		return db.DropBucket(name)
+	}
+	if !datastore.ValidBucketName(name) {
+		return datastore.ErrInvalidBucket
+	}
+	found := false
+	for _, bucket := range db.Buckets() {
+		found = found || bucket == name
+	}
+	if !found {
+		return datastore.ErrNotFound
+	}
+	return cluster.propose(ctx, replicationMessage{Type: datastore.EventDropBucket.String(), Bucket: name})
+}
+
+func handleRaftStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(cluster.node.Status())
+}
+
+// handleRaftMembers: GET - склад кластера, POST {"id": url} - додати вузол, DELETE ?id=url - вилучити.
+func handleRaftMembers(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(cluster.node.Status().Members)
+		return
+	case http.MethodPost:
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+			httpError(w, "id required", http.StatusBadRequest)
+			return
+		}
+		err = cluster.node.AddMember(r.Context(), strings.TrimSuffix(req.ID, "/"))
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			httpError(w, "id required", http.StatusBadRequest)
+			return
+		}
+		err = cluster.node.RemoveMember(r.Context(), id)
+	default:
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if errors.Is(err, raft.ErrMembershipChanging) {
+		httpError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		storeError(w, err, "failed to change membership")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/bohdanbulakh/kpi-lab5/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clusterNode struct {
+	url   string
+	mux   *http.ServeMux
+	store *datastore.Db
+	c     *dbCluster
+}
+
+// newClusterNodes запускає HTTP-сервери вузлів; самі вузли Raft стартують через startRaft.
+func newClusterNodes(t *testing.T, n int) []*clusterNode {
+	t.Helper()
+	var nodes []*clusterNode
+	for i := 0; i < n; i++ {
+		mux := http.NewServeMux()
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		store, err := datastore.Open(t.TempDir(), 1024)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close()
+		})
+		nodes = append(nodes, &clusterNode{url: srv.URL, mux: mux, store: store})
+	}
+	return nodes
+}
+
+func (n *clusterNode) startRaft(t *testing.T, members []string, threshold uint64) {
+	t.Helper()
+	c, err := newDbCluster(n.store, t.TempDir(), raft.Config{
+		ID:                n.url,
+		Members:           members,
+		ElectionTimeout:   150 * time.Millisecond,
+		HeartbeatInterval: 30 * time.Millisecond,
+		SnapshotThreshold: threshold,
+		Transport:         raft.NewHTTPTransport(&http.Client{}),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = c.close()
+	})
+	n.c = c
+	n.mux.Handle("/raft/", c.node.Handler())
+}
+
+func urls(nodes []*clusterNode) []string {
+	var res []string
+	for _, n := range nodes {
+		res = append(res, n.url)
+	}
+	return res
+}
+
+func waitClusterLeader(t *testing.T, nodes []*clusterNode) *clusterNode {
+	t.Helper()
+	var leader *clusterNode
+	require.Eventually(t, func() bool {
+		for _, n := range nodes {
+			if n.c != nil && n.c.node.Status().State == raft.Leader.String() {
+				leader = n
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond, "no leader elected")
+	return leader
+}
+
+func putMessage(bucket, key, value string) replicationMessage {
+	return replicationMessage{Type: datastore.EventPut.String(), Bucket: bucket, Key: key, Value: []byte(value)}
+}
+
+func TestCluster_ReplicatesWrites(t *testing.T) {
+	nodes := newClusterNodes(t, 3)
+	for _, n := range nodes {
+		n.startRaft(t, urls(nodes), 0)
+	}
+	leader := waitClusterLeader(t, nodes)
+	ctx := context.Background()
+
+	require.NoError(t, leader.c.propose(ctx, putMessage(datastore.DefaultBucket, "k1", "v1")))
+	require.NoError(t, leader.c.propose(ctx, putMessage("users", "alice", "admin")))
+	require.NoError(t, leader.c.propose(ctx, replicationMessage{Type: datastore.EventDelete.String(), Bucket: datastore.DefaultBucket, Key: "k1"}))
+	require.NoError(t, leader.c.propose(ctx, putMessage(datastore.DefaultBucket, "k2", "v2")))
+
+	_, leaderSeq, err := leader.store.GetWithSeq("k2")
+	require.NoError(t, err)
+	for _, n := range nodes {
+		waitReplicated(t, n.store, "users", "alice", "admin")
+		waitReplicated(t, n.store, datastore.DefaultBucket, "k2", "v2")
+		_, err := n.store.Get("k1")
+		assert.ErrorIs(t, err, datastore.ErrNotFound)
+		_, seq, err := n.store.GetWithSeq("k2")
+		require.NoError(t, err)
+		assert.Equal(t, leaderSeq, seq, "all nodes must use the raft index as the sequence number")
+	}
+}
+
+func TestCluster_SnapshotCatchUp(t *testing.T) {
+	nodes := newClusterNodes(t, 3)
+	members := urls(nodes)
+	// двох вузлів достатньо для кворуму, третій наздожене кластер зі знімка
+	nodes[0].startRaft(t, members, 4)
+	nodes[1].startRaft(t, members, 4)
+	leader := waitClusterLeader(t, nodes)
+
+	lagging := nodes[2]
+	require.NoError(t, lagging.store.Apply(context.Background(), datastore.LogRecord{
+		Seq: 1, Type: datastore.EventPut, Bucket: datastore.DefaultBucket, Key: "stale", Value: []byte("x"),
+	}))
+	for i := 0; i < 12; i++ {
+		require.NoError(t, leader.c.propose(context.Background(), putMessage(datastore.DefaultBucket, fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i))))
+	}
+	require.Eventually(t, func() bool {
+		return leader.c.node.Status().SnapshotIndex > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lagging.startRaft(t, members, 4)
+	waitReplicated(t, lagging.store, datastore.DefaultBucket, "key-11", "value-11")
+	for i := 0; i < 12; i++ {
+		v, err := lagging.store.Get(fmt.Sprintf("key-%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), v)
+	}
+	_, err := lagging.store.Get("stale")
+	assert.True(t, errors.Is(err, datastore.ErrNotFound), "stale key must be removed, got %v", err)
+}
+
+func TestCluster_Handlers(t *testing.T) {
+	nodes := newClusterNodes(t, 3)
+	for _, n := range nodes {
+		n.startRaft(t, urls(nodes), 0)
+	}
+	leader := waitClusterLeader(t, nodes)
+	var other *clusterNode
+	for _, n := range nodes {
+		if n != leader {
+			other = n
+		}
+	}
+	require.Eventually(t, func() bool {
+		return other.c.node.Leader() == leader.url
+	}, 5*time.Second, 10*time.Millisecond)
+
+	prevDb, prevCluster := db, cluster
+	t.Cleanup(func() {
+		db, cluster = prevDb, prevCluster
+	})
+
+	db, cluster = other.store, other.c
+	req := httptest.NewRequest(http.MethodPost, "/db/users/bob", strings.NewReader(`{"value":"guest"}`))
+	rec := httptest.NewRecorder()
+	readOnly(handleDb)(rec, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, leader.url+"/db/users/bob", rec.Header().Get("Location"))
+
+	db, cluster = leader.store, leader.c
+	rec = httptest.NewRecorder()
+	readOnly(handleDb)(rec, httptest.NewRequest(http.MethodPost, "/db/users/bob", strings.NewReader(`{"value":"guest"}`)))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	waitReplicated(t, other.store, "users", "bob", "guest")
+
+	rec = httptest.NewRecorder()
+	readOnly(handleDb)(rec, httptest.NewRequest(http.MethodDelete, "/db/users/missing", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	readOnly(handleDb)(rec, httptest.NewRequest(http.MethodDelete, "/db/users/bob", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Eventually(t, func() bool {
+		_, err := other.store.Bucket("users").Get("bob")
+		return errors.Is(err, datastore.ErrNotFound)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDbStateMachine_ApplyErrors(t *testing.T) {
+	store, err := datastore.Open(t.TempDir(), 1024)
+	require.NoError(t, err)
+	m := &dbStateMachine{db: store}
+	entry := func(index uint64, msg replicationMessage) raft.Entry {
+		data, err := json.Marshal(msg)
+		require.NoError(t, err)
+		return raft.Entry{Index: index, Type: raft.EntryCommand, Data: data}
+	}
+
+	rejected, err := m.Apply(entry(1, putMessage(datastore.DefaultBucket, "k", "v")))
+	require.NoError(t, err)
+	require.NoError(t, rejected)
+
+	var version uint64 = 7
+	stale := putMessage(datastore.DefaultBucket, "k", "v2")
+	stale.Expect = &version
+	rejected, err = m.Apply(entry(2, stale))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, rejected, datastore.ErrVersionMismatch)
+	rejected, err = m.Apply(entry(3, putMessage("bad/bucket", "k", "v")))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, rejected, datastore.ErrInvalidBucket)
+	rejected, err = m.Apply(raft.Entry{Index: 4, Type: raft.EntryCommand, Data: []byte("{")})
+	assert.NoError(t, err)
+	assert.Error(t, rejected)
+
+	// збій бази - не відмова: Raft має повторити запис, а не вважати його застосованим
+	require.NoError(t, store.Close())
+	rejected, err = m.Apply(entry(5, putMessage(datastore.DefaultBucket, "k", "v3")))
+	assert.NoError(t, rejected)
+	assert.ErrorIs(t, err, datastore.ErrClosed)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/bohdanbulakh/kpi-lab5/raft"
+)
+
+// Коди помилок у полі code відповіді; клієнти розрізняють за ними випадки з однаковим статусом.
+const (
+	codeBadRequest       = "bad_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeConflict         = "conflict"
+	codeAborted          = "aborted"
+	codeGone             = "gone"
+	codeVersionMismatch  = "version_mismatch"
+	codeTooLarge         = "too_large"
+	codeRateLimited      = "rate_limited"
+	codeQuotaExceeded    = "quota_exceeded"
+	codeInternal         = "internal"
+	codeCorrupted        = "data_corrupted"
+	codeClosing          = "closing"
+	codeUnavailable      = "unavailable"
+)
+
+// apiError - тіло всіх відповідей з помилкою.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func jsonError(w http.ResponseWriter, status int, code, message string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("ETag")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiError{Code: code, Message: message})
+}
+
+// httpError - заміна http.Error, що відповідає у форматі apiError з кодом за статусом.
+func httpError(w http.ResponseWriter, message string, status int) {
+	code := codeInternal
+	switch status {
+	case http.StatusBadRequest:
+		code = codeBadRequest
+	case http.StatusUnauthorized:
+		code = codeUnauthorized
+	case http.StatusForbidden:
+		code = codeForbidden
+	case http.StatusNotFound:
+		code = codeNotFound
+	case http.StatusMethodNotAllowed:
+		code = codeMethodNotAllowed
+	case http.StatusConflict:
+		code = codeConflict
+	case http.StatusGone:
+		code = codeGone
+	case http.StatusPreconditionFailed:
+		code = codeVersionMismatch
+	case http.StatusRequestEntityTooLarge:
+		code = codeTooLarge
+	case http.StatusTooManyRequests:
+		code = codeRateLimited
+	case http.StatusInsufficientStorage:
+		code = codeQuotaExceeded
+	case http.StatusServiceUnavailable:
+		code = codeUnavailable
+	}
+	jsonError(w, status, code, message)
+}
+
+// storeError перетворює помилку бази на відповідь. msg описує операцію і потрапляє у відповідь
+// лише для непередбачених помилок, щоб не розкривати внутрішні подробиці.
+func storeError(w http.ResponseWriter, err error, msg string) {
+	status, code, message := classifyError(err, msg)
+	if status == http.StatusInsufficientStorage {
+		// місце звільняється лише після видалень, тож клієнту пропонується почекати quotaRetryAfter
+		setRetryAfter(w, quotaRetryAfter)
+	}
+	jsonError(w, status, code, message)
+}
+
+// quotaRetryAfter - значення Retry-After для відповідей 507 (-quota-retry-after).
+var quotaRetryAfter = time.Minute
+
+// classifyError визначає статус, код і повідомлення для помилки бази. Пошкодження даних
+// і непередбачені помилки записуються в журнал.
+func classifyError(err error, msg string) (int, string, string) {
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return http.StatusNotFound, codeNotFound, "record does not exist"
+	case errors.Is(err, datastore.ErrHashMismatch):
+		alertCorruption(msg, err)
+		return http.StatusInternalServerError, codeCorrupted, "stored value failed the integrity check"
+	case errors.Is(err, datastore.ErrRecordTooLarge):
+		return http.StatusRequestEntityTooLarge, codeTooLarge, "value is too large"
+	case errors.Is(err, datastore.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage, codeQuotaExceeded, "bucket storage quota exceeded"
+	case errors.Is(err, datastore.ErrKeyTooLarge):
+		return http.StatusBadRequest, codeBadRequest, "key is too large"
+	case errors.Is(err, datastore.ErrInvalidBucket):
+		return http.StatusBadRequest, codeBadRequest, "invalid bucket"
+	case errors.Is(err, datastore.ErrVersionMismatch):
+		return http.StatusPreconditionFailed, codeVersionMismatch, "version does not match"
+	case errors.Is(err, datastore.ErrClosed), errors.Is(err, raft.ErrShutdown):
+		return http.StatusServiceUnavailable, codeClosing, "datastore is closed"
+	case errors.Is(err, raft.ErrNotLeader), errors.Is(err, raft.ErrLeadershipLost):
+		return http.StatusServiceUnavailable, codeUnavailable, "cluster leader changed, retry the request"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, codeUnavailable, "request timed out"
+	}
+	log.Printf("%s: %v", msg, err)
+	return http.StatusInternalServerError, codeInternal, msg
+}
+
+// alertCorruption пише в журнал пошкодження даних з окремою міткою, за якою на нього налаштовують сповіщення.
+func alertCorruption(op string, err error) {
+	log.Printf("ALERT data corruption: %s: %v", op, err)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func doDb(t *testing.T, method, path, contentType, body string) (int, apiError) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	handleDb(rec, req)
+	var resp apiError
+	if rec.Code >= 400 {
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	}
+	return rec.Code, resp
+}
+
+func TestErrors_Envelope(t *testing.T) {
+	prev := db
+	t.Cleanup(func() {
+		db = prev
+	})
+	dir := t.TempDir()
+	var err error
+	db, err = datastore.OpenWithOptions(dir, datastore.Options{SegmentSize: 4096, MaxValueSize: 16})
+	require.NoError(t, err)
+
+	code, resp := doDb(t, http.MethodGet, "/db/missing", "", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, apiError{Code: codeNotFound, Message: "record does not exist"}, resp)
+
+	code, resp = doDb(t, http.MethodPost, "/db/big", "application/json", `{"value": "`+strings.Repeat("x", 17)+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, codeTooLarge, resp.Code)
+
+	code, resp = doDb(t, http.MethodPost, "/db/big", octetStream, strings.Repeat("x", 17))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, codeTooLarge, resp.Code)
+
+	code, resp = doDb(t, http.MethodPost, "/db/k", "application/json", "{")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, codeBadRequest, resp.Code)
+
+	code, resp = doDb(t, http.MethodPut, "/db/k", "", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	assert.Equal(t, codeMethodNotAllowed, resp.Code)
+
+	// пошкоджуємо значення прямо у файлі сегмента
+	require.NoError(t, db.Put("broken", "value-1"))
+	path := filepath.Join(dir, "current-data")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	i := bytes.Index(data, []byte("value-1"))
+	require.GreaterOrEqual(t, i, 0)
+	data[i] = 'V'
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	code, resp = doDb(t, http.MethodGet, "/db/broken", "", "")
+	assert.Equal(t, http.StatusInternalServerError, code, "corruption must not look like a missing key")
+	assert.Equal(t, codeCorrupted, resp.Code)
+
+	require.NoError(t, db.Close())
+	code, resp = doDb(t, http.MethodPost, "/db/k", "application/json", `{"value": "v"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, codeClosing, resp.Code)
+	code, resp = doDb(t, http.MethodGet, "/db/k", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, codeClosing, resp.Code)
+}
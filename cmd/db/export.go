@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+)
+
+const (
+	jsonLines = "application/jsonl"
+
+	importOverwrite = "overwrite"
+	importSkip      = "skip"
+
+	encodingBase64 = "base64"
+)
+
+// exportRecord - рядок JSON Lines у /db-export і /db-import; при імпорті version ігнорується,
+// а порожній bucket означає бакет за замовчуванням. JSON-рядок не передає байти, що не є UTF-8,
+// тож такі ключ і значення експортуються в base64 з encoding "base64".
+type exportRecord struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+	Version  uint64 `json:"version,omitempty"`
+}
+
+func newExportRecord(rec datastore.LogRecord) exportRecord {
+	res := exportRecord{Bucket: rec.Bucket, Key: rec.Key, Value: string(rec.Value), Version: rec.Seq}
+	if !utf8.ValidString(res.Key) || !utf8.Valid(rec.Value) {
+		res.Encoding = encodingBase64
+		res.Key = base64.StdEncoding.EncodeToString([]byte(rec.Key))
+		res.Value = base64.StdEncoding.EncodeToString(rec.Value)
+	}
+	return res
+}
+
+// decode повертає запис з ключем і значенням у вихідному вигляді.
+func (r *exportRecord) decode() error {
+	switch r.Encoding {
+	case "":
+		return nil
+	case encodingBase64:
+		key, err := base64.StdEncoding.DecodeString(r.Key)
+		if err != nil {
+			return fmt.Errorf("invalid base64 key")
+		}
+		value, err := base64.StdEncoding.DecodeString(r.Value)
+		if err != nil {
+			return fmt.Errorf("invalid base64 value")
+		}
+		r.Key, r.Value, r.Encoding = string(key), string(value), ""
+		return nil
+	}
+	return fmt.Errorf("unknown encoding %q", r.Encoding)
+}
+
+type importResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// handleExport: GET /db-export?bucket=&prefix= передає живі ключі у форматі JSON Lines,
+// впорядковані за бакетом і ключем. Без bucket експортуються всі бакети. Вивід узгоджений
+// на момент початку запиту; номер послідовності знімка приходить у трейлері X-Export-Seq.
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	bucket, prefix := r.URL.Query().Get("bucket"), r.URL.Query().Get("prefix")
+	if bucket == "" && prefix != "" {
+		httpError(w, "prefix requires bucket", http.StatusBadRequest)
+		return
+	}
+	if bucket != "" && !datastore.ValidBucketName(bucket) {
+		httpError(w, "invalid bucket", http.StatusBadRequest)
+		return
+	}
+	scopeKey := ""
+	if bucket != "" {
+		scopeKey = bucket + "/" + prefix
+	}
+	if !permit(w, r, scopeRead, scopeKey) {
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonLines)
+	w.Header().Set("Trailer", "X-Export-Seq")
+	enc := json.NewEncoder(w)
+	started := false
+	seq, err := db.Snapshot(func(rec datastore.LogRecord) error {
+		if bucket != "" && (rec.Bucket != bucket || !strings.HasPrefix(rec.Key, prefix)) {
+			return nil
+		}
+		started = true
+		return enc.Encode(newExportRecord(rec))
+	})
+	if err != nil {
+		if !started {
+			storeError(w, err, "failed to export")
+			return
+		}
+		// частину рядків уже відправлено, тож лише обриваємо відповідь
+		if errors.Is(err, datastore.ErrHashMismatch) {
+			alertCorruption("export", err)
+		} else {
+			log.Printf("export: %v", err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Set("X-Export-Seq", fmt.Sprint(seq))
+}
+
+// handleImport: POST /db-import?mode=overwrite|skip читає JSON Lines і записує їх пакетами
+// до maxBatchOps записів. У режимі skip ключі, що вже існують, пропускаються; перевірка
+// виконується перед записом пакета, тож ключ, створений іншим клієнтом у цей момент, буде перезаписано.
+// Пакети, записані до помилки, лишаються в базі, а повідомлення вказує номер запису і скільки записано.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = importOverwrite
+	}
+	if mode != importOverwrite && mode != importSkip {
+		httpError(w, fmt.Sprintf("unknown mode %q, expected overwrite or skip", mode), http.StatusBadRequest)
+		return
+	}
+
+	im := &importer{
+		r:    r,
+		skip: mode == importSkip,
+		// пакет має вміститися в сегмент, тож більші записи записуються окремо
+		maxBytes: db.Options().SegmentSize - db.BatchOverhead(),
+		pending:  make(map[[2]string]bool),
+	}
+	dec := json.NewDecoder(r.Body)
+	for n := 1; ; n++ {
+		var rec exportRecord
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			im.fail(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("record %d: invalid JSON", n))
+			return
+		}
+		if err := rec.decode(); err != nil {
+			im.fail(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("record %d: %v", n, err))
+			return
+		}
+		if rec.Bucket == "" {
+			rec.Bucket = datastore.DefaultBucket
+		}
+		if !datastore.ValidBucketName(rec.Bucket) || rec.Key == "" {
+			im.fail(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("record %d: bucket and key required", n))
+			return
+		}
+		if !permit(w, r, scopeWrite, rec.Bucket+"/"+rec.Key) {
+			return
+		}
+		if err := im.add(n, rec); err != nil {
+			im.storeError(w, err)
+			return
+		}
+	}
+	if err := im.flush(); err != nil {
+		im.storeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(im.res)
+}
+
+// importer накопичує записи імпорту в пакети.
+type importer struct {
+	r        *http.Request
+	skip     bool
+	maxBytes int64
+	res      importResult
+
+	ops   []batchOp
+	bytes int64
+	// first - номер першого запису пакета в тілі запиту
+	first int
+	// pending - ключі пакета, щоб у режимі skip повтор ключа в тілі теж пропускався
+	pending map[[2]string]bool
+}
+
+// importError вказує номер запису, на якому зупинився імпорт.
+type importError struct {
+	record int
+	err    error
+}
+
+func (e *importError) Error() string {
+	return fmt.Sprintf("record %d: %v", e.record, e.err)
+}
+
+func (im *importer) add(n int, rec exportRecord) error {
+	k := [2]string{rec.Bucket, rec.Key}
+	if im.skip && (im.pending[k] || db.Bucket(rec.Bucket).Exists(rec.Key)) {
+		im.res.Skipped++
+		return nil
+	}
+	size := db.RecordSize(rec.Bucket, rec.Key, int64(len(rec.Value)))
+	if len(im.ops) == maxBatchOps || im.bytes+size > im.maxBytes {
+		if err := im.flush(); err != nil {
+			return err
+		}
+	}
+	if size > im.maxBytes {
+		if err := putValue(im.r.Context(), db.Bucket(rec.Bucket), rec.Key, []byte(rec.Value)); err != nil {
+			return &importError{record: n, err: err}
+		}
+		im.res.Imported++
+		return nil
+	}
+	if len(im.ops) == 0 {
+		im.first = n
+	}
+	im.pending[k] = true
+	im.ops = append(im.ops, batchOp{Op: batchPut, Bucket: rec.Bucket, Key: rec.Key, Value: rec.Value})
+	im.bytes += size
+	return nil
+}
+
+func (im *importer) flush() error {
+	if len(im.ops) == 0 {
+		return nil
+	}
+	writes := make([]int, len(im.ops))
+	for i := range writes {
+		writes[i] = i
+	}
+	err := writeBatch(im.r.Context(), im.ops, writes)
+	var batchErr *datastore.BatchError
+	if errors.As(err, &batchErr) {
+		return &importError{record: im.first + batchErr.Index, err: batchErr.Err}
+	}
+	if err != nil {
+		return &importError{record: im.first, err: err}
+	}
+	im.res.Imported += len(im.ops)
+	im.ops, im.bytes = im.ops[:0], 0
+	clear(im.pending)
+	return nil
+}
+
+func (im *importer) storeError(w http.ResponseWriter, err error) {
+	var impErr *importError
+	if !errors.As(err, &impErr) {
+		storeError(w, err, "failed to import")
+		return
+	}
+	status, code, msg := classifyError(impErr.err, "failed to import")
+	if status == http.StatusInsufficientStorage {
+		setRetryAfter(w, quotaRetryAfter)
+	}
+	im.fail(w, status, code, fmt.Sprintf("record %d: %s", impErr.record, msg))
+}
+
+func (im *importer) fail(w http.ResponseWriter, status int, code, msg string) {
+	jsonError(w, status, code, fmt.Sprintf("%s (%d records imported, %d skipped)", msg, im.res.Imported, im.res.Skipped))
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDb(t *testing.T, opts datastore.Options) {
+	t.Helper()
+	prev := db
+	t.Cleanup(func() {
+		db = prev
+	})
+	var err error
+	db, err = datastore.OpenWithOptions(t.TempDir(), opts)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+}
+
+func doImport(t *testing.T, mode, body string) (int, importResult, apiError) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleImport(rec, httptest.NewRequest(http.MethodPost, "/db-import?mode="+mode, strings.NewReader(body)))
+	var res importResult
+	var apiErr apiError
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+	} else {
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&apiErr))
+	}
+	return rec.Code, res, apiErr
+}
+
+func TestExportImport(t *testing.T) {
+	openTestDb(t, datastore.Options{SegmentSize: 4096})
+	require.NoError(t, db.Put("a", "1"))
+	require.NoError(t, db.Bucket("users").Put("alice", `{"name": "Alice"}`))
+	require.NoError(t, db.Bucket("users").Put("bob", "2"))
+
+	rec := httptest.NewRecorder()
+	handleExport(rec, httptest.NewRequest(http.MethodGet, "/db-export", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, jsonLines, rec.Header().Get("Content-Type"))
+	exported := rec.Body.String()
+	lines := strings.Split(strings.TrimSpace(exported), "\n")
+	require.Len(t, lines, 3)
+	var first exportRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, exportRecord{Bucket: "default", Key: "a", Value: "1", Version: first.Version}, first)
+	assert.Positive(t, first.Version)
+	assert.NotEmpty(t, rec.Result().Trailer.Get("X-Export-Seq"))
+
+	rec = httptest.NewRecorder()
+	handleExport(rec, httptest.NewRequest(http.MethodGet, "/db-export?bucket=users&prefix=b", nil))
+	assert.Equal(t, 1, strings.Count(rec.Body.String(), "\n"))
+	assert.Contains(t, rec.Body.String(), `"key":"bob"`)
+
+	// імпорт у нову базу відтворює ті самі ключі
+	openTestDb(t, datastore.Options{SegmentSize: 4096})
+	require.NoError(t, db.Put("a", "old"))
+	code, res, _ := doImport(t, importSkip, exported)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, importResult{Imported: 2, Skipped: 1}, res)
+	v, err := db.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "old", v, "skip mode must keep existing keys")
+	v, err = db.Bucket("users").Get("alice")
+	require.NoError(t, err)
+	assert.Equal(t, `{"name": "Alice"}`, v)
+
+	code, res, _ = doImport(t, "", exported)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, importResult{Imported: 3}, res)
+	v, err = db.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", v)
+
+	code, _, apiErr := doImport(t, "", `{"key": "x", "value": "1"}`+"\n"+`{"key": `)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, apiErr.Message, "record 2")
+	code, _, _ = doImport(t, "merge", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestExportImport_Binary(t *testing.T) {
+	openTestDb(t, datastore.Options{SegmentSize: 4096})
+	raw := "\x00\xff\xfe binary \x80"
+	require.NoError(t, db.Put("bin", raw))
+	require.NoError(t, db.Put("key-\xff", "text"))
+	require.NoError(t, db.Put("text", "звичайний текст"))
+
+	rec := httptest.NewRecorder()
+	handleExport(rec, httptest.NewRequest(http.MethodGet, "/db-export", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	exported := rec.Body.String()
+	assert.Equal(t, 2, strings.Count(exported, `"encoding":"base64"`))
+	assert.Contains(t, exported, `"value":"звичайний текст"`)
+
+	openTestDb(t, datastore.Options{SegmentSize: 4096})
+	code, res, _ := doImport(t, importOverwrite, exported)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, importResult{Imported: 3}, res)
+	for key, want := range map[string]string{"bin": raw, "key-\xff": "text", "text": "звичайний текст"} {
+		v, err := db.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, want, v, "key %q", key)
+	}
+
+	code, _, apiErr := doImport(t, "", `{"key": "x", "value": "!!", "encoding": "base64"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, apiErr.Message, "record 1: invalid base64")
+	code, _, apiErr = doImport(t, "", `{"key": "x", "value": "1", "encoding": "hex"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, apiErr.Message, "unknown encoding")
+}
+
+func TestImport_Batches(t *testing.T) {
+	openTestDb(t, datastore.Options{SegmentSize: 4096, Quotas: datastore.QuotaPolicy{Buckets: map[string]int64{"small": 200}}})
+
+	var body strings.Builder
+	for i := 0; i < 2500; i++ {
+		fmt.Fprintf(&body, `{"bucket": "bulk", "key": "k%04d", "value": "%d"}`+"\n", i, i)
+	}
+	fmt.Fprintf(&body, `{"bucket": "bulk", "key": "large", "value": "%s"}`+"\n", strings.Repeat("x", 3000))
+	code, res, _ := doImport(t, importSkip, body.String())
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2501, res.Imported)
+	assert.Len(t, db.Bucket("bulk").Keys(""), 2501)
+	assert.Greater(t, len(db.Stats().Segments), 2, "batches must be split to fit into segments")
+
+	body.Reset()
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&body, `{"bucket": "small", "key": "k%d", "value": "%s"}`+"\n", i, strings.Repeat("v", 50))
+	}
+	code, _, apiErr := doImport(t, "", body.String())
+	assert.Equal(t, http.StatusInsufficientStorage, code)
+	assert.Equal(t, codeQuotaExceeded, apiErr.Code)
+	assert.Contains(t, apiErr.Message, "0 records imported")
+
+	// вихідний файл читається як JSON Lines з будь-якого джерела
+	rec := httptest.NewRecorder()
+	handleExport(rec, httptest.NewRequest(http.MethodGet, "/db-export?bucket=bulk", nil))
+	scanner := bufio.NewScanner(rec.Body)
+	scanner.Buffer(nil, 1<<20)
+	n := 0
+	for scanner.Scan() {
+		n++
+	}
+	assert.Equal(t, 2501, n)
+}
@@ -1,68 +1,538 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/bohdanbulakh/kpi-lab5/raft"
+	"github.com/bohdanbulakh/kpi-lab5/signal"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
+const octetStream = "application/octet-stream"
+
+const shutdownTimeout = 10 * time.Second
+
 var db *datastore.Db
 
+// shuttingDown закривається на початку зупинки сервера, щоб завершити довгі SSE-з'єднання.
+var shuttingDown = make(chan struct{})
+
 func main() {
-	dataDir := "./data"
-	_ = os.MkdirAll(dataDir, 0o755)
+	dbOpts := bindDbFlags(flag.CommandLine)
+	leaderURL := flag.String("replicate-from", "", "leader URL (e.g. http://db:8081); when set, the node is a read-only replica")
+	replicaID := flag.String("replica-id", "", "name the replica reports to the leader (defaults to the hostname)")
+	raftID := flag.String("raft-id", "", "URL other cluster nodes use to reach this node (e.g. http://db1:8081); enables Raft cluster mode")
+	raftPeers := flag.String("raft-peers", "", "comma-separated URLs of the initial cluster members including this node; empty when joining an existing cluster")
+	respAddr := flag.String("resp-addr", "", "TCP address for the Redis protocol frontend (e.g. :6379); disabled when empty")
+	respBucket := flag.String("resp-bucket", datastore.DefaultBucket, "bucket that keys of the Redis protocol frontend are stored in")
+	raftSnapshot := flag.Uint64("raft-snapshot-threshold", raft.DefaultSnapshotThreshold, "number of applied Raft entries after which the log is compacted into a snapshot")
+	flag.Parse()
+	if err := applyEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		log.Fatalf("invalid environment: %v", err)
+	}
+	if err := dbOpts.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if dbOpts.authConfig != "" {
+		var err error
+		if auth, err = loadAuth(dbOpts.authConfig); err != nil {
+			log.Fatalf("failed to load auth config: %v", err)
+		}
+	}
+	peers := newPeerClient(dbOpts.authToken)
+	if dbOpts.rateLimit > 0 {
+		limiter = newRateLimiter(dbOpts.rateLimit, dbOpts.rateBurst)
+	}
+	quotaRetryAfter = dbOpts.quotaRetryAfter
+
+	if *raftID != "" && *leaderURL != "" {
+		log.Fatalf("-raft-id and -replicate-from cannot be used together")
+	}
+
+	dataDir := dbOpts.dataDir
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		log.Fatalf("failed to create data dir: %v", err)
+	}
 
+	opts := dbOpts.options()
 	var err error
-	db, err = datastore.Open(filepath.Join(dataDir), 10*1024*1024) // 10MB
+	db, err = datastore.OpenWithOptions(dataDir, opts)
 	if err != nil {
 		log.Fatalf("failed to open db: %v", err)
 	}
 
-	http.HandleFunc("/db/", handleDb)
+	if *raftID != "" {
+		var members []string
+		for _, m := range strings.Split(*raftPeers, ",") {
+			if m = strings.TrimSuffix(strings.TrimSpace(m), "/"); m != "" {
+				members = append(members, m)
+			}
+		}
+		cluster, err = newDbCluster(db, filepath.Join(dataDir, "raft"), raft.Config{
+			ID:                strings.TrimSuffix(*raftID, "/"),
+			Members:           members,
+			SnapshotThreshold: *raftSnapshot,
+			Transport:         raft.NewHTTPTransport(peers),
+			Logger:            opts.Logger.With("component", "raft"),
+		})
+		if err != nil {
+			log.Fatalf("failed to start raft node: %v", err)
+		}
+		http.HandleFunc("/raft/", requireScope(scopeAdmin, cluster.node.Handler().ServeHTTP))
+		http.HandleFunc("/raft/status", requireScope(scopeAdmin, handleRaftStatus))
+		http.HandleFunc("/raft/members", requireScope(scopeAdmin, readOnly(handleRaftMembers)))
+		log.Printf("raft node %s, members %v", *raftID, members)
+	}
+
+	var stopFollower context.CancelFunc = func() {}
+	if *leaderURL != "" {
+		if *replicaID == "" {
+			*replicaID, _ = os.Hostname()
+		}
+		follower = newReplicaFollower(db, strings.TrimSuffix(*leaderURL, "/"), *replicaID, dataDir)
+		follower.client = peers
+		var ctx context.Context
+		ctx, stopFollower = context.WithCancel(context.Background())
+		go follower.run(ctx)
+		log.Printf("replicating from %s", *leaderURL)
+	}
+
+	http.HandleFunc("/db/", authenticated(rateLimited(readOnly(handleDb))))
+	http.HandleFunc("/db-buckets", authenticated(rateLimited(readOnly(handleBuckets))))
+	http.HandleFunc("/db-buckets/", authenticated(rateLimited(readOnly(handleBuckets))))
+	http.HandleFunc("/db-batch", authenticated(rateLimited(readOnly(handleBatch))))
+	http.HandleFunc("/db-export", authenticated(handleExport))
+	http.HandleFunc("/db-import", authenticated(rateLimited(readOnly(handleImport))))
+	http.HandleFunc("/db-watch", authenticated(handleWatch))
+	http.HandleFunc("/db-index", authenticated(handleIndex))
+	http.HandleFunc("/db-index/", authenticated(handleIndex))
+	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/stats", requireScope(scopeAdmin, handleStats))
+	http.HandleFunc("/metrics", requireScope(scopeAdmin, handleMetrics))
+	http.HandleFunc("/admin/compact", adminOnly(handleAdminCompact))
+	http.HandleFunc("/admin/jobs/", adminOnly(handleAdminJob))
+	http.HandleFunc("/admin/segments", adminOnly(handleAdminSegments))
+	http.HandleFunc("/admin/stats", adminOnly(handleAdminStats))
+	// репліка отримує всі дані, тож читання журналу вимагає доступу до всіх ключів
+	http.HandleFunc("/replication/log", requireScope(scopeRead, handleReplicationLog))
+	http.HandleFunc("/replication/snapshot", requireScope(scopeRead, handleReplicationSnapshot))
+	http.HandleFunc("/replication/status", requireScope(scopeRead, handleReplicationStatus))
+
+	var resp *respServer
+	if *respAddr != "" {
+		if !datastore.ValidBucketName(*respBucket) {
+			log.Fatalf("invalid -resp-bucket %q", *respBucket)
+		}
+		ln, err := net.Listen("tcp", *respAddr)
+		if err != nil {
+			log.Fatalf("failed to listen for RESP: %v", err)
+		}
+		resp = newRespServer(db, *respBucket)
+		go func() {
+			log.Printf("RESP frontend running on %s", ln.Addr())
+			if err := resp.serve(ln); err != nil {
+				log.Fatalf("RESP server finished: %s", err)
+			}
+		}()
+	}
+
+	srv := &http.Server{Addr: dbOpts.addr}
+	srv.RegisterOnShutdown(func() { close(shuttingDown) })
+	go func() {
+		var err error
+		if dbOpts.tlsCert != "" {
+			log.Printf("DB service running on %s (TLS)", dbOpts.addr)
+			err = srv.ListenAndServeTLS(dbOpts.tlsCert, dbOpts.tlsKey)
+		} else {
+			log.Printf("DB service running on %s", dbOpts.addr)
+			err = srv.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP server finished: %s", err)
+		}
+	}()
+
+	signal.WaitForTerminationSignal()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+	if resp != nil {
+		_ = resp.close()
+	}
+	stopFollower()
+	if follower != nil {
+		<-follower.done
+	}
+	if cluster != nil {
+		if err := cluster.close(); err != nil {
+			log.Printf("failed to stop raft node: %v", err)
+		}
+	}
+	// Close дочікується записів, прийнятих до зупинки, і фонової компакції
+	if err := db.Close(); err != nil {
+		log.Printf("failed to close db: %v", err)
+	}
+}
 
-	log.Println("DB service running on :8081")
-	log.Fatal(http.ListenAndServe(":8081", nil))
+// parseDbPath розбирає /db/{bucket}/{key}; старий шлях /db/{key} веде до бакета за замовчуванням.
+// Ключ бакета за замовчуванням зі "/" (наприклад "a/b", записаний до появи бакетів) лишається доступним
+// за старим шляхом /db/a/b, якщо в бакеті "a" немає ключа "b". Новий такий ключ створюється лише через
+// /db/default/a/b, бо /db/a/b без наявного ключа означає ключ "b" бакета "a".
+func parseDbPath(path string) (string, string) {
+	rest := strings.TrimPrefix(path, "/db/")
+	bucket, key, ok := strings.Cut(rest, "/")
+	if !ok {
+		return datastore.DefaultBucket, rest
+	}
+	if key != "" && bucket != datastore.DefaultBucket && !db.Bucket(bucket).Exists(key) &&
+		db.Bucket(datastore.DefaultBucket).Exists(rest) {
+		return datastore.DefaultBucket, rest
+	}
+	return bucket, key
 }
 
 func handleDb(w http.ResponseWriter, r *http.Request) {
-	key := strings.TrimPrefix(r.URL.Path, "/db/")
+	name, key := parseDbPath(r.URL.Path)
+	if !datastore.ValidBucketName(name) {
+		httpError(w, "invalid bucket", http.StatusBadRequest)
+		return
+	}
+	scope := scopeRead
+	if r.Method != http.MethodGet {
+		scope = scopeWrite
+	}
+	target := name + "/" + key
+	if key == "" {
+		target += r.URL.Query().Get("prefix")
+	}
+	if !permit(w, r, scope, target) {
+		return
+	}
+	bucket := db.Bucket(name)
+	// GET /db/{bucket}/ повертає список ключів бакета
+	if key == "" && r.Method == http.MethodGet && r.URL.Path != "/db/" {
+		listKeys(w, r, bucket)
+		return
+	}
 	if key == "" {
-		http.Error(w, "key required", http.StatusBadRequest)
+		httpError(w, "key required", http.StatusBadRequest)
 		return
 	}
 
+	raw := r.Header.Get("Accept") == octetStream || r.Header.Get("Content-Type") == octetStream
+
 	switch r.Method {
 	case http.MethodGet:
-		value, err := db.Get(key)
+		if raw {
+			streamValue(w, r, bucket, key)
+			return
+		}
+		var value string
+		var version uint64
+		var err error
+		if v := r.URL.Query().Get("version"); v != "" {
+			version, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				httpError(w, "invalid version", http.StatusBadRequest)
+				return
+			}
+			value, err = bucket.GetVersion(key, version)
+		} else {
+			value, version, err = bucket.GetWithSeqContext(r.Context(), key)
+		}
 		if err != nil {
-			http.NotFound(w, r)
+			storeError(w, err, "failed to read")
 			return
 		}
-		resp := map[string]string{
-			"key":   key,
-			"value": value,
+		resp := map[string]any{
+			"bucket":  name,
+			"key":     key,
+			"value":   value,
+			"version": version,
 		}
+		w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(version, 10)))
 		_ = json.NewEncoder(w).Encode(resp)
 
 	case http.MethodPost:
+		version, conditional, err := parsePrecondition(r)
+		if err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if raw {
+			if conditional {
+				httpError(w, "conditional writes are supported only for JSON values", http.StatusBadRequest)
+				return
+			}
+			storeStream(w, r, bucket, key)
+			return
+		}
 		var req struct {
 			Value string `json:"value"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			httpError(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if conditional {
+			err = swapValue(r.Context(), bucket, key, version, []byte(req.Value))
+		} else {
+			err = putValue(r.Context(), bucket, key, []byte(req.Value))
+		}
+		if err != nil {
+			storeError(w, err, "failed to write")
 			return
 		}
-		if err := db.Put(key, req.Value); err != nil {
-			http.Error(w, "failed to write", http.StatusInternalServerError)
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		if err := deleteValue(r.Context(), bucket, key); err != nil {
+			storeError(w, err, "failed to delete")
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 
 	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// parsePrecondition розбирає умову запису: If-Match з версією ключа або If-None-Match: *,
+// що дозволяє лише створити ключ (версія 0).
+func parsePrecondition(r *http.Request) (uint64, bool, error) {
+	if r.Header.Get("If-None-Match") == "*" {
+		return 0, true, nil
+	}
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		return 0, false, nil
+	}
+	version, err := strconv.ParseUint(strings.Trim(match, `"`), 10, 64)
+	if err != nil || version == 0 {
+		return 0, false, fmt.Errorf("invalid If-Match version %q", match)
+	}
+	return version, true, nil
+}
+
+func listKeys(w http.ResponseWriter, r *http.Request, bucket *datastore.Bucket) {
+	keys := bucket.Keys(r.URL.Query().Get("prefix"))
+	if keys == nil {
+		keys = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"bucket": bucket.Name(),
+		"keys":   keys,
+	})
+}
+
+func handleBuckets(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/db-buckets"), "/")
+	w.Header().Set("Content-Type", "application/json")
+
+	if name == "" {
+		if r.Method != http.MethodGet {
+			httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !permit(w, r, scopeRead, "") {
+			return
+		}
+		stats := []datastore.BucketStats{}
+		for _, bucket := range db.Buckets() {
+			stats = append(stats, db.Bucket(bucket).Stats())
+		}
+		_ = json.NewEncoder(w).Encode(stats)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !permit(w, r, scopeRead, name+"/") {
+			return
+		}
+		_ = json.NewEncoder(w).Encode(db.Bucket(name).Stats())
+	case http.MethodDelete:
+		if !permit(w, r, scopeWrite, name+"/") {
+			return
+		}
+		if err := dropBucket(r.Context(), name); err != nil {
+			storeError(w, err, "failed to delete")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func streamValue(w http.ResponseWriter, r *http.Request, bucket *datastore.Bucket, key string) {
+	value, err := bucket.GetStream(key)
+	if err != nil {
+		storeError(w, err, "failed to read")
+		return
+	}
+	defer value.Close()
+
+	w.Header().Set("Content-Type", octetStream)
+	if _, err := io.Copy(w, value); err != nil {
+		// Заголовки вже відправлено, тому лише обриваємо відповідь
+		if errors.Is(err, datastore.ErrHashMismatch) {
+			alertCorruption("stream "+key, err)
+		} else {
+			log.Printf("stream %s: %v", key, err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func storeStream(w http.ResponseWriter, r *http.Request, bucket *datastore.Bucket, key string) {
+	if cluster != nil {
+		// запис журналу Raft містить значення цілком, тож тіло читається в пам'ять
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, db.Options().MaxRecordSize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			httpError(w, "value is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			httpError(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if err := putValue(r.Context(), bucket, key, value); err != nil {
+			storeError(w, err, "failed to write")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	body, size := io.Reader(r.Body), r.ContentLength
+	if size < 0 {
+		// Для chunked-запитів розмір невідомий, тому спершу зберігаємо тіло у тимчасовий файл
+		tmp, err := os.CreateTemp("", "db-upload-*")
+		if err != nil {
+			httpError(w, "failed to write", http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		size, err = io.Copy(tmp, http.MaxBytesReader(w, r.Body, db.Options().MaxRecordSize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			httpError(w, "value is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			httpError(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			httpError(w, "failed to write", http.StatusInternalServerError)
+			return
+		}
+		body = tmp
+	}
+
+	if err := bucket.PutStreamContext(r.Context(), key, body, size); err != nil {
+		storeError(w, err, "failed to write")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type watchEvent struct {
+	Bucket  string `json:"bucket"`
+	Key     string `json:"key,omitempty"`
+	Seq     uint64 `json:"seq"`
+	Dropped uint64 `json:"dropped,omitempty"`
+}
+
+func handleWatch(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	name := r.URL.Query().Get("bucket")
+	if name == "" {
+		name = datastore.DefaultBucket
+	}
+	if !permit(w, r, scopeRead, name+"/"+r.URL.Query().Get("prefix")) {
+		return
+	}
+	events := db.Bucket(name).Watch(r.URL.Query().Get("prefix"))
+	defer db.Unwatch(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(watchEvent{Bucket: ev.Bucket, Key: ev.Key, Seq: ev.Seq, Dropped: ev.Dropped})
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// індекси охоплюють довільні бакети, тож потрібен доступ до всіх ключів
+	if !permit(w, r, scopeRead, "") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/db-index"), "/")
+	if name == "" {
+		_ = json.NewEncoder(w).Encode(db.Indexes())
+		return
+	}
+	if !r.URL.Query().Has("eq") {
+		httpError(w, "eq required", http.StatusBadRequest)
+		return
+	}
+
+	keys, err := db.Lookup(name, r.URL.Query().Get("eq"))
+	if err != nil {
+		httpError(w, "index does not exist", http.StatusNotFound)
+		return
 	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"index": name,
+		"keys":  keys,
+	})
 }
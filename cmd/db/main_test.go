@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleDb_KeysWithSlash(t *testing.T) {
+	openTestDb(t, datastore.Options{})
+	// ключ бакета за замовчуванням, записаний до появи бакетів
+	require.NoError(t, db.Put("a/b", "legacy"))
+
+	get := func(path string) (int, map[string]any) {
+		rec := httptest.NewRecorder()
+		handleDb(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp map[string]any
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		}
+		return rec.Code, resp
+	}
+
+	for _, path := range []string{"/db/a/b", "/db/default/a/b"} {
+		code, resp := get(path)
+		require.Equal(t, http.StatusOK, code, path)
+		assert.Equal(t, "default", resp["bucket"], path)
+		assert.Equal(t, "a/b", resp["key"], path)
+		assert.Equal(t, "legacy", resp["value"], path)
+	}
+
+	// запис за старим шляхом оновлює наявний ключ, а не створює бакет "a"
+	code, _ := doDb(t, http.MethodPost, "/db/a/b", "application/json", `{"value": "updated"}`)
+	require.Equal(t, http.StatusNoContent, code)
+	v, err := db.Get("a/b")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", v)
+	assert.False(t, db.Bucket("a").Exists("b"))
+
+	// без такого ключа в бакеті за замовчуванням шлях означає бакет і ключ
+	code, _ = doDb(t, http.MethodPost, "/db/x/y", "application/json", `{"value": "1"}`)
+	require.Equal(t, http.StatusNoContent, code)
+	assert.True(t, db.Bucket("x").Exists("y"))
+
+	// ключ бакета має пріоритет, якщо існують обидва
+	require.NoError(t, db.Bucket("a").Put("b", "bucketed"))
+	code, resp := get("/db/a/b")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "bucketed", resp["value"])
+	assert.Equal(t, "a", resp["bucket"])
+}
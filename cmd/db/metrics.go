@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+)
+
+func handleStats(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(db.Stats())
+}
+
+type healthStatus struct {
+	Open     bool   `json:"open"`
+	Writable bool   `json:"writable"`
+	Role     string `json:"role"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleHealth відповідає 200, якщо база відкрита і каталог даних приймає записи, інакше 503.
+// Репліки і послідовники Raft теж здорові: вони записують зміни лідера у власну базу.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := healthStatus{Open: true, Writable: true, Role: nodeRole()}
+	if err := db.CheckWritable(); err != nil {
+		status.Open = !errors.Is(err, datastore.ErrClosed)
+		status.Writable = false
+		status.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Writable {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// nodeRole повертає standalone, replica або стан вузла Raft.
+func nodeRole() string {
+	switch {
+	case follower != nil:
+		return "replica"
+	case cluster != nil:
+		return cluster.node.Status().State
+	}
+	return "standalone"
+}
+
+func handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writePrometheus(w, db.Stats())
+}
+
+func writePrometheus(w io.Writer, stats datastore.Stats) {
+	gauge := func(name, help string, value any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	summary := func(name, help string, l datastore.LatencyStats) {
+		fmt.Fprintf(w, "# HELP %s_seconds %s\n# TYPE %s_seconds summary\n", name, help, name)
+		fmt.Fprintf(w, "%s_seconds_sum %g\n%s_seconds_count %d\n", name, l.Total.Seconds(), name, l.Count)
+		gauge(name+"_max_seconds", "Maximum observed "+strings.ToLower(help[:1])+help[1:], l.Max.Seconds())
+	}
+
+	gauge("datastore_keys", "Number of live keys.", stats.Keys)
+	gauge("datastore_buckets", "Number of non-empty buckets.", stats.Buckets)
+	gauge("datastore_live_bytes", "Bytes taken by live records.", stats.LiveBytes)
+	gauge("datastore_dead_bytes", "Bytes taken by overwritten or deleted records.", stats.DeadBytes)
+	gauge("datastore_segments", "Number of segment files including the active one.", len(stats.Segments))
+	gauge("datastore_write_queue", "Writes waiting for the writer loop.", stats.WriteQueue)
+
+	fmt.Fprintf(w, "# HELP datastore_segment_bytes Size of each segment file.\n# TYPE datastore_segment_bytes gauge\n")
+	for _, seg := range stats.Segments {
+		fmt.Fprintf(w, "datastore_segment_bytes{segment=%q} %d\n", seg.Name, seg.Size)
+	}
+
+	summary("datastore_put", "Latency of put operations.", stats.Puts)
+	summary("datastore_delete", "Latency of delete operations.", stats.Deletes)
+	summary("datastore_get", "Latency of get operations.", stats.Gets)
+	summary("datastore_compaction", "Duration of compactions.", stats.Compactions)
+	summary("datastore_batch", "Latency of atomic batch writes.", stats.Batches)
+}
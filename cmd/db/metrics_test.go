@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	stats := datastore.Stats{
+		Keys:      3,
+		LiveBytes: 120,
+		Segments: []datastore.SegmentStats{
+			{Name: "segment-1", Size: 100},
+			{Name: "current-data", Size: 20},
+		},
+		Puts: datastore.LatencyStats{Count: 4, Total: 2 * time.Second, Max: time.Second},
+	}
+
+	var out bytes.Buffer
+	writePrometheus(&out, stats)
+	text := out.String()
+
+	assert.Contains(t, text, "# TYPE datastore_keys gauge\ndatastore_keys 3\n")
+	assert.Contains(t, text, "datastore_live_bytes 120\n")
+	assert.Contains(t, text, "datastore_segments 2\n")
+	assert.Contains(t, text, `datastore_segment_bytes{segment="segment-1"} 100`+"\n")
+	assert.Contains(t, text, "datastore_put_seconds_sum 2\ndatastore_put_seconds_count 4\n")
+	assert.Contains(t, text, "datastore_put_max_seconds 1\n")
+
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		assert.Len(t, strings.Fields(line), 2, "malformed sample line %q", line)
+	}
+}
+
+func TestHandleHealth(t *testing.T) {
+	prev := db
+	t.Cleanup(func() {
+		db = prev
+	})
+	var err error
+	db, err = datastore.Open(t.TempDir(), 1024)
+	require.NoError(t, err)
+
+	check := func() (int, healthStatus) {
+		rec := httptest.NewRecorder()
+		handleHealth(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		var status healthStatus
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&status))
+		return rec.Code, status
+	}
+
+	code, status := check()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthStatus{Open: true, Writable: true, Role: "standalone"}, status)
+
+	require.NoError(t, db.Close())
+	code, status = check()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, status.Open)
+	assert.False(t, status.Writable)
+}
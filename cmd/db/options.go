@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+)
+
+// indexFlags збирає оголошення вторинних індексів у форматі name=path або name=bucket:path.
+type indexFlags []datastore.IndexSpec
+
+func (f *indexFlags) String() string {
+	var res []string
+	for _, spec := range *f {
+		res = append(res, fmt.Sprintf("%s=%s:%s", spec.Name, spec.Bucket, spec.Path))
+	}
+	return strings.Join(res, ",")
+}
+
+func (f *indexFlags) Set(value string) error {
+	name, target, ok := strings.Cut(value, "=")
+	if !ok || name == "" || target == "" {
+		return fmt.Errorf("expected name=path or name=bucket:path, got %q", value)
+	}
+	spec := datastore.IndexSpec{Name: name, Path: target}
+	if bucket, path, ok := strings.Cut(target, ":"); ok {
+		spec.Bucket, spec.Path = bucket, path
+	}
+	*f = append(*f, spec)
+	return nil
+}
+
+// quotaFlags збирає квоти окремих бакетів у форматі bucket=bytes.
+type quotaFlags map[string]int64
+
+func (f quotaFlags) String() string {
+	var res []string
+	for bucket, limit := range f {
+		res = append(res, fmt.Sprintf("%s=%d", bucket, limit))
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+func (f quotaFlags) Set(value string) error {
+	bucket, limit, ok := strings.Cut(value, "=")
+	n, err := strconv.ParseInt(limit, 10, 64)
+	if !ok || bucket == "" || err != nil || n < 0 {
+		return fmt.Errorf("expected bucket=bytes, got %q", value)
+	}
+	f[bucket] = n
+	return nil
+}
+
+type dbFlags struct {
+	opts     datastore.Options
+	indexes  indexFlags
+	quotas   quotaFlags
+	logLevel slog.Level
+
+	dataDir string
+	addr    string
+	// tlsCert і tlsKey вмикають HTTPS; задаються лише разом
+	tlsCert string
+	tlsKey  string
+	// authConfig - файл з токенами доступу; порожній вимикає автентифікацію
+	authConfig string
+	// authToken - токен, з яким вузол звертається до лідера реплікації та інших вузлів Raft
+	authToken string
+
+	// rateLimit - записів на секунду для одного клієнта; 0 вимикає обмеження
+	rateLimit       float64
+	rateBurst       int
+	quotaRetryAfter time.Duration
+}
+
+func bindDbFlags(fs *flag.FlagSet) *dbFlags {
+	f := &dbFlags{quotas: make(quotaFlags)}
+	f.opts.SegmentSize = datastore.DefaultSegmentSize
+	f.opts.HistorySize = 10
+
+	fs.Int64Var(&f.opts.SegmentSize, "segment-size", f.opts.SegmentSize, "size in bytes after which the active segment is sealed")
+	fs.Int64Var(&f.opts.MaxRecordSize, "max-record-size", datastore.DefaultMaxRecordSize, "maximum size of a single record in bytes")
+	fs.IntVar(&f.opts.MaxKeySize, "max-key-size", datastore.DefaultMaxKeySize, "maximum key size in bytes")
+	fs.Int64Var(&f.opts.MaxValueSize, "max-value-size", 0, "maximum value size in bytes (0 - limited by max-record-size)")
+	fs.IntVar(&f.opts.HistorySize, "history", f.opts.HistorySize, "number of previous versions kept per key until compaction")
+	fs.IntVar(&f.opts.WriteQueueSize, "write-queue", datastore.DefaultWriteQueueSize, "capacity of the write queue")
+	fs.TextVar(&f.opts.Sync, "sync", datastore.SyncNever, "fsync policy: never, always or interval")
+	fs.DurationVar(&f.opts.SyncInterval, "sync-interval", datastore.DefaultSyncInterval, "fsync period for the interval sync policy")
+	fs.DurationVar(&f.opts.Compaction.Interval, "compact-interval", 0, "how often to check whether compaction is needed (0 disables background compaction)")
+	fs.IntVar(&f.opts.Compaction.MinSegments, "compact-min-segments", 2, "minimum number of sealed segments before background compaction")
+	fs.Float64Var(&f.opts.Compaction.MinDeadRatio, "compact-dead-ratio", 0.5, "minimum share of dead bytes before background compaction")
+	fs.IntVar(&f.opts.ReplicationLogSize, "replication-log", datastore.DefaultReplicationLogSize, "number of recent records replicas can read before they need a snapshot")
+	fs.TextVar(&f.opts.Hash, "hash", datastore.HashSHA1, "value checksum algorithm for new records: sha1, crc32c, xxhash or sha256")
+	fs.Int64Var(&f.opts.Quotas.BucketBytes, "bucket-quota", 0, "maximum live bytes per bucket (0 - unlimited)")
+	fs.Var(f.quotas, "quota", "live bytes limit of one bucket as bucket=bytes, overrides -bucket-quota (repeatable)")
+	fs.DurationVar(&f.quotaRetryAfter, "quota-retry-after", time.Minute, "Retry-After of responses rejected by a bucket quota")
+	fs.Float64Var(&f.rateLimit, "rate-limit", 0, "writes per second allowed for each client (token or IP); 0 disables the limit")
+	fs.IntVar(&f.rateBurst, "rate-burst", 0, "number of writes a client can make at once above -rate-limit (defaults to the rate)")
+	fs.Var(&f.indexes, "index", "secondary index over JSON values as name=path or name=bucket:path (repeatable)")
+	fs.TextVar(&f.logLevel, "log-level", slog.LevelInfo, "datastore log level: DEBUG, INFO, WARN or ERROR")
+	fs.StringVar(&f.dataDir, "data-dir", "./data", "directory with segment files")
+	fs.StringVar(&f.addr, "addr", ":8081", "HTTP listen address")
+	fs.StringVar(&f.tlsCert, "tls-cert", "", "TLS certificate file; enables HTTPS together with -tls-key")
+	fs.StringVar(&f.tlsKey, "tls-key", "", "TLS private key file")
+	fs.StringVar(&f.authConfig, "auth-config", "", "JSON file with bearer tokens, their scopes and key prefixes; authentication is disabled when empty")
+	fs.StringVar(&f.authToken, "auth-token", "", "bearer token sent to the replication leader and Raft peers (needs read scope for replicas, admin for Raft)")
+	return f
+}
+
+func (f *dbFlags) validate() error {
+	if (f.tlsCert == "") != (f.tlsKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+	if f.rateLimit < 0 || f.rateBurst < 0 {
+		return fmt.Errorf("-rate-limit and -rate-burst must not be negative")
+	}
+	return nil
+}
+
+// envPrefix - префікс змінних оточення: значення -segment-size читається з DB_SEGMENT_SIZE.
+const envPrefix = "DB_"
+
+// applyEnv задає прапорці, яких немає в командному рядку, зі змінних оточення, тож командний
+// рядок має пріоритет. Повторюваний прапорець отримує зі змінної одне значення.
+func applyEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := lookup(name); ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: %w", name, setErr)
+			}
+		}
+	})
+	return err
+}
+
+func (f *dbFlags) options() datastore.Options {
+	opts := f.opts
+	opts.Indexes = f.indexes
+	if len(f.quotas) > 0 {
+		opts.Quotas.Buckets = f.quotas
+	}
+	opts.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: f.logLevel}))
+	return opts
+}
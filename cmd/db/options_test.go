@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindDbFlags_Defaults(t *testing.T) {
+	fs := flag.NewFlagSet("db", flag.ContinueOnError)
+	f := bindDbFlags(fs)
+	require.NoError(t, fs.Parse(nil))
+
+	opts := f.options()
+	assert.Equal(t, int64(datastore.DefaultSegmentSize), opts.SegmentSize)
+	assert.Equal(t, datastore.SyncNever, opts.Sync)
+	assert.Equal(t, datastore.HashSHA1, opts.Hash)
+	assert.NotNil(t, opts.Logger)
+}
+
+func TestBindDbFlags_Parse(t *testing.T) {
+	fs := flag.NewFlagSet("db", flag.ContinueOnError)
+	f := bindDbFlags(fs)
+	require.NoError(t, fs.Parse([]string{
+		"-segment-size", "2048",
+		"-sync", "interval",
+		"-sync-interval", "250ms",
+		"-compact-interval", "1m",
+		"-compact-dead-ratio", "0.3",
+		"-write-queue", "16",
+		"-index", "city=address.city",
+		"-index", "team=people:team",
+		"-log-level", "DEBUG",
+		"-bucket-quota", "4096",
+		"-quota", "logs=0",
+		"-rate-limit", "2.5",
+	}))
+
+	opts := f.options()
+	assert.Equal(t, int64(2048), opts.SegmentSize)
+	assert.Equal(t, datastore.SyncInterval, opts.Sync)
+	assert.Equal(t, 250*time.Millisecond, opts.SyncInterval)
+	assert.Equal(t, time.Minute, opts.Compaction.Interval)
+	assert.Equal(t, 0.3, opts.Compaction.MinDeadRatio)
+	assert.Equal(t, 16, opts.WriteQueueSize)
+	assert.Equal(t, []datastore.IndexSpec{
+		{Name: "city", Path: "address.city"},
+		{Name: "team", Bucket: "people", Path: "team"},
+	}, opts.Indexes)
+	assert.Equal(t, slog.LevelDebug, f.logLevel)
+	assert.Equal(t, datastore.QuotaPolicy{BucketBytes: 4096, Buckets: map[string]int64{"logs": 0}}, opts.Quotas)
+	assert.Equal(t, 2.5, f.rateLimit)
+}
+
+func TestBindDbFlags_Invalid(t *testing.T) {
+	fs := flag.NewFlagSet("db", flag.ContinueOnError)
+	fs.SetOutput(discard{})
+	bindDbFlags(fs)
+	assert.Error(t, fs.Parse([]string{"-sync", "sometimes"}))
+	assert.Error(t, fs.Parse([]string{"-index", "broken"}))
+	assert.Error(t, fs.Parse([]string{"-quota", "logs=-1"}))
+}
+
+func TestApplyEnv(t *testing.T) {
+	fs := flag.NewFlagSet("db", flag.ContinueOnError)
+	f := bindDbFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-segment-size", "2048"}))
+
+	env := map[string]string{
+		"DB_SEGMENT_SIZE":       "4096",
+		"DB_DATA_DIR":           "/var/lib/db",
+		"DB_ADDR":               ":9000",
+		"DB_SYNC":               "always",
+		"DB_COMPACT_DEAD_RATIO": "0.2",
+		"DB_TLS_CERT":           "cert.pem",
+	}
+	require.NoError(t, applyEnv(fs, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}))
+
+	opts := f.options()
+	assert.Equal(t, int64(2048), opts.SegmentSize, "command line must take precedence")
+	assert.Equal(t, "/var/lib/db", f.dataDir)
+	assert.Equal(t, ":9000", f.addr)
+	assert.Equal(t, datastore.SyncAlways, opts.Sync)
+	assert.Equal(t, 0.2, opts.Compaction.MinDeadRatio)
+	assert.Error(t, f.validate(), "TLS key is missing")
+
+	f.tlsKey = "key.pem"
+	assert.NoError(t, f.validate())
+
+	fs = flag.NewFlagSet("db", flag.ContinueOnError)
+	bindDbFlags(fs)
+	err := applyEnv(fs, func(name string) (string, bool) {
+		return "sometimes", name == "DB_SYNC"
+	})
+	assert.ErrorContains(t, err, "DB_SYNC")
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
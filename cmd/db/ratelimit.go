@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxLimitedClients - після скількох клієнтів limiter забуває тих, чиє відро вже повне.
+const maxLimitedClients = 10000
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter - token bucket для кожного клієнта: відро на burst токенів поповнюється зі
+// швидкістю rate токенів на секунду, кожен запис забирає один токен.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	lock    sync.Mutex
+	clients map[string]*tokenBucket
+}
+
+// limiter дорівнює nil, якщо -rate-limit не задано.
+var limiter *rateLimiter
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), now: time.Now, clients: make(map[string]*tokenBucket)}
+}
+
+// allow забирає токен клієнта; якщо токенів немає, повертає час до появи наступного.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	b, ok := l.clients[client]
+	if !ok {
+		if len(l.clients) >= maxLimitedClients {
+			l.forgetIdle(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// forgetIdle видаляє клієнтів, чиє відро вже наповнилося: для них новий запис нічим не відрізняється.
+func (l *rateLimiter) forgetIdle(now time.Time) {
+	for client, b := range l.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// clientID - назва токена, якщо ввімкнено автентифікацію, інакше IP-адреса клієнта.
+func clientID(r *http.Request) string {
+	if p, ok := r.Context().Value(principalKey{}).(*principal); ok {
+		return "token:" + p.name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimited обмежує частоту записів кожного клієнта; читання не обмежуються.
+// Має стояти після authenticated, щоб клієнта можна було розрізнити за токеном.
+func rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		if ok, wait := limiter.allow(clientID(r)); !ok {
+			setRetryAfter(w, wait)
+			jsonError(w, http.StatusTooManyRequests, codeRateLimited,
+				fmt.Sprintf("write rate limit of %g per second exceeded", limiter.rate))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// setRetryAfter задає Retry-After у цілих секундах, округлюючи вгору.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(max(1, int64(math.Ceil(wait.Seconds()))), 10))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allow("a")
+		assert.True(t, ok, "burst must allow %d writes", i+1)
+	}
+	ok, wait := l.allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	ok, _ = l.allow("b")
+	assert.True(t, ok, "clients are limited independently")
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.allow("a")
+	assert.True(t, ok)
+
+	now = now.Add(time.Hour)
+	l.forgetIdle(now)
+	assert.Empty(t, l.clients)
+}
+
+func TestRateLimited(t *testing.T) {
+	setupAuth(t)
+	prev := limiter
+	t.Cleanup(func() {
+		limiter = prev
+	})
+	limiter = newRateLimiter(1, 1)
+	h := authenticated(rateLimited(handleDb))
+
+	assert.Equal(t, http.StatusNoContent, doAuth(h, http.MethodPost, "/db/users/a", "w-secret", `{"value": "1"}`).Code)
+	rec := doAuth(h, http.MethodPost, "/db/users/b", "w-secret", `{"value": "1"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), codeRateLimited)
+
+	assert.Equal(t, http.StatusOK, doAuth(h, http.MethodGet, "/db/users/a", "w-secret", "").Code, "reads are not limited")
+	assert.Equal(t, http.StatusNoContent, doAuth(h, http.MethodPost, "/db/b", "a-secret", `{"value": "1"}`).Code,
+		"another token has its own bucket")
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	prev := db
+	t.Cleanup(func() {
+		db = prev
+	})
+	var err error
+	db, err = datastore.OpenWithOptions(t.TempDir(), datastore.Options{Quotas: datastore.QuotaPolicy{BucketBytes: 100}})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	code, _ := doDb(t, http.MethodPost, "/db/k", "application/json", `{"value": "small"}`)
+	assert.Equal(t, http.StatusNoContent, code)
+
+	rec := doAuth(handleDb, http.MethodPost, "/db/big", "", `{"value": "`+strings.Repeat("x", 100)+`"}`)
+	assert.Equal(t, http.StatusInsufficientStorage, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), codeQuotaExceeded)
+}
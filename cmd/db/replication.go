@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+)
+
+const (
+	replicationBatchSize = 256
+	heartbeatInterval    = time.Second
+	maxReconnectDelay    = 10 * time.Second
+
+	messageHeartbeat   = "heartbeat"
+	messageSnapshotEnd = "snapshot-end"
+
+	// snapshotMarkerName - файл у каталозі даних репліки, що існує, поки завантажується знімок.
+	// Ключі знімка приходять не в порядку seq, тож після перерваного завантаження LastSeq бази
+	// не є позицією в журналі лідера, і знімок завантажується заново.
+	snapshotMarkerName = "replica-snapshot"
+)
+
+var errSnapshotRequired = errors.New("leader requires a snapshot")
+
+// replicationMessage - рядок JSON Lines у потоках /replication/log та /replication/snapshot.
+// Type - тип запису журналу (put, delete, drop-bucket) або службове повідомлення.
+type replicationMessage struct {
+	Seq    uint64 `json:"seq"`
+	Type   string `json:"type"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Value  []byte `json:"value,omitempty"`
+	// Expect - очікувана версія ключа для умовного запису; буває лише в командах Raft
+	Expect *uint64 `json:"expect,omitempty"`
+	// Ops - зміни пакета в команді Raft типу batch
+	Ops []replicationMessage `json:"ops,omitempty"`
+}
+
+func newRecordMessage(rec datastore.LogRecord) replicationMessage {
+	return replicationMessage{Seq: rec.Seq, Type: rec.Type.String(), Bucket: rec.Bucket, Key: rec.Key, Value: rec.Value}
+}
+
+func (m replicationMessage) record() (datastore.LogRecord, error) {
+	rec := datastore.LogRecord{Seq: m.Seq, Bucket: m.Bucket, Key: m.Key, Value: m.Value}
+	err := rec.Type.UnmarshalText([]byte(m.Type))
+	return rec, err
+}
+
+type replicaStatus struct {
+	ID        string    `json:"id"`
+	Addr      string    `json:"addr"`
+	Seq       uint64    `json:"seq"`
+	Lag       uint64    `json:"lag"`
+	Connected bool      `json:"connected"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type replicationStatus struct {
+	Role        string          `json:"role"`
+	Seq         uint64          `json:"seq"`
+	Leader      string          `json:"leader,omitempty"`
+	LeaderSeq   uint64          `json:"leader_seq,omitempty"`
+	Lag         uint64          `json:"lag"`
+	Connected   bool            `json:"connected"`
+	LastContact *time.Time      `json:"last_contact,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	Replicas    []replicaStatus `json:"replicas,omitempty"`
+}
+
+// replicaRegistry запам'ятовує, до якого запису лідер відправив журнал кожній репліці.
+type replicaRegistry struct {
+	lock sync.Mutex
+	list map[string]*replicaStatus
+}
+
+var replicas = &replicaRegistry{list: make(map[string]*replicaStatus)}
+
+func (reg *replicaRegistry) update(id, addr string, seq uint64, connected bool) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	reg.list[id] = &replicaStatus{ID: id, Addr: addr, Seq: seq, Connected: connected, LastSeen: time.Now()}
+}
+
+func (reg *replicaRegistry) snapshot(last uint64) []replicaStatus {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	res := make([]replicaStatus, 0, len(reg.list))
+	for _, r := range reg.list {
+		st := *r
+		if last > st.Seq {
+			st.Lag = last - st.Seq
+		}
+		res = append(res, st)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// serverContext завершується разом із запитом або на початку зупинки сервера.
+func serverContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(r.Context())
+	go func() {
+		select {
+		case <-shuttingDown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// handleReplicationLog передає журнал після ?after= потоком JSON Lines. Якщо нових записів
+// немає, раз на heartbeatInterval надсилає heartbeat з номером останнього запису лідера.
+func handleReplicationLog(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	after, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid after", http.StatusBadRequest)
+		return
+	}
+	id := r.URL.Query().Get("replica")
+	if id == "" {
+		id = r.RemoteAddr
+	}
+
+	ctx, cancel := serverContext(r)
+	defer cancel()
+	replicas.update(id, r.RemoteAddr, after, true)
+	defer func() {
+		replicas.update(id, r.RemoteAddr, after, false)
+	}()
+
+	enc := json.NewEncoder(w)
+	started := false
+	for {
+		waitCtx, waitCancel := context.WithTimeout(ctx, heartbeatInterval)
+		recs, err := db.ReadLog(waitCtx, after, replicationBatchSize)
+		waitCancel()
+
+		var msgs []replicationMessage
+		switch {
+		case err == nil:
+			for _, rec := range recs {
+				msgs = append(msgs, newRecordMessage(rec))
+			}
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			msgs = append(msgs, replicationMessage{Seq: db.LastSeq(), Type: messageHeartbeat})
+		case errors.Is(err, datastore.ErrLogTruncated) && !started:
+			httpError(w, err.Error(), http.StatusGone)
+			return
+		default:
+			if !errors.Is(err, context.Canceled) && !errors.Is(err, datastore.ErrClosed) {
+				log.Printf("replication log for %s: %v", id, err)
+			}
+			return
+		}
+
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+		}
+		for _, msg := range msgs {
+			if err := enc.Encode(msg); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		if err == nil {
+			after = recs[len(recs)-1].Seq
+		}
+		replicas.update(id, r.RemoteAddr, after, true)
+	}
+}
+
+// handleReplicationSnapshot передає всі живі ключі, а останнім рядком - snapshot-end з
+// номером послідовності, після якого репліка продовжує читати журнал.
+func handleReplicationSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	seq, err := db.Snapshot(func(rec datastore.LogRecord) error {
+		return enc.Encode(newRecordMessage(rec))
+	})
+	if err != nil {
+		log.Printf("replication snapshot: %v", err)
+		// Заголовки вже могли бути відправлені, тож обриваємо відповідь без snapshot-end
+		panic(http.ErrAbortHandler)
+	}
+	_ = enc.Encode(replicationMessage{Seq: seq, Type: messageSnapshotEnd})
+}
+
+func handleReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	var status replicationStatus
+	if follower != nil {
+		status = follower.status()
+	} else {
+		status.Role = "leader"
+		status.Seq = db.LastSeq()
+		status.Replicas = replicas.snapshot(status.Seq)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// readOnly відхиляє запити на зміну даних на репліці, а в режимі кластера перенаправляє їх
+// на лідера Raft: писати можна лише на лідера.
+func readOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		if follower != nil {
+			httpError(w, fmt.Sprintf("read-only replica, write to %s", follower.leader), http.StatusForbidden)
+			return
+		}
+		if cluster != nil && !cluster.redirectToLeader(w, r) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// replicaFollower отримує журнал лідера і застосовує його до локальної бази.
+type replicaFollower struct {
+	db     *datastore.Db
+	leader string
+	id     string
+	client *http.Client
+	done   chan struct{}
+	marker string
+	// resync - попереднє завантаження знімка не завершилось
+	resync bool
+
+	lock        sync.Mutex
+	applied     uint64
+	leaderSeq   uint64
+	connected   bool
+	lastContact time.Time
+	lastError   string
+}
+
+var follower *replicaFollower
+
+func newReplicaFollower(store *datastore.Db, leader, id, dataDir string) *replicaFollower {
+	f := &replicaFollower{
+		db:      store,
+		leader:  leader,
+		id:      id,
+		client:  &http.Client{},
+		done:    make(chan struct{}),
+		marker:  filepath.Join(dataDir, snapshotMarkerName),
+		applied: store.LastSeq(),
+	}
+	if _, err := os.Stat(f.marker); err == nil {
+		f.resync, f.applied = true, 0
+	}
+	return f
+}
+
+func (f *replicaFollower) run(ctx context.Context) {
+	defer close(f.done)
+	delay := heartbeatInterval
+	for ctx.Err() == nil {
+		var err error
+		if f.resync {
+			log.Printf("previous snapshot load was interrupted, loading snapshot")
+			err = f.loadSnapshot(ctx)
+		} else if err = f.stream(ctx); errors.Is(err, errSnapshotRequired) {
+			log.Printf("replica is behind the leader log, loading snapshot")
+			err = f.loadSnapshot(ctx)
+		}
+		f.setConnected(false, err)
+		if err == nil {
+			delay = heartbeatInterval
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("replication from %s: %v", f.leader, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+func (f *replicaFollower) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.leader+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusGone {
+			return nil, errSnapshotRequired
+		}
+		return nil, fmt.Errorf("%s: unexpected status %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
+func (f *replicaFollower) stream(ctx context.Context) error {
+	f.lock.Lock()
+	after := f.applied
+	f.lock.Unlock()
+
+	resp, err := f.get(ctx, "/replication/log", url.Values{
+		"after":   {strconv.FormatUint(after, 10)},
+		"replica": {f.id},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f.setConnected(true, nil)
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg replicationMessage
+		if err := dec.Decode(&msg); err != nil {
+			return fmt.Errorf("read log: %w", err)
+		}
+		if msg.Type == messageHeartbeat {
+			f.contact(msg.Seq, 0)
+			continue
+		}
+		rec, err := msg.record()
+		if err != nil {
+			return err
+		}
+		if err := f.db.Apply(ctx, rec); err != nil {
+			return fmt.Errorf("apply seq %d: %w", rec.Seq, err)
+		}
+		f.contact(rec.Seq, rec.Seq)
+	}
+}
+
+// loadSnapshot завантажує знімок лідера і продовжує читати журнал після нього. Маркер знімка
+// видаляється лише після snapshot-end, тож перерване завантаження після перезапуску повторюється.
+func (f *replicaFollower) loadSnapshot(ctx context.Context) error {
+	resp, err := f.get(ctx, "/replication/snapshot", url.Values{"replica": {f.id}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f.setConnected(true, nil)
+
+	if err := createMarker(f.marker); err != nil {
+		return fmt.Errorf("create snapshot marker: %w", err)
+	}
+	f.resync = true
+	seq, err := restoreSnapshot(ctx, f.db, json.NewDecoder(resp.Body))
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(f.marker); err != nil {
+		return fmt.Errorf("remove snapshot marker: %w", err)
+	}
+	f.resync = false
+	f.contact(seq, seq)
+	log.Printf("snapshot loaded at seq %d", seq)
+	return nil
+}
+
+// restoreSnapshot переписує ключі, що відрізняються від знімка, і видаляє ключі, яких у
+// знімку немає. Видалення отримують номер послідовності знімка, який і повертається.
+func restoreSnapshot(ctx context.Context, store *datastore.Db, dec *json.Decoder) (uint64, error) {
+	type bucketKey struct{ bucket, key string }
+	seen := make(map[bucketKey]struct{})
+	for {
+		var msg replicationMessage
+		if err := dec.Decode(&msg); err != nil {
+			return 0, fmt.Errorf("read snapshot: %w", err)
+		}
+		if msg.Type == messageSnapshotEnd {
+			for _, bucket := range store.Buckets() {
+				for _, key := range store.Bucket(bucket).Keys("") {
+					if _, ok := seen[bucketKey{bucket, key}]; ok {
+						continue
+					}
+					rec := datastore.LogRecord{Seq: msg.Seq, Type: datastore.EventDelete, Bucket: bucket, Key: key}
+					if err := store.Apply(ctx, rec); err != nil {
+						return 0, fmt.Errorf("apply snapshot: %w", err)
+					}
+				}
+			}
+			return msg.Seq, nil
+		}
+
+		rec, err := msg.record()
+		if err != nil {
+			return 0, err
+		}
+		seen[bucketKey{rec.Bucket, rec.Key}] = struct{}{}
+		versions := store.Bucket(rec.Bucket).Versions(rec.Key)
+		if len(versions) > 0 && versions[len(versions)-1] == rec.Seq {
+			continue
+		}
+		if err := store.Apply(ctx, rec); err != nil {
+			return 0, fmt.Errorf("apply snapshot: %w", err)
+		}
+	}
+}
+
+func createMarker(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *replicaFollower) setConnected(connected bool, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.connected = connected
+	if connected {
+		f.lastContact = time.Now()
+	}
+	if err != nil {
+		f.lastError = err.Error()
+	} else if connected {
+		f.lastError = ""
+	}
+}
+
+// contact фіксує номер запису лідера і, якщо applied не 0, позицію репліки.
+func (f *replicaFollower) contact(leaderSeq, applied uint64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.lastContact = time.Now()
+	f.leaderSeq = max(f.leaderSeq, leaderSeq)
+	if applied != 0 {
+		f.applied = applied
+	}
+}
+
+func (f *replicaFollower) status() replicationStatus {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	st := replicationStatus{
+		Role:      "follower",
+		Seq:       f.applied,
+		Leader:    f.leader,
+		LeaderSeq: f.leaderSeq,
+		Connected: f.connected,
+		LastError: f.lastError,
+	}
+	if f.leaderSeq > f.applied {
+		st.Lag = f.leaderSeq - f.applied
+	}
+	if !f.lastContact.IsZero() {
+		contact := f.lastContact
+		st.LastContact = &contact
+	}
+	return st
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startLeader(t *testing.T, opts datastore.Options) *httptest.Server {
+	t.Helper()
+	var err error
+	db, err = datastore.OpenWithOptions(t.TempDir(), opts)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/replication/log", handleReplicationLog)
+	mux.HandleFunc("/replication/snapshot", handleReplicationSnapshot)
+	mux.HandleFunc("/replication/status", handleReplicationStatus)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func startFollower(t *testing.T, leader string, store *datastore.Db, dir string) *replicaFollower {
+	t.Helper()
+	f := newReplicaFollower(store, leader, "replica-1", dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	go f.run(ctx)
+	t.Cleanup(func() {
+		cancel()
+		<-f.done
+	})
+	return f
+}
+
+func waitReplicated(t *testing.T, store *datastore.Db, bucket, key, want string) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		v, err := store.Bucket(bucket).Get(key)
+		return err == nil && v == want
+	}, 5*time.Second, 10*time.Millisecond, "%s/%s was not replicated", bucket, key)
+}
+
+func TestReplication_Stream(t *testing.T) {
+	srv := startLeader(t, datastore.Options{SegmentSize: 200})
+	dir := t.TempDir()
+	store, err := datastore.Open(dir, 200)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	f := startFollower(t, srv.URL, store, dir)
+
+	require.NoError(t, db.Put("k1", "v1"))
+	require.NoError(t, db.Bucket("users").Put("alice", "admin"))
+	waitReplicated(t, store, "users", "alice", "admin")
+
+	require.NoError(t, db.Delete("k1"))
+	require.NoError(t, db.Put("k2", "v2"))
+	waitReplicated(t, store, datastore.DefaultBucket, "k2", "v2")
+	_, err = store.Get("k1")
+	assert.ErrorIs(t, err, datastore.ErrNotFound)
+
+	_, seq, err := store.GetWithSeq("k2")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), seq, "replica must keep leader sequence numbers")
+
+	st := f.status()
+	assert.Equal(t, "follower", st.Role)
+	assert.Equal(t, uint64(4), st.Seq)
+	assert.Zero(t, st.Lag)
+	assert.True(t, st.Connected)
+
+	resp, err := http.Get(srv.URL + "/replication/status")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var leaderStatus replicationStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&leaderStatus))
+	assert.Equal(t, "leader", leaderStatus.Role)
+	require.Len(t, leaderStatus.Replicas, 1)
+	assert.Equal(t, "replica-1", leaderStatus.Replicas[0].ID)
+	assert.True(t, leaderStatus.Replicas[0].Connected)
+}
+
+func TestReplication_Snapshot(t *testing.T) {
+	srv := startLeader(t, datastore.Options{SegmentSize: 200, ReplicationLogSize: 4})
+	dir := t.TempDir()
+	store, err := datastore.Open(dir, 200)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	// локальний ключ, якого немає у лідера, зникне після завантаження знімка
+	require.NoError(t, store.Apply(context.Background(), datastore.LogRecord{
+		Seq: 1, Type: datastore.EventPut, Bucket: datastore.DefaultBucket, Key: "stale", Value: []byte("x"),
+	}))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)))
+	}
+
+	f := startFollower(t, srv.URL, store, dir)
+	waitReplicated(t, store, datastore.DefaultBucket, "key-9", "value-9")
+	for i := 0; i < 10; i++ {
+		v, err := store.Get(fmt.Sprintf("key-%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), v)
+	}
+	_, err = store.Get("stale")
+	assert.True(t, errors.Is(err, datastore.ErrNotFound), "stale key must be removed, got %v", err)
+
+	require.NoError(t, db.Put("after-snapshot", "v"))
+	waitReplicated(t, store, datastore.DefaultBucket, "after-snapshot", "v")
+	assert.Eventually(t, func() bool {
+		return f.status().Lag == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplication_InterruptedSnapshot(t *testing.T) {
+	srv := startLeader(t, datastore.Options{SegmentSize: 200, ReplicationLogSize: 4})
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Put(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)))
+	}
+
+	// репліку зупинено посеред знімка: застосовано лише ключ з найбільшим seq
+	dir := t.TempDir()
+	store, err := datastore.Open(dir, 200)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	require.NoError(t, store.Apply(context.Background(), datastore.LogRecord{
+		Seq: db.LastSeq(), Type: datastore.EventPut, Bucket: datastore.DefaultBucket, Key: "key-9", Value: []byte("value-9"),
+	}))
+	marker := filepath.Join(dir, snapshotMarkerName)
+	require.NoError(t, createMarker(marker))
+
+	f := startFollower(t, srv.URL, store, dir)
+	waitReplicated(t, store, datastore.DefaultBucket, "key-0", "value-0")
+	for i := 0; i < 10; i++ {
+		v, err := store.Get(fmt.Sprintf("key-%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), v)
+	}
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(marker)
+		return errors.Is(err, os.ErrNotExist) && f.status().Seq == db.LastSeq()
+	}, 5*time.Second, 10*time.Millisecond, "snapshot marker must be removed after snapshot-end")
+}
+
+func TestReadOnly(t *testing.T) {
+	defer func() { follower = nil }()
+	follower = &replicaFollower{leader: "http://leader:8081"}
+
+	handler := readOnly(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/db/key", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "http://leader:8081")
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/db/key", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
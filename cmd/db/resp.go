@@ -0,0 +1,707 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+)
+
+const (
+	// respExpireBucket зберігає час завершення TTL ключів як unix-мілісекунди. Будь-яка команда запису
+	// може змінити TTL ключа, тож токен має мати право запису і на ключ з тією ж назвою в цьому бакеті.
+	respExpireBucket = "resp-expire"
+	respKeyLocks     = 256
+	respMaxArgs      = 1024
+	// respMaxLine обмежує inline-команди та рядки заголовків
+	respMaxLine     = 64 * 1024
+	respSweepPeriod = time.Second
+	respScanCount   = 10
+)
+
+var errNotInteger = errors.New("value is not an integer or out of range")
+
+// respError - помилка, яку клієнт отримує як є, з префіксом на кшталт ERR чи WRONGTYPE.
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// respServer обслуговує підмножину протоколу Redis (RESP2) над бакетом бази. Команди, що
+// читають і змінюють ключ, виконуються під блокуванням ключа, тож INCR атомарний відносно
+// інших клієнтів RESP, але не відносно записів через HTTP.
+type respServer struct {
+	bucket *datastore.Bucket
+	expire *datastore.Bucket
+	// maxBulk - найбільший bulk-рядок, який приймає сервер
+	maxBulk int64
+
+	keyLocks [respKeyLocks]sync.Mutex
+
+	lock  sync.Mutex
+	ln    net.Listener
+	conns map[net.Conn]struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newRespServer(store *datastore.Db, bucket string) *respServer {
+	return &respServer{
+		bucket:  store.Bucket(bucket),
+		expire:  store.Bucket(respExpireBucket),
+		maxBulk: store.Options().MaxRecordSize,
+		conns:   make(map[net.Conn]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// serve приймає з'єднання, доки не буде викликано close.
+func (s *respServer) serve(ln net.Listener) error {
+	s.lock.Lock()
+	s.ln = ln
+	s.lock.Unlock()
+
+	s.wg.Add(1)
+	go s.sweepLoop()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		s.lock.Lock()
+		s.conns[conn] = struct{}{}
+		s.lock.Unlock()
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+// close зупиняє прийом з'єднань, обриває відкриті і чекає завершення їхніх команд.
+func (s *respServer) close() error {
+	s.lock.Lock()
+	close(s.done)
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *respServer) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		_ = conn.Close()
+	}()
+
+	in := bufio.NewReader(conn)
+	out := bufio.NewWriter(conn)
+	// p - власник токена з команди AUTH
+	var p *principal
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	for {
+		args, err := readCommand(in, s.maxBulk)
+		if err != nil {
+			var protoErr respError
+			if errors.As(err, &protoErr) {
+				writeError(out, protoErr)
+				_ = out.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToUpper(args[0])
+		switch {
+		case name == "QUIT":
+			writeSimple(out, "OK")
+			_ = out.Flush()
+			return
+		case name == "AUTH":
+			p = s.authenticate(out, conn, args, p)
+		case auth != nil && p == nil && name != "PING":
+			writeError(out, respError("NOAUTH Authentication required."))
+		default:
+			client := host
+			if p != nil {
+				client = "token:" + p.name
+			}
+			s.execute(out, args, p, client)
+		}
+		// при конвеєрній передачі відповідаємо одним пакетом після останньої прочитаної команди
+		if in.Buffered() == 0 {
+			if err := out.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// authenticate обробляє AUTH [username] token; ім'я користувача ігнорується, бо токени
+// мають власні назви. Повертає власника токена або попереднього, якщо токен невірний.
+func (s *respServer) authenticate(w *bufio.Writer, conn net.Conn, args []string, p *principal) *principal {
+	if len(args) < 2 || len(args) > 3 {
+		writeError(w, fmt.Errorf("wrong number of arguments for 'auth' command"))
+		return p
+	}
+	if auth == nil {
+		writeError(w, fmt.Errorf("AUTH called without any password configured"))
+		return p
+	}
+	next := auth.lookup(args[len(args)-1])
+	if next == nil {
+		log.Printf("AUDIT denied token=%q remote=%s resp command=AUTH", "", conn.RemoteAddr())
+		writeError(w, respError("WRONGPASS invalid username-password pair or user is disabled."))
+		return p
+	}
+	writeSimple(w, "OK")
+	return next
+}
+
+// readCommand читає масив bulk-рядків або inline-команду, розділену пробілами.
+// Bulk-рядки, довші за maxBulk, відхиляються як помилка протоколу.
+func readCommand(in *bufio.Reader, maxBulk int64) ([]string, error) {
+	line, err := readLine(in)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > respMaxArgs {
+		return nil, respError("ERR Protocol error: invalid multibulk length")
+	}
+	args := make([]string, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err := readLine(in)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, respError(fmt.Sprintf("ERR Protocol error: expected '$', got '%.1s'", line))
+		}
+		size, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil || size < 0 || size > maxBulk {
+			return nil, respError("ERR Protocol error: invalid bulk length")
+		}
+		// буфер росте разом з отриманими даними, а не виділяється наперед за заявленою довжиною
+		buf, err := io.ReadAll(io.LimitReader(in, size+2))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(buf)) < size+2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine читає рядок не довший за respMaxLine, щоб клієнт не змусив сервер накопичувати його без кінця.
+func readLine(in *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := in.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > respMaxLine {
+			return "", respError("ERR Protocol error: too big inline request")
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func writeSimple(w *bufio.Writer, s string) {
+	_, _ = fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+func writeError(w *bufio.Writer, err error) {
+	msg := strings.ReplaceAll(err.Error(), "\r\n", " ")
+	var re respError
+	if !errors.As(err, &re) {
+		msg = "ERR " + msg
+	}
+	_, _ = fmt.Fprintf(w, "-%s\r\n", msg)
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	_, _ = fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	_, _ = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func writeNull(w *bufio.Writer) {
+	_, _ = w.WriteString("$-1\r\n")
+}
+
+func writeArrayHeader(w *bufio.Writer, n int) {
+	_, _ = fmt.Fprintf(w, "*%d\r\n", n)
+}
+
+type respCommand struct {
+	// arity - мінімальна кількість аргументів разом з назвою команди
+	arity int
+	write bool
+	// keys повертає ключі, до яких звертається команда, для перевірки прав токена
+	keys func(args []string) []string
+	run  func(s *respServer, w *bufio.Writer, args []string) error
+}
+
+func firstKey(args []string) []string { return args[1:2] }
+
+func allKeys(args []string) []string { return args[1:] }
+
+// wholeBucket вимагає доступу до всього бакета.
+func wholeBucket([]string) []string { return []string{""} }
+
+var respCommands = map[string]respCommand{
+	"PING":    {arity: 1, run: (*respServer).ping},
+	"GET":     {arity: 2, keys: firstKey, run: (*respServer).get},
+	"SET":     {arity: 3, write: true, keys: firstKey, run: (*respServer).set},
+	"DEL":     {arity: 2, write: true, keys: allKeys, run: (*respServer).del},
+	"EXISTS":  {arity: 2, keys: allKeys, run: (*respServer).exists},
+	"INCR":    {arity: 2, write: true, keys: firstKey, run: (*respServer).incr},
+	"SCAN":    {arity: 2, keys: wholeBucket, run: (*respServer).scan},
+	"TTL":     {arity: 2, keys: firstKey, run: (*respServer).ttl},
+	"PTTL":    {arity: 2, keys: firstKey, run: (*respServer).ttl},
+	"EXPIRE":  {arity: 3, write: true, keys: firstKey, run: (*respServer).expireCmd},
+	"COMMAND": {arity: 1, run: (*respServer).command},
+}
+
+// execute виконує команду від імені p; p дорівнює nil, якщо автентифікацію вимкнено.
+// client - ідентифікатор клієнта для обмеження частоти записів, як у clientID.
+func (s *respServer) execute(w *bufio.Writer, args []string, p *principal, client string) {
+	name := strings.ToUpper(args[0])
+	cmd, ok := respCommands[name]
+	if !ok {
+		writeError(w, fmt.Errorf("unknown command '%s'", args[0]))
+		return
+	}
+	if len(args) < cmd.arity {
+		writeError(w, fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+	if cmd.write && follower != nil {
+		writeError(w, respError("READONLY You can't write against a read only replica."))
+		return
+	}
+	if p != nil && cmd.keys != nil {
+		scope := scopeRead
+		if cmd.write {
+			scope = scopeWrite
+		}
+		for _, key := range cmd.keys(args) {
+			if !p.allows(scope, s.bucket.Name()+"/"+key) {
+				log.Printf("AUDIT denied token=%q resp command=%s scope=%s key=%q", p.name, name, scope, key)
+				writeError(w, respError(fmt.Sprintf("NOPERM this token has no %s access to '%s'", scope, key)))
+				return
+			}
+			if cmd.write && !p.allows(scope, s.expire.Name()+"/"+key) {
+				log.Printf("AUDIT denied token=%q resp command=%s scope=%s key=%q", p.name, name, scope, s.expire.Name()+"/"+key)
+				writeError(w, respError(fmt.Sprintf("NOPERM this token has no %s access to the TTL of '%s'", scope, key)))
+				return
+			}
+		}
+	}
+	if cmd.write && limiter != nil {
+		if ok, wait := limiter.allow(client); !ok {
+			writeError(w, fmt.Errorf("write rate limit exceeded, retry in %s", wait.Round(time.Millisecond)))
+			return
+		}
+	}
+	args[0] = name
+	if err := cmd.run(s, w, args); err != nil {
+		writeError(w, err)
+	}
+}
+
+func (s *respServer) lockKey(key string) func() {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	lock := &s.keyLocks[h.Sum32()%respKeyLocks]
+	lock.Lock()
+	return lock.Unlock
+}
+
+// deadline повертає час завершення TTL ключа або нульовий час, якщо TTL не задано.
+func (s *respServer) deadline(key string) (time.Time, error) {
+	v, err := s.expire.Get(key)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// lookup читає ключ, вважаючи ключі з простроченим TTL відсутніми; їх прибирає sweepLoop.
+func (s *respServer) lookup(key string) (string, bool, error) {
+	value, err := s.bucket.Get(key)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	deadline, err := s.deadline(key)
+	if err != nil {
+		return "", false, err
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return "", false, nil
+	}
+	return value, true, nil
+}
+
+func (s *respServer) setExpire(key string, deadline time.Time) error {
+	if deadline.IsZero() {
+		err := deleteValue(context.Background(), s.expire, key)
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	return putValue(context.Background(), s.expire, key, []byte(strconv.FormatInt(deadline.UnixMilli(), 10)))
+}
+
+// remove видаляє ключ разом з його TTL; false, якщо ключа не було.
+func (s *respServer) remove(key string) (bool, error) {
+	err := deleteValue(context.Background(), s.bucket, key)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return false, s.setExpire(key, time.Time{})
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, s.setExpire(key, time.Time{})
+}
+
+func (s *respServer) ping(w *bufio.Writer, args []string) error {
+	if len(args) > 1 {
+		writeBulk(w, args[1])
+	} else {
+		writeSimple(w, "PONG")
+	}
+	return nil
+}
+
+func (s *respServer) get(w *bufio.Writer, args []string) error {
+	value, ok, err := s.lookup(args[1])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		writeNull(w)
+		return nil
+	}
+	writeBulk(w, value)
+	return nil
+}
+
+// set підтримує опції EX, PX, NX і XX. Без EX/PX попередній TTL ключа скидається.
+func (s *respServer) set(w *bufio.Writer, args []string) error {
+	key, value := args[1], args[2]
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 == len(args) || ttl != 0 {
+				return respError("ERR syntax error")
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil || n <= 0 {
+				return respError("ERR invalid expire time in 'set' command")
+			}
+			ttl = time.Duration(n) * time.Millisecond
+			if opt == "EX" {
+				ttl = time.Duration(n) * time.Second
+			}
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+	if nx && xx {
+		return respError("ERR syntax error")
+	}
+
+	defer s.lockKey(key)()
+	if nx || xx {
+		_, exists, err := s.lookup(key)
+		if err != nil {
+			return err
+		}
+		if (nx && exists) || (xx && !exists) {
+			writeNull(w)
+			return nil
+		}
+	}
+	if err := putValue(context.Background(), s.bucket, key, []byte(value)); err != nil {
+		return err
+	}
+	var deadline time.Time
+	if ttl > 0 {
+		deadline = time.Now().Add(ttl)
+	}
+	if err := s.setExpire(key, deadline); err != nil {
+		return err
+	}
+	writeSimple(w, "OK")
+	return nil
+}
+
+func (s *respServer) del(w *bufio.Writer, args []string) error {
+	var n int64
+	for _, key := range args[1:] {
+		unlock := s.lockKey(key)
+		_, exists, err := s.lookup(key)
+		if err == nil {
+			var removed bool
+			removed, err = s.remove(key)
+			if removed && exists {
+				n++
+			}
+		}
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	writeInt(w, n)
+	return nil
+}
+
+func (s *respServer) exists(w *bufio.Writer, args []string) error {
+	var n int64
+	for _, key := range args[1:] {
+		_, ok, err := s.lookup(key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			n++
+		}
+	}
+	writeInt(w, n)
+	return nil
+}
+
+// incr збільшує ціле значення ключа на одиницю, зберігаючи його TTL; відсутній ключ вважається нулем.
+func (s *respServer) incr(w *bufio.Writer, args []string) error {
+	key := args[1]
+	defer s.lockKey(key)()
+	value, ok, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+	var n int64
+	if ok {
+		n, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || n == 1<<63-1 {
+			return errNotInteger
+		}
+	} else if err := s.setExpire(key, time.Time{}); err != nil {
+		return err
+	}
+	n++
+	if err := putValue(context.Background(), s.bucket, key, []byte(strconv.FormatInt(n, 10))); err != nil {
+		return err
+	}
+	writeInt(w, n)
+	return nil
+}
+
+// scan використовує як курсор зсув у відсортованому списку ключів. Ключі, додані чи видалені
+// між викликами, можуть зсунути позицію, тож SCAN гарантує не більше, ніж Redis.
+func (s *respServer) scan(w *bufio.Writer, args []string) error {
+	cursor, err := strconv.Atoi(args[1])
+	if err != nil || cursor < 0 {
+		return respError("ERR invalid cursor")
+	}
+	pattern, count := "", respScanCount
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return respError("ERR syntax error")
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+			if _, err := path.Match(pattern, ""); err != nil {
+				return respError("ERR invalid pattern")
+			}
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count <= 0 {
+				return respError("ERR syntax error")
+			}
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+
+	keys := s.bucket.Keys("")
+	end := min(cursor+count, len(keys))
+	var found []string
+	for i := min(cursor, len(keys)); i < end; i++ {
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, keys[i]); !ok {
+				continue
+			}
+		}
+		if _, ok, err := s.lookup(keys[i]); err != nil {
+			return err
+		} else if ok {
+			found = append(found, keys[i])
+		}
+	}
+	next := end
+	if end >= len(keys) {
+		next = 0
+	}
+
+	writeArrayHeader(w, 2)
+	writeBulk(w, strconv.Itoa(next))
+	writeArrayHeader(w, len(found))
+	for _, key := range found {
+		writeBulk(w, key)
+	}
+	return nil
+}
+
+// ttl відповідає на TTL і PTTL: -2 для відсутнього ключа, -1 для ключа без TTL.
+func (s *respServer) ttl(w *bufio.Writer, args []string) error {
+	key := args[1]
+	_, ok, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		writeInt(w, -2)
+		return nil
+	}
+	deadline, err := s.deadline(key)
+	if err != nil {
+		return err
+	}
+	if deadline.IsZero() {
+		writeInt(w, -1)
+		return nil
+	}
+	left := time.Until(deadline)
+	if args[0] == "PTTL" {
+		writeInt(w, left.Milliseconds())
+	} else {
+		writeInt(w, int64((left+time.Second/2)/time.Second))
+	}
+	return nil
+}
+
+// expireCmd задає TTL у секундах; недодатний TTL одразу видаляє ключ, як у Redis.
+func (s *respServer) expireCmd(w *bufio.Writer, args []string) error {
+	key := args[1]
+	seconds, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	defer s.lockKey(key)()
+	_, ok, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		writeInt(w, 0)
+		return nil
+	}
+	if seconds <= 0 {
+		_, err = s.remove(key)
+	} else {
+		err = s.setExpire(key, time.Now().Add(time.Duration(seconds)*time.Second))
+	}
+	if err != nil {
+		return err
+	}
+	writeInt(w, 1)
+	return nil
+}
+
+// command відповідає порожнім списком: redis-cli запитує COMMAND DOCS під час підключення.
+func (s *respServer) command(w *bufio.Writer, args []string) error {
+	writeArrayHeader(w, 0)
+	return nil
+}
+
+// sweepLoop видаляє ключі з простроченим TTL. Репліки й послідовники Raft лише приховують їх:
+// видалення прийде від лідера.
+func (s *respServer) sweepLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(respSweepPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		if follower != nil || (cluster != nil && cluster.node.Leader() != cluster.node.ID()) {
+			continue
+		}
+		s.sweep()
+	}
+}
+
+func (s *respServer) sweep() {
+	for _, key := range s.expire.Keys("") {
+		unlock := s.lockKey(key)
+		deadline, err := s.deadline(key)
+		if err == nil && !deadline.IsZero() && !time.Now().Before(deadline) {
+			_, err = s.remove(key)
+		}
+		unlock()
+		if err != nil && !errors.Is(err, datastore.ErrClosed) {
+			log.Printf("resp: expire %s: %v", key, err)
+		}
+	}
+}
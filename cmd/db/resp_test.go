@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type respClient struct {
+	t    *testing.T
+	conn net.Conn
+	in   *bufio.Reader
+}
+
+func startResp(t *testing.T) (*respServer, *datastore.Db, *respClient) {
+	t.Helper()
+	store, err := datastore.Open(t.TempDir(), 4096)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := newRespServer(store, datastore.DefaultBucket)
+	go func() {
+		_ = s.serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = s.close()
+		_ = store.Close()
+	})
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return s, store, &respClient{t: t, conn: conn, in: bufio.NewReader(conn)}
+}
+
+func (c *respClient) send(args ...string) {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := c.conn.Write([]byte(b.String()))
+	require.NoError(c.t, err)
+}
+
+// reply читає відповідь; nil-рядок повертається як nil, масив - як []any.
+func (c *respClient) reply() any {
+	c.t.Helper()
+	require.NoError(c.t, c.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	line, err := readLine(c.in)
+	require.NoError(c.t, err)
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		require.NoError(c.t, err)
+		return n
+	case '$':
+		size, _ := strconv.Atoi(line[1:])
+		if size < 0 {
+			return nil
+		}
+		buf := make([]byte, size+2)
+		_, err := io.ReadFull(c.in, buf)
+		require.NoError(c.t, err)
+		return string(buf[:size])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		res := []any{}
+		for i := 0; i < n; i++ {
+			res = append(res, c.reply())
+		}
+		return res
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func (c *respClient) do(args ...string) any {
+	c.t.Helper()
+	c.send(args...)
+	return c.reply()
+}
+
+func TestResp_Basic(t *testing.T) {
+	_, store, c := startResp(t)
+
+	assert.Equal(t, "PONG", c.do("PING"))
+	assert.Equal(t, "hello", c.do("ping", "hello"))
+	assert.Nil(t, c.do("GET", "missing"))
+	assert.Equal(t, "OK", c.do("SET", "k", "v 1"))
+	assert.Equal(t, "v 1", c.do("GET", "k"))
+
+	v, err := store.Get("k")
+	require.NoError(t, err)
+	assert.Equal(t, "v 1", v, "keys must be stored in the datastore")
+
+	assert.Nil(t, c.do("SET", "k", "other", "NX"))
+	assert.Nil(t, c.do("SET", "new", "x", "XX"))
+	assert.Equal(t, "OK", c.do("SET", "k", "v2", "XX"))
+	assert.Equal(t, int64(1), c.do("EXISTS", "k", "new"))
+
+	assert.Equal(t, int64(1), c.do("INCR", "counter"))
+	assert.Equal(t, int64(2), c.do("INCR", "counter"))
+	assert.Equal(t, "2", c.do("GET", "counter"))
+	assert.ErrorContains(t, c.do("INCR", "k").(error), "not an integer")
+
+	assert.Equal(t, int64(2), c.do("DEL", "k", "counter", "missing"))
+	assert.Equal(t, int64(0), c.do("EXISTS", "k", "counter"))
+
+	assert.ErrorContains(t, c.do("NOPE").(error), "unknown command")
+	assert.ErrorContains(t, c.do("GET").(error), "wrong number of arguments")
+	assert.ErrorContains(t, c.do("SET", "k", "v", "EX").(error), "syntax error")
+}
+
+func TestResp_Expire(t *testing.T) {
+	s, store, c := startResp(t)
+
+	assert.Equal(t, int64(-2), c.do("TTL", "k"))
+	assert.Equal(t, "OK", c.do("SET", "k", "v"))
+	assert.Equal(t, int64(-1), c.do("TTL", "k"))
+	assert.Equal(t, int64(1), c.do("EXPIRE", "k", "100"))
+	assert.Equal(t, int64(100), c.do("TTL", "k"))
+	assert.Equal(t, int64(0), c.do("EXPIRE", "missing", "100"))
+
+	// SET без EX/PX скидає TTL
+	assert.Equal(t, "OK", c.do("SET", "k", "v"))
+	assert.Equal(t, int64(-1), c.do("TTL", "k"))
+
+	assert.Equal(t, "OK", c.do("SET", "short", "v", "PX", "50"))
+	pttl := c.do("PTTL", "short").(int64)
+	assert.True(t, pttl > 0 && pttl <= 50, "unexpected PTTL %d", pttl)
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, c.do("GET", "short"))
+	assert.Equal(t, int64(-2), c.do("TTL", "short"))
+	assert.Equal(t, int64(0), c.do("DEL", "short"), "expired key must not be counted")
+
+	assert.Equal(t, "OK", c.do("SET", "swept", "v", "PX", "1"))
+	time.Sleep(5 * time.Millisecond)
+	s.sweep()
+	_, err := store.Get("swept")
+	assert.ErrorIs(t, err, datastore.ErrNotFound)
+	assert.Empty(t, store.Bucket(respExpireBucket).Keys(""))
+
+	assert.Equal(t, int64(1), c.do("EXPIRE", "k", "0"))
+	assert.Equal(t, int64(0), c.do("EXISTS", "k"))
+}
+
+func TestResp_Scan(t *testing.T) {
+	_, _, c := startResp(t)
+	for i := 0; i < 25; i++ {
+		assert.Equal(t, "OK", c.do("SET", fmt.Sprintf("user:%02d", i), "v"))
+	}
+	assert.Equal(t, "OK", c.do("SET", "other", "v"))
+
+	var keys []any
+	cursor := "0"
+	for {
+		res := c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "7").([]any)
+		keys = append(keys, res[1].([]any)...)
+		cursor = res[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Len(t, keys, 25)
+	assert.Contains(t, keys, "user:00")
+	assert.NotContains(t, keys, "other")
+}
+
+func TestResp_InlineAndPipeline(t *testing.T) {
+	_, _, c := startResp(t)
+
+	_, err := c.conn.Write([]byte("SET inline value\r\nGET inline\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "OK", c.reply())
+	assert.Equal(t, "value", c.reply())
+
+	c.send("INCR", "n")
+	c.send("INCR", "n")
+	c.send("INCR", "n")
+	assert.Equal(t, int64(1), c.reply())
+	assert.Equal(t, int64(2), c.reply())
+	assert.Equal(t, int64(3), c.reply())
+
+	assert.Equal(t, "OK", c.do("QUIT"))
+}
+
+func TestResp_ProtocolLimits(t *testing.T) {
+	s, _, c := startResp(t)
+
+	for _, packet := range []string{
+		"*1\r\n$9999999999999\r\n",
+		fmt.Sprintf("*1\r\n$%d\r\n", s.maxBulk+1),
+		"*1\r\n$-5\r\n",
+		"*99999999\r\n",
+		strings.Repeat("x", respMaxLine+1) + "\r\n",
+	} {
+		conn, err := net.Dial("tcp", c.conn.RemoteAddr().String())
+		require.NoError(t, err)
+		bad := &respClient{t: t, conn: conn, in: bufio.NewReader(conn)}
+		_, err = conn.Write([]byte(packet))
+		require.NoError(t, err)
+		reply, ok := bad.reply().(error)
+		require.True(t, ok, "expected an error reply for %.20q", packet)
+		assert.Contains(t, reply.Error(), "Protocol error")
+		_, err = bad.in.ReadByte()
+		assert.ErrorIs(t, err, io.EOF, "connection must be closed after a protocol error")
+		_ = conn.Close()
+	}
+
+	assert.Equal(t, "PONG", c.do("PING"))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bohdanbulakh/kpi-lab5/httptools"
+	"github.com/bohdanbulakh/kpi-lab5/signal"
+)
+
+var (
+	port    = flag.Int("port", 8091, "router port")
+	nodes   = flag.String("nodes", "http://db:8081", "comma-separated URLs of db nodes")
+	vnodes  = flag.Int("vnodes", 128, "number of virtual nodes per db node on the hash ring")
+	timeout = flag.Duration("timeout", 10*time.Second, "timeout of a single request to a db node")
+	// -auth-token потрібен, якщо вузли запущені з -auth-config: без нього перенесення ключів отримує 401
+	authToken  = flag.String("auth-token", "", "bearer token of the router's own requests to db nodes when keys are moved (needs write scope)")
+	adminToken = flag.String("admin-token", "", "bearer token required by /router/nodes; without it anyone can change the ring")
+)
+
+func main() {
+	flag.Parse()
+
+	var list []string
+	for _, n := range strings.Split(*nodes, ",") {
+		if n = strings.TrimSuffix(strings.TrimSpace(n), "/"); n != "" {
+			list = append(list, n)
+		}
+	}
+	if len(list) == 0 {
+		log.Fatal("at least one db node is required")
+	}
+
+	rt := newRouter(&http.Client{Timeout: *timeout}, newRing(*vnodes, list...))
+	rt.authToken, rt.adminToken = *authToken, *adminToken
+	if *adminToken == "" {
+		log.Printf("warning: /router/nodes is not protected, set -admin-token")
+	}
+	h := http.NewServeMux()
+	h.HandleFunc("/db/", rt.handleDb)
+	h.HandleFunc("/router/nodes", rt.handleNodes)
+	h.HandleFunc("/health", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("content-type", "text/plain")
+		_, _ = rw.Write([]byte("OK"))
+	})
+
+	server := httptools.CreateServer(*port, h)
+	log.Printf("DB router over %v", list)
+	server.Start()
+	signal.WaitForTerminationSignal()
+}
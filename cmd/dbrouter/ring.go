@@ -0,0 +1,79 @@
+package main
+
+import (
+	"hash/crc32"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// ring - незмінне кільце консистентного хешування. Кожен вузол займає vnodes точок,
+// тож при додаванні чи вилученні вузла переїжджає лише близько 1/N ключів.
+type ring struct {
+	vnodes int
+	points []uint32
+	owners map[uint32]string
+	list   []string
+}
+
+func newRing(vnodes int, nodes ...string) *ring {
+	r := &ring{vnodes: vnodes, owners: make(map[uint32]string)}
+	for _, node := range nodes {
+		r = r.with(node)
+	}
+	return r
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func ringHash(s string) uint32 {
+	return crc32.Checksum([]byte(s), castagnoli)
+}
+
+// with повертає нове кільце з доданим вузлом.
+func (r *ring) with(node string) *ring {
+	if slices.Contains(r.list, node) {
+		return r
+	}
+	return r.rebuild(append(slices.Clone(r.list), node))
+}
+
+// without повертає нове кільце без вузла.
+func (r *ring) without(node string) *ring {
+	return r.rebuild(slices.DeleteFunc(slices.Clone(r.list), func(n string) bool { return n == node }))
+}
+
+func (r *ring) rebuild(nodes []string) *ring {
+	res := &ring{vnodes: r.vnodes, owners: make(map[uint32]string), list: nodes}
+	sort.Strings(res.list)
+	for _, node := range res.list {
+		for i := 0; i < r.vnodes; i++ {
+			p := ringHash(node + "#" + strconv.Itoa(i))
+			// за колізії точку отримує менший за порядком вузол, щоб результат не залежав від порядку додавання
+			if _, taken := res.owners[p]; taken {
+				continue
+			}
+			res.owners[p] = node
+			res.points = append(res.points, p)
+		}
+	}
+	slices.Sort(res.points)
+	return res
+}
+
+// lookup повертає вузол, що відповідає за ключ: власника першої точки за годинниковою стрілкою.
+func (r *ring) lookup(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+func (r *ring) nodes() []string {
+	return slices.Clone(r.list)
+}
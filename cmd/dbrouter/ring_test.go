@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing_Distribution(t *testing.T) {
+	r := newRing(128, "node-a", "node-b", "node-c", "node-d")
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[r.lookup(fmt.Sprintf("key-%d", i))]++
+	}
+	assert.Len(t, counts, 4)
+	for node, n := range counts {
+		assert.InDelta(t, 2500, n, 750, "node %s owns %d keys", node, n)
+	}
+}
+
+func TestRing_OrderIndependent(t *testing.T) {
+	a := newRing(64, "node-a", "node-b", "node-c")
+	b := newRing(64, "node-c", "node-a").with("node-b")
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.Equal(t, a.lookup(key), b.lookup(key))
+	}
+}
+
+func TestRing_AddMovesOnlyToNewNode(t *testing.T) {
+	before := newRing(128, "node-a", "node-b", "node-c")
+	after := before.with("node-d")
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if old, cur := before.lookup(key), after.lookup(key); old != cur {
+			assert.Equal(t, "node-d", cur)
+			moved++
+		}
+	}
+	assert.InDelta(t, 2500, moved, 750)
+
+	// вилучення повертає ключі туди, де вони були
+	back := after.without("node-d")
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.Equal(t, before.lookup(key), back.lookup(key))
+	}
+}
+
+func TestRing_Empty(t *testing.T) {
+	assert.Empty(t, newRing(16).lookup("key"))
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	octetStream    = "application/octet-stream"
+	keyLockStripes = 256
+
+	migrationRetryDelay    = time.Second
+	maxMigrationRetryDelay = 30 * time.Second
+)
+
+var (
+	errMigrationRunning = errors.New("key migration is already running")
+	errRingUnchanged    = errors.New("ring is unchanged")
+)
+
+// migrationStatus описує останню зміну складу кільця. Failed - помилки останнього проходу:
+// міграція повторює проходи, доки один з них не завершиться без помилок.
+type migrationStatus struct {
+	Running    bool       `json:"running"`
+	From       []string   `json:"from,omitempty"`
+	To         []string   `json:"to,omitempty"`
+	Moved      int        `json:"moved"`
+	Failed     int        `json:"failed"`
+	Passes     int        `json:"passes"`
+	LastError  string     `json:"last_error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// router розподіляє ключі між вузлами db за кільцем консистентного хешування. Поки триває
+// міграція, previous містить попереднє кільце: читання, що не знайшли ключ у нового власника,
+// повторюються на старому, а видалення виконуються на обох.
+type router struct {
+	client *http.Client
+	// authToken автентифікує власні запити роутера до вузлів (перенесення ключів), adminToken
+	// захищає /router/nodes; порожній токен вимикає відповідну перевірку
+	authToken  string
+	adminToken string
+
+	lock      sync.RWMutex
+	ring      *ring
+	previous  *ring
+	migration migrationStatus
+
+	// keyLocks впорядковують записи через роутер і перенесення того самого ключа
+	keyLocks [keyLockStripes]sync.Mutex
+	// retryDelay - пауза перед повторним проходом міграції, що мав помилки
+	retryDelay time.Duration
+}
+
+func newRouter(client *http.Client, r *ring) *router {
+	return &router{client: client, ring: r, retryDelay: migrationRetryDelay}
+}
+
+// shardKey - ключ, за яким обирається вузол; назви бакетів не містять "/". Ключ бакета за
+// замовчуванням з "/" доступний і за старим шляхом /db/{key}, де його початок до "/" розбирається
+// як бакет, тож такий ключ шардується за самим ключем, як і цей шлях.
+func shardKey(bucket, key string) string {
+	if bucket == "default" && strings.Contains(key, "/") {
+		return key
+	}
+	return bucket + "/" + key
+}
+
+func (rt *router) keyLock(shard string) *sync.Mutex {
+	return &rt.keyLocks[ringHash(shard)%keyLockStripes]
+}
+
+// owners повертає поточного власника ключа і, якщо під час міграції він інший, попереднього.
+func (rt *router) owners(shard string) (string, string) {
+	rt.lock.RLock()
+	defer rt.lock.RUnlock()
+	owner := rt.ring.lookup(shard)
+	if rt.previous != nil {
+		if prev := rt.previous.lookup(shard); prev != owner {
+			return owner, prev
+		}
+	}
+	return owner, ""
+}
+
+// allNodes повертає вузли поточного і, під час міграції, попереднього кільця.
+func (rt *router) allNodes() []string {
+	rt.lock.RLock()
+	defer rt.lock.RUnlock()
+	nodes := rt.ring.nodes()
+	if rt.previous != nil {
+		for _, n := range rt.previous.nodes() {
+			if !slices.Contains(nodes, n) {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	return nodes
+}
+
+// parseDbPath розбирає /db/{bucket}/{key}; старий шлях /db/{key} веде до бакета за замовчуванням.
+func parseDbPath(path string) (string, string) {
+	rest := strings.TrimPrefix(path, "/db/")
+	if bucket, key, ok := strings.Cut(rest, "/"); ok {
+		return bucket, key
+	}
+	return "default", rest
+}
+
+func (rt *router) handleDb(w http.ResponseWriter, r *http.Request) {
+	bucket, key := parseDbPath(r.URL.Path)
+	if key == "" && r.Method == http.MethodGet && r.URL.Path != "/db/" {
+		rt.listKeys(w, r, bucket)
+		return
+	}
+	if key == "" {
+		http.Error(w, "key required", http.StatusBadRequest)
+		return
+	}
+	shard := shardKey(bucket, key)
+	owner, prev := rt.owners(shard)
+	if owner == "" {
+		http.Error(w, "no db nodes", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		resp, err := rt.send(r, owner, nil)
+		if err == nil && resp.StatusCode == http.StatusNotFound && prev != "" {
+			resp.Body.Close()
+			resp, err = rt.send(r, prev, nil)
+		}
+		rt.reply(w, resp, err)
+
+	case http.MethodPost:
+		lock := rt.keyLock(shard)
+		lock.Lock()
+		defer lock.Unlock()
+		resp, err := rt.send(r, owner, r.Body)
+		rt.reply(w, resp, err)
+
+	case http.MethodDelete:
+		lock := rt.keyLock(shard)
+		lock.Lock()
+		defer lock.Unlock()
+		resp, err := rt.send(r, owner, nil)
+		if err == nil && prev != "" {
+			// ключ може ще лежати на старому вузлі; він видалений, якщо його видалено хоча б на одному
+			old, oldErr := rt.send(r, prev, nil)
+			switch {
+			case oldErr != nil:
+				resp.Body.Close()
+				resp, err = nil, oldErr
+			case resp.StatusCode == http.StatusNotFound:
+				resp.Body.Close()
+				resp = old
+			default:
+				old.Body.Close()
+			}
+		}
+		rt.reply(w, resp, err)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// send повторює запит клієнта на вузлі node разом з умовами запису If-Match та If-None-Match.
+func (rt *router) send(r *http.Request, node string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, node+r.URL.RequestURI(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = r.ContentLength
+	}
+	for _, h := range []string{"Accept", "Content-Type", "Authorization", "If-Match", "If-None-Match"} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	return rt.client.Do(req)
+}
+
+func (rt *router) reply(w http.ResponseWriter, resp *http.Response, err error) {
+	if err != nil {
+		log.Printf("db node request failed: %v", err)
+		http.Error(w, "db node is unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for _, h := range []string{"Content-Type", "Content-Length", "Location", "ETag", "Retry-After"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// listKeys об'єднує списки ключів бакета з усіх вузлів.
+func (rt *router) listKeys(w http.ResponseWriter, r *http.Request, bucket string) {
+	seen := make(map[string]struct{})
+	for _, node := range rt.allNodes() {
+		keys, err := rt.fetchKeys(r.Context(), node, bucket, r.URL.Query().Get("prefix"))
+		if err != nil {
+			log.Printf("list %s on %s: %v", bucket, node, err)
+			http.Error(w, "db node is unavailable", http.StatusBadGateway)
+			return
+		}
+		for _, k := range keys {
+			seen[k] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"bucket": bucket,
+		"keys":   keys,
+	})
+}
+
+func (rt *router) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := rt.doInternal(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (rt *router) fetchKeys(ctx context.Context, node, bucket, prefix string) ([]string, error) {
+	var list struct {
+		Keys []string `json:"keys"`
+	}
+	u := node + "/db/" + url.PathEscape(bucket) + "/?" + url.Values{"prefix": {prefix}}.Encode()
+	err := rt.getJSON(ctx, u, &list)
+	return list.Keys, err
+}
+
+func (rt *router) fetchBuckets(ctx context.Context, node string) ([]string, error) {
+	var stats []struct {
+		Name string `json:"name"`
+	}
+	if err := rt.getJSON(ctx, node+"/db-buckets", &stats); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, s := range stats {
+		names = append(names, s.Name)
+	}
+	return names, nil
+}
+
+// changeRing перемикає роутер на кільце, отримане з поточного через change, і запускає
+// перенесення ключів у фоні.
+func (rt *router) changeRing(change func(*ring) *ring) error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	if rt.previous != nil {
+		return errMigrationRunning
+	}
+	next := change(rt.ring)
+	if slices.Equal(next.nodes(), rt.ring.nodes()) {
+		return errRingUnchanged
+	}
+	if len(next.nodes()) == 0 {
+		return errors.New("the ring must keep at least one node")
+	}
+	now := time.Now()
+	rt.previous, rt.ring = rt.ring, next
+	rt.migration = migrationStatus{Running: true, From: rt.previous.nodes(), To: next.nodes(), StartedAt: &now}
+	go rt.migrate(rt.previous, next)
+	return nil
+}
+
+// migrate переносить з кожного вузла старого кільця ключі, власник яких змінився. Прохід з помилками
+// повторюється з наростаючою паузою, а до того previous лишається, щоб неперенесені ключі були доступні;
+// тож міграція з недоступного вузла чекає, доки він повернеться.
+// Переноситься лише остання версія значення; історія версій лишається на старому вузлі до видалення.
+func (rt *router) migrate(from, to *ring) {
+	delay := rt.retryDelay
+	for !rt.migratePass(from, to) {
+		time.Sleep(delay)
+		delay = min(2*delay, maxMigrationRetryDelay)
+	}
+
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	now := time.Now()
+	rt.previous = nil
+	rt.migration.Running = false
+	rt.migration.FinishedAt = &now
+	log.Printf("key migration finished: moved %d in %d passes", rt.migration.Moved, rt.migration.Passes)
+}
+
+// migratePass робить один прохід міграції і повертає true, якщо всі ключі перенесено.
+func (rt *router) migratePass(from, to *ring) bool {
+	rt.lock.Lock()
+	rt.migration.Passes++
+	rt.migration.Failed = 0
+	rt.lock.Unlock()
+
+	ctx := context.Background()
+	ok := true
+	for _, node := range from.nodes() {
+		buckets, err := rt.fetchBuckets(ctx, node)
+		if err != nil {
+			rt.migrationFailed(fmt.Errorf("list buckets on %s: %w", node, err))
+			ok = false
+			continue
+		}
+		for _, bucket := range buckets {
+			keys, err := rt.fetchKeys(ctx, node, bucket, "")
+			if err != nil {
+				rt.migrationFailed(fmt.Errorf("list %s on %s: %w", bucket, node, err))
+				ok = false
+				continue
+			}
+			for _, key := range keys {
+				target := to.lookup(shardKey(bucket, key))
+				if target == node {
+					continue
+				}
+				if err := rt.moveKey(ctx, node, target, bucket, key); err != nil {
+					rt.migrationFailed(fmt.Errorf("move %s/%s from %s to %s: %w", bucket, key, node, target, err))
+					ok = false
+					continue
+				}
+				rt.lock.Lock()
+				rt.migration.Moved++
+				rt.lock.Unlock()
+			}
+		}
+	}
+	return ok
+}
+
+func (rt *router) migrationFailed(err error) {
+	log.Printf("key migration: %v", err)
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+	rt.migration.Failed++
+	rt.migration.LastError = err.Error()
+}
+
+// moveKey копіює значення на новий вузол, якщо там ще немає свіжішого запису, і видаляє його зі старого.
+func (rt *router) moveKey(ctx context.Context, from, to, bucket, key string) error {
+	lock := rt.keyLock(shardKey(bucket, key))
+	lock.Lock()
+	defer lock.Unlock()
+
+	path := "/db/" + url.PathEscape(bucket) + "/" + url.PathEscape(key)
+	exists, err := rt.do(ctx, http.MethodGet, to+path, nil)
+	if err != nil {
+		return err
+	}
+	exists.Body.Close()
+	if exists.StatusCode == http.StatusNotFound {
+		src, err := rt.do(ctx, http.MethodGet, from+path, nil)
+		if err != nil {
+			return err
+		}
+		defer src.Body.Close()
+		if src.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		if src.StatusCode != http.StatusOK {
+			return fmt.Errorf("read: unexpected status %s", src.Status)
+		}
+		resp, err := rt.do(ctx, http.MethodPost, to+path, src.Body)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("write: unexpected status %s", resp.Status)
+		}
+	}
+
+	resp, err := rt.do(ctx, http.MethodDelete, from+path, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("delete: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// do виконує запит до вузла з сирим значенням ключа.
+func (rt *router) do(ctx context.Context, method, u string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", octetStream)
+	if body != nil {
+		req.Header.Set("Content-Type", octetStream)
+	}
+	return rt.doInternal(req)
+}
+
+// doInternal виконує власний запит роутера, не пов'язаний з запитом клієнта, з токеном роутера.
+func (rt *router) doInternal(req *http.Request) (*http.Response, error) {
+	if rt.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+rt.authToken)
+	}
+	return rt.client.Do(req)
+}
+
+type nodesResponse struct {
+	Nodes     []string        `json:"nodes"`
+	Migration migrationStatus `json:"migration"`
+}
+
+// handleNodes: GET - склад кільця і стан міграції, POST {"url": ...} - додати вузол,
+// DELETE ?url= - вилучити. Зміни складу відповідають 202 і переносять ключі у фоні, а
+// повторне додавання чи вилучення відсутнього вузла - 204. Якщо задано adminToken, усі методи
+// вимагають Authorization: Bearer з ним.
+func (rt *router) handleNodes(w http.ResponseWriter, r *http.Request) {
+	if rt.adminToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(rt.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dbrouter"`)
+			http.Error(w, "valid bearer token required", http.StatusUnauthorized)
+			return
+		}
+	}
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		rt.lock.RLock()
+		resp := nodesResponse{Nodes: rt.ring.nodes(), Migration: rt.migration}
+		rt.lock.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+		return
+	case http.MethodPost:
+		var req struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+			http.Error(w, "url required", http.StatusBadRequest)
+			return
+		}
+		node := strings.TrimSuffix(req.URL, "/")
+		err = rt.changeRing(func(r *ring) *ring {
+			return r.with(node)
+		})
+	case http.MethodDelete:
+		node := strings.TrimSuffix(r.URL.Query().Get("url"), "/")
+		err = rt.changeRing(func(r *ring) *ring {
+			return r.without(node)
+		})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if errors.Is(err, errMigrationRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errRingUnchanged) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDb - вузол db у пам'яті з підмножиною API cmd/db, потрібною роутеру. Версія ключа -
+// номер його останнього запису, як у ETag cmd/db.
+type fakeDb struct {
+	lock     sync.Mutex
+	data     map[string]map[string][]byte
+	versions map[string]uint64
+	seq      uint64
+	// token, якщо заданий, вимагається від усіх запитів, як на вузлі з -auth-config
+	token string
+	// failWrites імітує вузол, що відхиляє записи
+	failWrites bool
+}
+
+func startFakeDb(t *testing.T) (*fakeDb, string) {
+	t.Helper()
+	f := &fakeDb{data: make(map[string]map[string][]byte), versions: make(map[string]uint64)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/db/", f.handleDb)
+	mux.HandleFunc("/db-buckets", f.handleBuckets)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return f, srv.URL
+}
+
+func (f *fakeDb) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (f *fakeDb) handleDb(w http.ResponseWriter, r *http.Request) {
+	bucket, key := parseDbPath(r.URL.Path)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.authorized(w, r) {
+		return
+	}
+
+	if key == "" {
+		keys := []string{}
+		for k := range f.data[bucket] {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"bucket": bucket, "keys": keys})
+		return
+	}
+
+	raw := r.Header.Get("Accept") == octetStream || r.Header.Get("Content-Type") == octetStream
+	switch r.Method {
+	case http.MethodGet:
+		value, ok := f.data[bucket][key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(f.versions[bucket+"/"+key], 10)))
+		if raw {
+			_, _ = w.Write(value)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"bucket": bucket, "key": key, "value": string(value)})
+	case http.MethodPost:
+		if f.failWrites {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, exists := f.data[bucket][key]
+		match := r.Header.Get("If-Match")
+		if (r.Header.Get("If-None-Match") == "*" && exists) ||
+			(match != "" && match != strconv.Quote(strconv.FormatUint(f.versions[bucket+"/"+key], 10))) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		var value []byte
+		if raw {
+			value, _ = io.ReadAll(r.Body)
+		} else {
+			var req struct {
+				Value string `json:"value"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			value = []byte(req.Value)
+		}
+		if f.data[bucket] == nil {
+			f.data[bucket] = make(map[string][]byte)
+		}
+		f.data[bucket][key] = value
+		f.seq++
+		f.versions[bucket+"/"+key] = f.seq
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if _, ok := f.data[bucket][key]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(f.data[bucket], key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeDb) handleBuckets(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.authorized(w, r) {
+		return
+	}
+	var stats []map[string]any
+	for name, keys := range f.data {
+		stats = append(stats, map[string]any{"name": name, "keys": len(keys)})
+	}
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+func (f *fakeDb) has(bucket, key string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, ok := f.data[bucket][key]
+	return ok
+}
+
+func (f *fakeDb) size() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n := 0
+	for _, keys := range f.data {
+		n += len(keys)
+	}
+	return n
+}
+
+func startRouter(t *testing.T, nodes ...string) (*router, string) {
+	t.Helper()
+	rt := newRouter(&http.Client{}, newRing(64, nodes...))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/db/", rt.handleDb)
+	mux.HandleFunc("/router/nodes", rt.handleNodes)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return rt, srv.URL
+}
+
+func put(t *testing.T, base, path, value string) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"value": value})
+	resp, err := http.Post(base+path, "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func get(t *testing.T, base, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(base + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var body struct {
+		Value string `json:"value"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body.Value
+}
+
+func request(t *testing.T, method, u string, body io.Reader) int {
+	t.Helper()
+	req, err := http.NewRequest(method, u, body)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func waitMigration(t *testing.T, rt *router) migrationStatus {
+	t.Helper()
+	var st migrationStatus
+	require.Eventually(t, func() bool {
+		rt.lock.RLock()
+		defer rt.lock.RUnlock()
+		st = rt.migration
+		return !st.Running
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Zero(t, st.Failed, st.LastError)
+	return st
+}
+
+func TestRouter_SpreadsKeys(t *testing.T) {
+	a, urlA := startFakeDb(t)
+	b, urlB := startFakeDb(t)
+	_, base := startRouter(t, urlA, urlB)
+
+	for i := 0; i < 50; i++ {
+		put(t, base, fmt.Sprintf("/db/key-%d", i), fmt.Sprintf("value-%d", i))
+	}
+	put(t, base, "/db/users/alice", "admin")
+
+	assert.Equal(t, 51, a.size()+b.size())
+	assert.Positive(t, a.size())
+	assert.Positive(t, b.size())
+	for i := 0; i < 50; i++ {
+		code, value := get(t, base, fmt.Sprintf("/db/key-%d", i))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), value)
+	}
+	code, value := get(t, base, "/db/users/alice")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "admin", value)
+
+	resp, err := http.Get(base + "/db/default/?prefix=key-1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var list struct {
+		Keys []string `json:"keys"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Len(t, list.Keys, 11)
+
+	assert.Equal(t, http.StatusNoContent, request(t, http.MethodDelete, base+"/db/key-1", nil))
+	code, _ = get(t, base, "/db/key-1")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestRouter_AddAndRemoveNode(t *testing.T) {
+	a, urlA := startFakeDb(t)
+	b, urlB := startFakeDb(t)
+	c, urlC := startFakeDb(t)
+	rt, base := startRouter(t, urlA, urlB)
+
+	for i := 0; i < 100; i++ {
+		put(t, base, fmt.Sprintf("/db/key-%d", i), fmt.Sprintf("value-%d", i))
+	}
+
+	body := strings.NewReader(fmt.Sprintf(`{"url": %q}`, urlC))
+	require.Equal(t, http.StatusAccepted, request(t, http.MethodPost, base+"/router/nodes", body))
+	st := waitMigration(t, rt)
+	assert.Positive(t, st.Moved)
+	assert.Positive(t, c.size())
+	assert.Equal(t, 100, a.size()+b.size()+c.size(), "every key must live on exactly one node")
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner := rt.ring.lookup(shardKey("default", key))
+		for url, node := range map[string]*fakeDb{urlA: a, urlB: b, urlC: c} {
+			assert.Equal(t, url == owner, node.has("default", key))
+		}
+	}
+
+	require.Equal(t, http.StatusAccepted, request(t, http.MethodDelete, base+"/router/nodes?url="+urlA, nil))
+	waitMigration(t, rt)
+	assert.Zero(t, a.size())
+	for i := 0; i < 100; i++ {
+		code, value := get(t, base, fmt.Sprintf("/db/key-%d", i))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), value)
+	}
+
+	assert.Equal(t, http.StatusNoContent, request(t, http.MethodDelete, base+"/router/nodes?url="+urlA, nil))
+}
+
+func TestRouter_DuringMigration(t *testing.T) {
+	a, urlA := startFakeDb(t)
+	_, urlB := startFakeDb(t)
+	rt, base := startRouter(t, urlA)
+	for i := 0; i < 20; i++ {
+		put(t, base, fmt.Sprintf("/db/key-%d", i), "old")
+	}
+
+	// перемикаємо кільце без фонового перенесення, щоб перевірити читання і видалення посередині міграції
+	rt.lock.Lock()
+	rt.previous, rt.ring = rt.ring, rt.ring.with(urlB)
+	rt.migration.Running = true
+	rt.lock.Unlock()
+
+	var moved string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if rt.ring.lookup(shardKey("default", key)) == urlB {
+			moved = key
+			break
+		}
+	}
+	require.NotEmpty(t, moved)
+	code, value := get(t, base, "/db/"+moved)
+	assert.Equal(t, http.StatusOK, code, "read must fall back to the previous owner")
+	assert.Equal(t, "old", value)
+
+	assert.Equal(t, http.StatusNoContent, request(t, http.MethodDelete, base+"/db/"+moved, nil))
+	assert.False(t, a.has("default", moved))
+	code, _ = get(t, base, "/db/"+moved)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	assert.Equal(t, http.StatusConflict, request(t, http.MethodPost, base+"/router/nodes", strings.NewReader(`{"url": "http://other"}`)))
+}
+
+func TestRouter_ConditionalWrites(t *testing.T) {
+	_, urlA := startFakeDb(t)
+	_, urlB := startFakeDb(t)
+	_, base := startRouter(t, urlA, urlB)
+	put(t, base, "/db/users/alice", "admin")
+
+	resp, err := http.Get(base + "/db/users/alice")
+	require.NoError(t, err)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag, "ETag of the node must reach the client")
+
+	conditional := func(header, value, body string) int {
+		req, err := http.NewRequest(http.MethodPost, base+"/db/users/alice", strings.NewReader(`{"value": "`+body+`"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(header, value)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusPreconditionFailed, conditional("If-None-Match", "*", "create"))
+	assert.Equal(t, http.StatusNoContent, conditional("If-Match", etag, "swapped"))
+	assert.Equal(t, http.StatusPreconditionFailed, conditional("If-Match", etag, "stale"), "stale If-Match must be rejected")
+	_, value := get(t, base, "/db/users/alice")
+	assert.Equal(t, "swapped", value)
+}
+
+func TestRouter_AuthTokens(t *testing.T) {
+	var nodes []*fakeDb
+	var urls []string
+	for i := 0; i < 3; i++ {
+		node, u := startFakeDb(t)
+		node.token = "node-secret"
+		nodes, urls = append(nodes, node), append(urls, u)
+	}
+	rt, base := startRouter(t, urls[0], urls[1])
+	rt.authToken, rt.adminToken = "node-secret", "admin-secret"
+
+	send := func(method, path, token string, body io.Reader) int {
+		req, err := http.NewRequest(method, base+path, body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for i := 0; i < 50; i++ {
+		// токен клієнта передається вузлу як є
+		require.Equal(t, http.StatusNoContent, send(http.MethodPost, fmt.Sprintf("/db/key-%d", i), "node-secret", strings.NewReader(`{"value": "v"}`)))
+	}
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodPost, "/db/key-0", "", strings.NewReader(`{"value": "v"}`)))
+
+	addNode := fmt.Sprintf(`{"url": %q}`, urls[2])
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/router/nodes", "", nil))
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodPost, "/router/nodes", "node-secret", strings.NewReader(addNode)))
+	require.Equal(t, http.StatusAccepted, send(http.MethodPost, "/router/nodes", "admin-secret", strings.NewReader(addNode)))
+
+	// перенесення ключів автентифікується токеном роутера
+	st := waitMigration(t, rt)
+	assert.Positive(t, st.Moved)
+	assert.Positive(t, nodes[2].size())
+	assert.Equal(t, 50, nodes[0].size()+nodes[1].size()+nodes[2].size())
+}
+
+func TestRouter_MigrationRetriesFailedMoves(t *testing.T) {
+	a, urlA := startFakeDb(t)
+	c, urlC := startFakeDb(t)
+	rt, base := startRouter(t, urlA)
+	rt.retryDelay = 10 * time.Millisecond
+	for i := 0; i < 30; i++ {
+		put(t, base, fmt.Sprintf("/db/key-%d", i), fmt.Sprintf("value-%d", i))
+	}
+
+	c.lock.Lock()
+	c.failWrites = true
+	c.lock.Unlock()
+	require.Equal(t, http.StatusAccepted, request(t, http.MethodPost, base+"/router/nodes", strings.NewReader(fmt.Sprintf(`{"url": %q}`, urlC))))
+	require.Eventually(t, func() bool {
+		rt.lock.RLock()
+		defer rt.lock.RUnlock()
+		return rt.migration.Passes > 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// поки ключі не перенесено, міграція триває, а читання знаходять їх на старому вузлі
+	rt.lock.RLock()
+	st, previous := rt.migration, rt.previous
+	rt.lock.RUnlock()
+	assert.True(t, st.Running)
+	assert.NotNil(t, previous)
+	for i := 0; i < 30; i++ {
+		code, value := get(t, base, fmt.Sprintf("/db/key-%d", i))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), value)
+	}
+
+	c.lock.Lock()
+	c.failWrites = false
+	c.lock.Unlock()
+	waitMigration(t, rt)
+	assert.Positive(t, c.size())
+	assert.Equal(t, 30, a.size()+c.size())
+	for i := 0; i < 30; i++ {
+		code, value := get(t, base, fmt.Sprintf("/db/key-%d", i))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), value)
+	}
+}
+
+func TestRouter_LegacyPathShard(t *testing.T) {
+	assert.Equal(t, shardKey("dir", "file"), shardKey("default", "dir/file"),
+		"default-bucket key with '/' must shard like its legacy /db/{key} path")
+	assert.Equal(t, "default/key", shardKey("default", "key"))
+
+	nodes := make(map[string]*fakeDb)
+	var urls []string
+	for i := 0; i < 3; i++ {
+		node, u := startFakeDb(t)
+		nodes[u], urls = node, append(urls, u)
+	}
+	rt, base := startRouter(t, urls[0])
+	for i := 0; i < 30; i++ {
+		put(t, base, fmt.Sprintf("/db/default/dir-%d/file", i), "v")
+	}
+	require.Equal(t, http.StatusAccepted, request(t, http.MethodPost, base+"/router/nodes", strings.NewReader(fmt.Sprintf(`{"url": %q}`, urls[1]))))
+	waitMigration(t, rt)
+	require.Equal(t, http.StatusAccepted, request(t, http.MethodPost, base+"/router/nodes", strings.NewReader(fmt.Sprintf(`{"url": %q}`, urls[2]))))
+	waitMigration(t, rt)
+
+	// міграція кладе ключ туди ж, куди роутер надсилає запит /db/dir-N/file
+	for i := 0; i < 30; i++ {
+		bucket, key := parseDbPath(fmt.Sprintf("/db/dir-%d/file", i))
+		owner := rt.ring.lookup(shardKey(bucket, key))
+		assert.True(t, nodes[owner].has("default", fmt.Sprintf("dir-%d/file", i)), "dir-%d/file is not on %s", i, owner)
+	}
+}
@@ -0,0 +1,116 @@
+// Команда dbtool вивантажує і завантажує ключі cmd/db у форматі JSON Lines:
+//
+//	dbtool [-db URL] [-token TOKEN] export [-bucket B] [-prefix P] [-o FILE]
+//	dbtool [-db URL] [-token TOKEN] import [-mode overwrite|skip] [FILE]
+//
+// Без FILE export пише у stdout, а import читає stdin.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bohdanbulakh/kpi-lab5/dbclient"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("dbtool", flag.ContinueOnError)
+	dbAddr := fs.String("db", "http://localhost:8081", "db service address")
+	token := fs.String("token", os.Getenv("DB_TOKEN"), "bearer token for the db service (env DB_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: dbtool [flags] export|import [command flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	client := dbclient.New(*dbAddr, dbclient.Options{Token: *token})
+
+	switch cmd, rest := fs.Arg(0), fs.Args()[1:]; cmd {
+	case "export":
+		return runExport(ctx, client, rest, stdout)
+	case "import":
+		return runImport(ctx, client, rest, stdin)
+	default:
+		return fmt.Errorf("unknown command %q, expected export or import", cmd)
+	}
+}
+
+func runExport(ctx context.Context, client *dbclient.Client, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	bucket := fs.String("bucket", "", "bucket to export (all buckets when empty)")
+	prefix := fs.String("prefix", "", "export only keys with this prefix; requires -bucket")
+	output := fs.String("o", "", "output file (stdout when empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return export(ctx, client, *bucket, *prefix, stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := export(ctx, client, *bucket, *prefix, f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func export(ctx context.Context, client *dbclient.Client, bucket, prefix string, out io.Writer) error {
+	if err := client.Export(ctx, bucket, prefix, out); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	return nil
+}
+
+func runImport(ctx context.Context, client *dbclient.Client, args []string, stdin io.Reader) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	mode := fs.String("mode", string(dbclient.ImportOverwrite), "what to do with existing keys: overwrite or skip")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *mode != string(dbclient.ImportOverwrite) && *mode != string(dbclient.ImportSkipExisting) {
+		return fmt.Errorf("unknown mode %q, expected overwrite or skip", *mode)
+	}
+
+	in := stdin
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	res, err := client.Import(ctx, in, dbclient.ImportMode(*mode))
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	log.Printf("imported %d records, skipped %d existing", res.Imported, res.Skipped)
+	return nil
+}
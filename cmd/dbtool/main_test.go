@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDb зберігає рядки імпорту і віддає їх при експорті, як /db-import і /db-export у cmd/db.
+type fakeDb struct {
+	lines []string
+	query string
+}
+
+func (f *fakeDb) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.query = r.URL.RawQuery
+	switch r.URL.Path {
+	case "/db-export":
+		_, _ = io.WriteString(w, strings.Join(f.lines, ""))
+	case "/db-import":
+		data, _ := io.ReadAll(r.Body)
+		skipped := 0
+		for _, line := range strings.SplitAfter(string(data), "\n") {
+			if line == "" {
+				continue
+			}
+			if r.URL.Query().Get("mode") == "skip" && strings.Contains(line, `"old"`) {
+				skipped++
+				continue
+			}
+			f.lines = append(f.lines, line)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]int{"imported": len(f.lines), "skipped": skipped})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRun(t *testing.T) {
+	f := &fakeDb{}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	ctx := context.Background()
+
+	input := `{"bucket":"users","key":"alice","value":"1"}` + "\n" + `{"bucket":"users","key":"old","value":"2"}` + "\n"
+	file := filepath.Join(t.TempDir(), "dump.jsonl")
+	require.NoError(t, os.WriteFile(file, []byte(input), 0o600))
+
+	require.NoError(t, run(ctx, []string{"-db", srv.URL, "import", "-mode", "skip", file}, nil, io.Discard))
+	assert.Equal(t, "mode=skip", f.query)
+	assert.Len(t, f.lines, 1)
+
+	require.NoError(t, run(ctx, []string{"-db", srv.URL, "import"}, strings.NewReader(input), io.Discard))
+	assert.Len(t, f.lines, 3)
+
+	var out bytes.Buffer
+	require.NoError(t, run(ctx, []string{"-db", srv.URL, "export", "-bucket", "users", "-prefix", "a"}, nil, &out))
+	assert.Equal(t, "bucket=users&prefix=a", f.query)
+	assert.Equal(t, 3, strings.Count(out.String(), "\n"))
+
+	require.NoError(t, run(ctx, []string{"-db", srv.URL, "export", "-o", file}, nil, io.Discard))
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, out.String(), string(data))
+
+	assert.ErrorContains(t, run(ctx, []string{"-db", srv.URL, "import", "-mode", "merge"}, nil, io.Discard), "unknown mode")
+	assert.ErrorContains(t, run(ctx, []string{"-db", srv.URL, "rename"}, nil, io.Discard), "unknown command")
+}
@@ -1,31 +1,39 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
-	"fmt"
-	"io"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/bohdanbulakh/kpi-lab5/dbclient"
 	"github.com/bohdanbulakh/kpi-lab5/httptools"
 	"github.com/bohdanbulakh/kpi-lab5/signal"
 )
 
-var port = flag.Int("port", 8080, "server port")
+var (
+	port   = flag.Int("port", 8080, "server port")
+	dbAddr = flag.String("db", "http://db:8081", "db service address")
+	// токен можна передати і через змінну оточення, щоб він не потрапляв у список процесів
+	dbToken = flag.String("db-token", os.Getenv("DB_TOKEN"), "bearer token for the db service (env DB_TOKEN)")
+)
 
 const confResponseDelaySec = "CONF_RESPONSE_DELAY_SEC"
 const confHealthFailure = "CONF_HEALTH_FAILURE"
 
-const dbURL = "http://db:8081/db"
+// seedTimeout - скільки часу сервер намагається записати teamName, поки db запускається
+const seedTimeout = 30 * time.Second
 
 const teamName = "dreamteam"
 
 func main() {
 	flag.Parse()
+	client := dbclient.New(*dbAddr, dbclient.Options{Token: *dbToken})
 	h := new(http.ServeMux)
 
 	h.HandleFunc("/health", func(rw http.ResponseWriter, r *http.Request) {
@@ -40,10 +48,21 @@ func main() {
 	})
 
 	go func() {
-		time.Sleep(1 * time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), seedTimeout)
+		defer cancel()
 		now := time.Now().Format("2006-01-02")
-		payload, _ := json.Marshal(map[string]string{"value": now})
-		_, _ = http.Post(fmt.Sprintf("%s/%s", dbURL, teamName), "application/json", bytes.NewReader(payload))
+		for {
+			err := client.Put(ctx, dbclient.DefaultBucket, teamName, now)
+			if err == nil {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				log.Printf("Failed to seed %s: %s", teamName, err)
+				return
+			case <-time.After(time.Second):
+			}
+		}
 	}()
 
 	h.HandleFunc("/api/v1/some-data", func(rw http.ResponseWriter, r *http.Request) {
@@ -58,18 +77,20 @@ func main() {
 			return
 		}
 
-		resp, err := http.Get(fmt.Sprintf("%s/%s", dbURL, key))
-		if err != nil || resp.StatusCode != http.StatusOK {
+		item, err := client.Get(r.Context(), dbclient.DefaultBucket, key)
+		if errors.Is(err, dbclient.ErrNotFound) {
 			http.NotFound(rw, r)
 			return
 		}
-		defer resp.Body.Close()
-
-		body, _ := io.ReadAll(resp.Body)
+		if err != nil {
+			log.Printf("Failed to read %s from db: %s", key, err)
+			http.Error(rw, "db is unavailable", http.StatusBadGateway)
+			return
+		}
 
 		rw.Header().Set("content-type", "application/json")
 		rw.WriteHeader(http.StatusOK)
-		_, _ = rw.Write(body)
+		_ = json.NewEncoder(rw).Encode(item)
 	})
 
 	report := make(Report)
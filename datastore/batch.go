@@ -0,0 +1,265 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Batch - набір змін, які WriteBatch записує атомарно: читачі бачать або всі зміни пакета, або жодної.
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	kind               entryKind
+	bucket, key, value string
+}
+
+func (b *Batch) Put(bucket, key, value string) {
+	b.ops = append(b.ops, batchOp{kind: entryPut, bucket: bucket, key: key, value: value})
+}
+
+func (b *Batch) Delete(bucket, key string) {
+	b.ops = append(b.ops, batchOp{kind: entryDelete, bucket: bucket, key: key})
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// BatchError вказує операцію, через яку пакет не записано.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// WriteBatch записує зміни пакета по порядку. Якщо хоч одна зміна неможлива (наприклад, видалення
+// відсутнього ключа), не записується жодна, а помилка - *BatchError з індексом цієї зміни.
+func (db *Db) WriteBatch(ctx context.Context, b *Batch) error {
+	reqs := make([]writeRequest, len(b.ops))
+	for i, op := range b.ops {
+		if !ValidBucketName(op.bucket) {
+			return &BatchError{Index: i, Err: ErrInvalidBucket}
+		}
+		reqs[i] = writeRequest{kind: op.kind, bucket: op.bucket, key: op.key,
+			value: strings.NewReader(op.value), size: int64(len(op.value))}
+	}
+	return db.writeBatch(ctx, reqs, false)
+}
+
+// ApplyBatch атомарно застосовує записи з уже призначеними номерами послідовності, як Apply.
+// Видалення відсутніх ключів пропускаються.
+func (db *Db) ApplyBatch(ctx context.Context, recs []LogRecord) error {
+	reqs := make([]writeRequest, len(recs))
+	for i, rec := range recs {
+		if !ValidBucketName(rec.Bucket) {
+			return &BatchError{Index: i, Err: ErrInvalidBucket}
+		}
+		if rec.Seq == 0 {
+			return fmt.Errorf("cannot apply record without a sequence number")
+		}
+		reqs[i] = writeRequest{bucket: rec.Bucket, key: rec.Key, seq: rec.Seq}
+		switch rec.Type {
+		case EventPut:
+			reqs[i].kind = entryPut
+			reqs[i].value, reqs[i].size = bytes.NewReader(rec.Value), int64(len(rec.Value))
+		case EventDelete:
+			reqs[i].kind = entryDelete
+		default:
+			return fmt.Errorf("cannot apply %s record in a batch", rec.Type)
+		}
+	}
+	return db.writeBatch(ctx, reqs, true)
+}
+
+func (db *Db) writeBatch(ctx context.Context, reqs []writeRequest, skipMissing bool) error {
+	if len(reqs) == 0 {
+		return nil
+	}
+	return db.write(ctx, writeRequest{batch: reqs, skipMissing: skipMissing})
+}
+
+// batchRecord - закодований запис пакета, ще не доданий до індексу.
+type batchRecord struct {
+	meta    recordMeta
+	size    int64
+	indexed *cappedBuffer
+}
+
+// performBatch дописує всі записи пакета до сегмента одним викликом Write, після чого оновлює
+// індекс під одним утриманням indexLock. Записи обрамлені маркерами початку і завершення,
+// тож пакет, обірваний збоєм, відновлення відкидає цілком.
+func (db *Db) performBatch(req *writeRequest) error {
+	var buf bytes.Buffer
+	begin, err := writeBatchMarker(&buf, entryBatchBegin, db.opts.Hash)
+	if err != nil {
+		return err
+	}
+	records, err := db.encodeBatch(req, &buf)
+	if err != nil || len(records) == 0 {
+		return err
+	}
+	commit, err := writeBatchMarker(&buf, entryBatchCommit, db.opts.Hash)
+	if err != nil {
+		return err
+	}
+	total := int64(buf.Len())
+	if total > db.segmentMaxSize {
+		return fmt.Errorf("%w: batch of %d bytes", ErrRecordTooLarge, total)
+	}
+
+	db.outLock.Lock()
+	defer db.outLock.Unlock()
+
+	if db.outOffset+total > db.segmentMaxSize && !db.outEmpty() {
+		db.indexLock.Lock()
+		err := db.rotateSegment()
+		db.indexLock.Unlock()
+		if err != nil {
+			return fmt.Errorf("rotation failed: %w", err)
+		}
+	}
+
+	if _, err := db.out.Write(buf.Bytes()); err != nil {
+		_ = db.out.Truncate(db.outOffset)
+		db.log.Warn("batch write failed", "records", len(records), "offset", db.outOffset, "err", err)
+		return err
+	}
+	if db.opts.Sync == SyncAlways {
+		if err := db.out.Sync(); err != nil {
+			_ = db.out.Truncate(db.outOffset)
+			return fmt.Errorf("sync failed: %w", err)
+		}
+	}
+
+	db.log.Debug("batch written", "records", len(records),
+		"segment", filepath.Base(db.out.Name()), "offset", db.outOffset)
+
+	db.indexLock.Lock()
+	offset := db.outOffset + begin
+	for _, rec := range records {
+		ref := recordRef{file: db.out.Name(), offset: offset, size: rec.size, seq: rec.meta.seq}
+		db.updateIndex(rec.meta, ref)
+		db.replLog.append(logEntry{ref: ref, kind: rec.meta.kind, bucket: rec.meta.bucket, key: rec.meta.key})
+		if rec.indexed != nil || rec.meta.kind != entryPut {
+			var indexedValue []byte
+			if rec.indexed != nil {
+				indexedValue = rec.indexed.bytes()
+			}
+			db.updateSecondary(rec.meta, indexedValue)
+		}
+		offset += rec.size
+	}
+	db.outOffset = offset + commit
+	db.indexLock.Unlock()
+	return nil
+}
+
+// BatchOverhead повертає кількість байтів, яку пакет займає на диску понад свої записи.
+func (db *Db) BatchOverhead() int64 {
+	return 2 * recordSize(0, 0, DefaultBucket, db.opts.Hash)
+}
+
+func writeBatchMarker(w io.Writer, kind entryKind, hash HashAlgorithm) (int64, error) {
+	return writeEntry(w, recordMeta{kind: kind, bucket: DefaultBucket, hash: hash}, strings.NewReader(""), 0)
+}
+
+// encodeBatch перевіряє зміни пакета з урахуванням попередніх змін того ж пакета і кодує їх у buf.
+// Записам без seq призначаються наступні номери; в req.batch лишаються лише записані зміни.
+func (db *Db) encodeBatch(req *writeRequest, buf *bytes.Buffer) ([]batchRecord, error) {
+	db.indexLock.RLock()
+	defer db.indexLock.RUnlock()
+
+	var (
+		records []batchRecord
+		applied []writeRequest
+	)
+	seq := db.seq
+	exists := make(map[bucketKey]bool)
+	// sizes - розміри записів ключів після попередніх змін пакета, growth - зміна живих байтів бакетів,
+	// lastPut - індекс останнього локального put бакета, на який вказує помилка квоти
+	sizes := make(map[bucketKey]int64)
+	growth := make(map[string]int64)
+	lastPut := make(map[string]int)
+	for i, r := range req.batch {
+		if len(r.key) > db.opts.MaxKeySize {
+			return nil, &BatchError{Index: i, Err: fmt.Errorf("%w: %d bytes", ErrKeyTooLarge, len(r.key))}
+		}
+		if db.opts.MaxValueSize > 0 && r.size > db.opts.MaxValueSize {
+			return nil, &BatchError{Index: i, Err: fmt.Errorf("%w: value of %d bytes", ErrRecordTooLarge, r.size)}
+		}
+		if dataLen := recordSize(len(r.key), r.size, r.bucket, db.opts.Hash); dataLen > db.maxRecordSize {
+			return nil, &BatchError{Index: i, Err: fmt.Errorf("%w: %d bytes", ErrRecordTooLarge, dataLen)}
+		}
+
+		k := bucketKey{r.bucket, r.key}
+		found, ok := exists[k]
+		if !ok {
+			_, found = db.index[r.bucket][r.key]
+		}
+		if r.kind == entryDelete && !found {
+			if req.skipMissing {
+				continue
+			}
+			return nil, &BatchError{Index: i, Err: ErrNotFound}
+		}
+		exists[k] = r.kind == entryPut
+
+		if r.seq == 0 {
+			r.seq = seq + 1
+		}
+		seq = max(seq, r.seq)
+
+		value := r.value
+		if r.kind != entryPut {
+			value, r.size = strings.NewReader(""), 0
+		}
+		rec := batchRecord{meta: recordMeta{key: r.key, kind: r.kind, seq: r.seq, bucket: r.bucket, hash: db.opts.Hash}}
+		if r.kind == entryPut && db.hasSecondary(r.bucket) {
+			rec.indexed = &cappedBuffer{limit: maxIndexedValueSize}
+			value = io.TeeReader(value, rec.indexed)
+		}
+		n, err := writeEntry(buf, rec.meta, value, r.size)
+		if err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		rec.size = n
+		records = append(records, rec)
+		applied = append(applied, r)
+
+		prev, ok := sizes[k]
+		if !ok {
+			prev = db.index[r.bucket][r.key].size
+		}
+		if r.kind == entryPut {
+			sizes[k] = n
+			growth[r.bucket] += n - prev
+			if req.batch[i].seq == 0 {
+				lastPut[r.bucket] = i
+			}
+		} else {
+			sizes[k] = 0
+			growth[r.bucket] -= prev
+		}
+	}
+	// квота перевіряється для підсумкового стану, бо пакет записується цілком
+	for bucket, i := range lastPut {
+		if err := db.checkQuota(bucket, growth[bucket]); err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+	}
+	req.batch = applied
+	return records, nil
+}
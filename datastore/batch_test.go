@@ -0,0 +1,196 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDb_WriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := db.Put("old", "x"); err != nil {
+		t.Fatal(err)
+	}
+	seq := db.LastSeq()
+
+	var b Batch
+	b.Put(DefaultBucket, "a", "1")
+	b.Put("users", "alice", "admin")
+	b.Delete(DefaultBucket, "old")
+	b.Put(DefaultBucket, "tmp", "t")
+	b.Delete(DefaultBucket, "tmp")
+	if err := db.WriteBatch(ctx, &b); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.LastSeq(); got != seq+5 {
+		t.Errorf("every record must get its own seq: expected %d, got %d", seq+5, got)
+	}
+
+	check := func(db *Db) {
+		t.Helper()
+		if v, _ := db.Get("a"); v != "1" {
+			t.Errorf("expected a=1, got %q", v)
+		}
+		if v, _ := db.Bucket("users").Get("alice"); v != "admin" {
+			t.Errorf("expected users/alice=admin, got %q", v)
+		}
+		for _, key := range []string{"old", "tmp"} {
+			if _, err := db.Get(key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected %s to be deleted, got %v", key, err)
+			}
+		}
+	}
+	check(db)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(dir, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(db)
+}
+
+func TestDb_WriteBatchIsAtomic(t *testing.T) {
+	db, err := Open(t.TempDir(), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	ctx := context.Background()
+	events := db.Watch("")
+	defer db.Unwatch(events)
+
+	var b Batch
+	b.Put(DefaultBucket, "a", "1")
+	b.Delete(DefaultBucket, "missing")
+	err = db.WriteBatch(ctx, &b)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 1 || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for operation 1, got %v", err)
+	}
+	if _, err := db.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("failed batch must not write anything, got %v", err)
+	}
+	if size, _ := db.Size(); size != segmentHeaderSize {
+		t.Errorf("failed batch must not touch the segment, size %d", size)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("failed batch must not publish events, got %+v", ev)
+	default:
+	}
+
+	b = Batch{}
+	b.Put("bad/bucket", "k", "v")
+	if err := db.WriteBatch(ctx, &b); !errors.Is(err, ErrInvalidBucket) {
+		t.Errorf("expected ErrInvalidBucket, got %v", err)
+	}
+
+	b = Batch{}
+	for i := 0; i < 100; i++ {
+		b.Put(DefaultBucket, fmt.Sprintf("key-%d", i), "0123456789")
+	}
+	if err := db.WriteBatch(ctx, &b); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("batch larger than a segment must be refused, got %v", err)
+	}
+}
+
+func TestDb_TornBatchDiscarded(t *testing.T) {
+	// зсуви обриву відносно кінця пакета: посеред запису, перед маркером завершення, посеред маркера
+	commitSize := recordSize(0, 0, DefaultBucket, HashSHA1)
+	for name, cut := range map[string]int64{
+		"mid record":    commitSize + 5,
+		"before commit": commitSize,
+		"mid commit":    commitSize / 2,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := Open(dir, 4096)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Put("before", "x"); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := db.Size()
+
+			var b Batch
+			b.Put(DefaultBucket, "a", "1")
+			b.Put(DefaultBucket, "b", "2")
+			b.Delete(DefaultBucket, "before")
+			if err := db.WriteBatch(context.Background(), &b); err != nil {
+				t.Fatal(err)
+			}
+			size, _ := db.Size()
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(filepath.Join(dir, outFileName), size-cut); err != nil {
+				t.Fatal(err)
+			}
+
+			db, err = Open(dir, 4096)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for _, key := range []string{"a", "b"} {
+				if _, err := db.Get(key); !errors.Is(err, ErrNotFound) {
+					t.Errorf("key %s of a torn batch must not be recovered, got %v", key, err)
+				}
+			}
+			if v, err := db.Get("before"); err != nil || v != "x" {
+				t.Errorf("delete of a torn batch must not be applied, got %q, %v", v, err)
+			}
+			if size, _ := db.Size(); size != before {
+				t.Errorf("torn batch must be truncated: size %d, expected %d", size, before)
+			}
+
+			if err := db.Put("after", "y"); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := db.Get("after"); err != nil || v != "y" {
+				t.Errorf("write after recovery: got %q, %v", v, err)
+			}
+		})
+	}
+}
+
+func TestDb_ApplyBatch(t *testing.T) {
+	db, err := Open(t.TempDir(), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	err = db.ApplyBatch(context.Background(), []LogRecord{
+		{Seq: 7, Type: EventPut, Bucket: DefaultBucket, Key: "a", Value: []byte("1")},
+		{Seq: 7, Type: EventDelete, Bucket: DefaultBucket, Key: "missing"},
+		{Seq: 7, Type: EventPut, Bucket: DefaultBucket, Key: "b", Value: []byte("2")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.LastSeq() != 7 {
+		t.Errorf("expected seq 7, got %d", db.LastSeq())
+	}
+	if v, seq, _ := db.GetWithSeq("b"); v != "2" || seq != 7 {
+		t.Errorf("unexpected b=%q seq %d", v, seq)
+	}
+}
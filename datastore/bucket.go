@@ -0,0 +1,242 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DefaultBucket = "default"
+
+const maxBucketNameLen = 255
+
+var ErrInvalidBucket = fmt.Errorf("invalid bucket name")
+
+// Bucket - іменований простір ключів всередині однієї бази.
+type Bucket struct {
+	db   *Db
+	name string
+}
+
+type BucketStats struct {
+	Name      string `json:"name"`
+	Keys      int    `json:"keys"`
+	LiveBytes int64  `json:"live_bytes"`
+	// Quota - обмеження LiveBytes з Options.Quotas; 0 - без обмеження
+	Quota int64 `json:"quota,omitempty"`
+}
+
+func ValidBucketName(name string) bool {
+	return name != "" && len(name) <= maxBucketNameLen && !strings.ContainsAny(name, "/\x00")
+}
+
+func (db *Db) Bucket(name string) *Bucket {
+	return &Bucket{db: db, name: name}
+}
+
+// Buckets повертає назви всіх непорожніх бакетів.
+func (db *Db) Buckets() []string {
+	db.indexLock.RLock()
+	defer db.indexLock.RUnlock()
+
+	res := make([]string, 0, len(db.index))
+	for name := range db.index {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// DropBucket видаляє бакет разом з усіма його ключами одним записом у журналі.
+func (db *Db) DropBucket(name string) error {
+	if !ValidBucketName(name) {
+		return ErrInvalidBucket
+	}
+	return db.write(context.Background(), writeRequest{kind: entryDropBucket, bucket: name})
+}
+
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+func (b *Bucket) Put(key, value string) error {
+	return b.PutContext(context.Background(), key, value)
+}
+
+func (b *Bucket) PutContext(ctx context.Context, key, value string) error {
+	return b.PutStreamContext(ctx, key, strings.NewReader(value), int64(len(value)))
+}
+
+func (b *Bucket) PutStream(key string, value io.Reader, size int64) error {
+	return b.PutStreamContext(context.Background(), key, value, size)
+}
+
+func (b *Bucket) PutStreamContext(ctx context.Context, key string, value io.Reader, size int64) error {
+	if !ValidBucketName(b.name) {
+		return ErrInvalidBucket
+	}
+	return b.db.write(ctx, writeRequest{kind: entryPut, bucket: b.name, key: key, value: value, size: size})
+}
+
+func (b *Bucket) CompareAndSwap(key string, version uint64, value string) error {
+	return b.CompareAndSwapContext(context.Background(), key, version, value)
+}
+
+// CompareAndSwapContext записує значення, лише якщо поточна версія ключа дорівнює version;
+// version 0 означає, що ключа ще не має бути. Інакше повертає ErrVersionMismatch.
+func (b *Bucket) CompareAndSwapContext(ctx context.Context, key string, version uint64, value string) error {
+	if !ValidBucketName(b.name) {
+		return ErrInvalidBucket
+	}
+	return b.db.write(ctx, writeRequest{
+		kind:     entryPut,
+		bucket:   b.name,
+		key:      key,
+		value:    strings.NewReader(value),
+		size:     int64(len(value)),
+		checkSeq: true,
+		ifSeq:    version,
+	})
+}
+
+func (b *Bucket) Delete(key string) error {
+	return b.DeleteContext(context.Background(), key)
+}
+
+func (b *Bucket) DeleteContext(ctx context.Context, key string) error {
+	if !ValidBucketName(b.name) {
+		return ErrInvalidBucket
+	}
+	return b.db.write(ctx, writeRequest{kind: entryDelete, bucket: b.name, key: key})
+}
+
+func (b *Bucket) Get(key string) (string, error) {
+	return b.GetContext(context.Background(), key)
+}
+
+func (b *Bucket) GetContext(ctx context.Context, key string) (string, error) {
+	value, _, err := b.GetWithSeqContext(ctx, key)
+	return value, err
+}
+
+func (b *Bucket) GetWithSeq(key string) (string, uint64, error) {
+	return b.GetWithSeqContext(context.Background(), key)
+}
+
+func (b *Bucket) GetWithSeqContext(ctx context.Context, key string) (string, uint64, error) {
+	if err := b.db.checkRead(ctx); err != nil {
+		return "", 0, err
+	}
+	defer b.db.metrics.gets.observe(time.Now())
+
+	ref, ok := b.db.lookup(b.name, key)
+	if !ok {
+		return "", 0, ErrNotFound
+	}
+	value, err := b.db.readValue(ref)
+	if err != nil {
+		return "", 0, err
+	}
+	return value, ref.seq, nil
+}
+
+func (b *Bucket) GetVersion(key string, seq uint64) (string, error) {
+	if b.db.closed() {
+		return "", ErrClosed
+	}
+	defer b.db.metrics.gets.observe(time.Now())
+
+	b.db.indexLock.RLock()
+	ref, ok := b.db.index[b.name][key]
+	if !ok || ref.seq != seq {
+		ok = false
+		for _, old := range b.db.history[bucketKey{b.name, key}] {
+			if old.seq == seq {
+				ref, ok = old, true
+				break
+			}
+		}
+	}
+	b.db.indexLock.RUnlock()
+
+	if !ok {
+		return "", ErrNotFound
+	}
+	return b.db.readValue(ref)
+}
+
+func (b *Bucket) Versions(key string) []uint64 {
+	b.db.indexLock.RLock()
+	defer b.db.indexLock.RUnlock()
+
+	var res []uint64
+	for _, ref := range b.db.history[bucketKey{b.name, key}] {
+		res = append(res, ref.seq)
+	}
+	if ref, ok := b.db.index[b.name][key]; ok {
+		res = append(res, ref.seq)
+	}
+	return res
+}
+
+func (b *Bucket) GetStream(key string) (io.ReadCloser, error) {
+	if b.db.closed() {
+		return nil, ErrClosed
+	}
+	defer b.db.metrics.gets.observe(time.Now())
+
+	ref, ok := b.db.lookup(b.name, key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return b.db.openStream(ref)
+}
+
+// Keys повертає відсортовані ключі бакета, що починаються з prefix.
+func (b *Bucket) Keys(prefix string) []string {
+	b.db.indexLock.RLock()
+	defer b.db.indexLock.RUnlock()
+
+	var res []string
+	for key := range b.db.index[b.name] {
+		if strings.HasPrefix(key, prefix) {
+			res = append(res, key)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (b *Bucket) Stats() BucketStats {
+	b.db.indexLock.RLock()
+	defer b.db.indexLock.RUnlock()
+
+	stats := BucketStats{Name: b.name, Quota: b.db.opts.Quotas.limit(b.name)}
+	for _, ref := range b.db.index[b.name] {
+		stats.Keys++
+		stats.LiveBytes += ref.size
+	}
+	return stats
+}
+
+// Exists повідомляє, чи є в бакеті ключ key, не читаючи значення.
+func (b *Bucket) Exists(key string) bool {
+	_, ok := b.db.lookup(b.name, key)
+	return ok
+}
+
+// CheckQuota перевіряє, чи вміститься в квоту бакета значення розміром size для key, не записуючи його.
+// Потрібна, коли запис застосовується з призначеним seq і квоту вже не перевіряє сама база.
+func (b *Bucket) CheckQuota(key string, size int64) error {
+	b.db.indexLock.RLock()
+	defer b.db.indexLock.RUnlock()
+	growth := recordSize(len(key), size, b.name, b.db.opts.Hash) - b.db.index[b.name][key].size
+	return b.db.checkQuota(b.name, growth)
+}
+
+func (b *Bucket) Watch(prefix string) <-chan Event {
+	return b.db.watch(b.name, prefix)
+}
@@ -0,0 +1,142 @@
+package datastore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	teamA, teamB := db.Bucket("team-a"), db.Bucket("team-b")
+	_ = db.Put("shared", "default")
+	_ = teamA.Put("shared", "a")
+	_ = teamA.Put("a:1", "a1")
+	_ = teamB.Put("shared", "b")
+
+	t.Run("isolation", func(t *testing.T) {
+		for _, tc := range []struct {
+			bucket *Bucket
+			value  string
+		}{{db.Bucket(DefaultBucket), "default"}, {teamA, "a"}, {teamB, "b"}} {
+			if v, err := tc.bucket.Get("shared"); err != nil || v != tc.value {
+				t.Errorf("%s: Get(shared) = %q, %v", tc.bucket.Name(), v, err)
+			}
+		}
+		if _, err := teamB.Get("a:1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("key leaked between buckets: %v", err)
+		}
+	})
+
+	t.Run("listing and stats", func(t *testing.T) {
+		if buckets := db.Buckets(); !reflect.DeepEqual(buckets, []string{DefaultBucket, "team-a", "team-b"}) {
+			t.Errorf("unexpected buckets %v", buckets)
+		}
+		if keys := teamA.Keys(""); !reflect.DeepEqual(keys, []string{"a:1", "shared"}) {
+			t.Errorf("unexpected keys %v", keys)
+		}
+		if !teamA.Exists("shared") || teamA.Exists("missing") {
+			t.Error("Exists does not match the bucket keys")
+		}
+		if keys := teamA.Keys("a:"); !reflect.DeepEqual(keys, []string{"a:1"}) {
+			t.Errorf("unexpected keys with prefix %v", keys)
+		}
+		stats := teamA.Stats()
+		if stats.Keys != 2 || stats.LiveBytes <= 0 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("compaction keeps buckets", func(t *testing.T) {
+		if err := db.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := teamB.Get("shared"); err != nil || v != "b" {
+			t.Errorf("team-b: Get(shared) after compaction = %q, %v", v, err)
+		}
+	})
+
+	t.Run("drop bucket", func(t *testing.T) {
+		events := teamA.Watch("")
+		defer db.Unwatch(events)
+
+		if err := db.DropBucket("team-a"); err != nil {
+			t.Fatal(err)
+		}
+		if ev := receive(t, events); ev.Type != EventDropBucket || ev.Bucket != "team-a" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+		if err := db.DropBucket("team-a"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for dropped bucket, got %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		db, err = Open(dir, 200)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+		if _, err := db.Bucket("team-a").Get("shared"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("dropped bucket came back after reopen: %v", err)
+		}
+		if v, err := db.Bucket("team-b").Get("shared"); err != nil || v != "b" {
+			t.Errorf("team-b: Get(shared) = %q, %v", v, err)
+		}
+		if v, err := db.Get("shared"); err != nil || v != "default" {
+			t.Errorf("default: Get(shared) = %q, %v", v, err)
+		}
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		bad := db.Bucket("a/b")
+		for name, err := range map[string]error{
+			"Put":            bad.Put("k", "v"),
+			"CompareAndSwap": bad.CompareAndSwap("k", 0, "v"),
+			"Delete":         bad.Delete("k"),
+		} {
+			if !errors.Is(err, ErrInvalidBucket) {
+				t.Errorf("%s: expected ErrInvalidBucket, got %v", name, err)
+			}
+		}
+	})
+}
+
+func TestBucket_CompareAndSwap(t *testing.T) {
+	db, err := Open(t.TempDir(), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	b := db.Bucket("cas")
+
+	if err := b.CompareAndSwap("k", 0, "v1"); err != nil {
+		t.Fatalf("create with version 0: %v", err)
+	}
+	if err := b.CompareAndSwap("k", 0, "again"); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("create of an existing key: %v", err)
+	}
+	_, version, err := b.GetWithSeq("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CompareAndSwap("k", version+1, "v2"); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("stale version: %v", err)
+	}
+	if err := b.CompareAndSwap("k", version, "v2"); err != nil {
+		t.Errorf("matching version: %v", err)
+	}
+	if v, _ := b.Get("k"); v != "v2" {
+		t.Errorf("Get(k) = %q, want v2", v)
+	}
+}
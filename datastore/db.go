@@ -2,48 +2,114 @@ package datastore
 
 import (
 	"bufio"
-	"crypto/sha1"
-	"encoding/hex"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
-const outFileName = "current-data"
+const (
+	outFileName        = "current-data"
+	compactingFileName = "segment-compacting"
+)
 
-var ErrNotFound = fmt.Errorf("record does not exist")
+var (
+	ErrNotFound       = fmt.Errorf("record does not exist")
+	ErrHashMismatch   = fmt.Errorf("data integrity error: hash mismatch")
+	ErrRecordTooLarge = fmt.Errorf("record is too large")
+	ErrClosed         = fmt.Errorf("datastore is closed")
+	// ErrVersionMismatch повертає CompareAndSwap, якщо поточна версія ключа відрізняється від очікуваної
+	ErrVersionMismatch = fmt.Errorf("version does not match")
+	// ErrQuotaExceeded - запис перевищив би обмеження живих байтів бакета з Options.Quotas
+	ErrQuotaExceeded = fmt.Errorf("bucket quota exceeded")
+)
 
 type recordRef struct {
 	file   string
 	offset int64
+	size   int64
+	seq    uint64
 }
 
 type hashIndex map[string]recordRef
 
+type bucketKey struct {
+	bucket, key string
+}
+
 type writeRequest struct {
-	key   string
-	value string
-	resp  chan error
+	ctx    context.Context
+	kind   entryKind
+	bucket string
+	key    string
+	value  io.Reader
+	size   int64
+	// seq задається лише для записів, отриманих від лідера реплікації
+	seq uint64
+	// якщо checkSeq, запис виконується лише тоді, коли поточна версія ключа дорівнює ifSeq (0 - ключа немає)
+	checkSeq bool
+	ifSeq    uint64
+	// batch - зміни пакета, які записуються атомарно; решта полів тоді не використовується
+	batch       []writeRequest
+	skipMissing bool
+	resp        chan error
 }
 
 type Db struct {
-	out            *os.File
-	outOffset      int64
-	index          hashIndex
-	indexLock      sync.RWMutex
-	segments       []string
+	out *os.File
+	// outOffset, segments і headers змінюються під outLock та indexLock, тож для читання
+	// достатньо indexLock, який писач не утримує, поки копіює значення у файл
+	outOffset int64
+	outLock   sync.Mutex
+	index     map[string]hashIndex
+	// bucketBytes - сума розмірів живих записів кожного бакета для перевірки квот
+	bucketBytes map[string]int64
+	history     map[bucketKey][]recordRef
+	historySize int
+	secondary   map[string]*secondaryIndex
+	indexLock   sync.RWMutex
+	segments    []string
+	// headers - заголовки запечатаних сегментів і current-data
+	headers        map[string]segmentHeader
 	segmentMaxSize int64
+	maxRecordSize  int64
+	nextSegment    int
 	dir            string
+	sealed         map[string]*sealedSegment
+	seq            uint64
+	replLog        replicationLog
+	watchers       watchers
+	metrics        metrics
+	log            *slog.Logger
+	opts           Options
 
-	writeChan chan writeRequest
-	quitChan  chan struct{}
+	// closeLock захищає closing і не дає закрити writeChan, поки в нього ще відправляють запити.
+	closeLock  sync.RWMutex
+	closing    bool
+	writeChan  chan writeRequest
+	writerDone chan struct{}
+	quitChan   chan struct{}
+	background sync.WaitGroup
 }
 
 func Open(dir string, maxSize int64) (*Db, error) {
+	return OpenWithOptions(dir, Options{SegmentSize: maxSize})
+}
+
+func OpenWithOptions(dir string, opts Options) (*Db, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
 	outputPath := filepath.Join(dir, outFileName)
 	f, err := os.OpenFile(outputPath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
 	if err != nil {
@@ -52,114 +118,492 @@ func Open(dir string, maxSize int64) (*Db, error) {
 	db := &Db{
 		out:            f,
 		dir:            dir,
-		index:          make(hashIndex),
-		segmentMaxSize: maxSize,
-		writeChan:      make(chan writeRequest),
+		index:          make(map[string]hashIndex),
+		bucketBytes:    make(map[string]int64),
+		history:        make(map[bucketKey][]recordRef),
+		historySize:    opts.HistorySize,
+		secondary:      make(map[string]*secondaryIndex),
+		log:            opts.Logger,
+		opts:           opts,
+		sealed:         make(map[string]*sealedSegment),
+		headers:        make(map[string]segmentHeader),
+		segmentMaxSize: opts.SegmentSize,
+		maxRecordSize:  opts.MaxRecordSize,
+		nextSegment:    1,
+		replLog:        newReplicationLog(opts.ReplicationLogSize),
+		watchers:       watchers{list: make(map[<-chan Event]*watcher)},
+		writeChan:      make(chan writeRequest, opts.WriteQueueSize),
+		writerDone:     make(chan struct{}),
 		quitChan:       make(chan struct{}),
 	}
-	err = db.recover()
-	if err != nil && err != io.EOF {
+	if err := db.init(); err != nil {
+		_ = f.Close()
+		db.unmapAll()
 		return nil, err
 	}
+	db.log.Info("datastore opened", "dir", dir, "segments", len(db.segments), "seq", db.seq)
 
 	go db.writerLoop()
+	if opts.Sync == SyncInterval {
+		db.background.Add(1)
+		go db.syncLoop()
+	}
+	if opts.Compaction.Interval > 0 {
+		db.background.Add(1)
+		go db.compactionLoop()
+	}
 
 	return db, nil
 }
 
+func (db *Db) init() error {
+	for _, spec := range db.opts.Indexes {
+		idx, err := newSecondaryIndex(spec)
+		if err != nil {
+			return err
+		}
+		if _, exists := db.secondary[spec.Name]; exists {
+			return fmt.Errorf("%w: duplicate index %s", ErrInvalidOptions, spec.Name)
+		}
+		db.secondary[spec.Name] = idx
+	}
+	err := db.recover()
+	if err != nil && err != io.EOF {
+		db.log.Error("recovery failed", "dir", db.dir, "err", err)
+		return err
+	}
+	if err := db.rebuildSecondary(); err != nil {
+		db.log.Error("secondary index rebuild failed", "dir", db.dir, "err", err)
+		return err
+	}
+	db.replLog.reset(db.seq)
+	return nil
+}
+
+// writerLoop обробляє запити, доки Close не закриє writeChan, тож усі прийняті записи отримують відповідь.
 func (db *Db) writerLoop() {
-	for {
-		select {
-		case req := <-db.writeChan:
-			err := db.performPut(req.key, req.value)
+	defer close(db.writerDone)
+	for req := range db.writeChan {
+		// клієнт міг піти, поки запит чекав у черзі; такий запис не застосовується
+		if err := req.ctx.Err(); err != nil {
 			req.resp <- err
-		case <-db.quitChan:
-			return
+			continue
 		}
+		if req.batch != nil {
+			err := db.performBatch(&req)
+			if err == nil {
+				for _, r := range req.batch {
+					db.written(r, r.seq)
+				}
+			}
+			req.resp <- err
+			continue
+		}
+		seq := db.seq + 1
+		if req.seq != 0 {
+			seq = req.seq
+		}
+		err := db.performWrite(req, seq)
+		if err == nil {
+			db.written(req, seq)
+		}
+		req.resp <- err
 	}
 }
 
-func (db *Db) performPut(key, value string) error {
-	e := entry{key: key, value: value}
-	data := e.Encode()
-	dataLen := int64(len(data)) // Додано визначення довжини даних
+// written оновлює номер послідовності і сповіщає спостерігачів про записану зміну.
+func (db *Db) written(req writeRequest, seq uint64) {
+	db.seq = max(db.seq, seq)
+	ev := Event{Type: EventPut, Bucket: req.bucket, Key: req.key, Seq: seq}
+	switch req.kind {
+	case entryDelete:
+		ev.Type = EventDelete
+	case entryDropBucket:
+		ev.Type = EventDropBucket
+	}
+	db.publish(ev)
+}
 
-	db.indexLock.Lock()
-	defer db.indexLock.Unlock()
+func (db *Db) performWrite(req writeRequest, seq uint64) error {
+	key, value, size := req.key, req.value, req.size
+	if len(key) > db.opts.MaxKeySize {
+		return fmt.Errorf("%w: %d bytes", ErrKeyTooLarge, len(key))
+	}
+	if db.opts.MaxValueSize > 0 && size > db.opts.MaxValueSize {
+		return fmt.Errorf("%w: value of %d bytes", ErrRecordTooLarge, size)
+	}
+	if req.checkSeq {
+		db.indexLock.RLock()
+		ref, ok := db.index[req.bucket][key]
+		db.indexLock.RUnlock()
+		var current uint64
+		if ok {
+			current = ref.seq
+		}
+		if current != req.ifSeq {
+			return ErrVersionMismatch
+		}
+	}
+	if req.kind != entryPut {
+		db.indexLock.RLock()
+		_, ok := db.index[req.bucket][key]
+		if req.kind == entryDropBucket {
+			_, ok = db.index[req.bucket]
+		}
+		db.indexLock.RUnlock()
+		if !ok {
+			return ErrNotFound
+		}
+		value, size = strings.NewReader(""), 0
+	}
+
+	dataLen := recordSize(len(key), size, req.bucket, db.opts.Hash)
+	if dataLen > db.maxRecordSize {
+		return fmt.Errorf("%w: %d bytes", ErrRecordTooLarge, dataLen)
+	}
+	if req.kind == entryPut && req.seq == 0 {
+		db.indexLock.RLock()
+		growth := dataLen - db.index[req.bucket][key].size
+		err := db.checkQuota(req.bucket, growth)
+		db.indexLock.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Запис іде під outLock, тож читання не блокуються, поки значення передається потоком
+	db.outLock.Lock()
+	defer db.outLock.Unlock()
 
 	// Виправлено умову: перевіряємо, чи додавання нового запису перевищить ліміт
-	if db.outOffset+dataLen > db.segmentMaxSize {
-		if err := db.rotateSegment(); err != nil {
+	if db.outOffset+dataLen > db.segmentMaxSize && !db.outEmpty() {
+		db.indexLock.Lock()
+		err := db.rotateSegment()
+		db.indexLock.Unlock()
+		if err != nil {
 			return fmt.Errorf("rotation failed: %w", err)
 		}
 	}
 
-	n, err := db.out.Write(data)
+	var indexed *cappedBuffer
+	if req.kind == entryPut && db.hasSecondary(req.bucket) {
+		indexed = &cappedBuffer{limit: maxIndexedValueSize}
+		value = io.TeeReader(value, indexed)
+	}
+
+	meta := recordMeta{key: key, kind: req.kind, seq: seq, bucket: req.bucket, hash: db.opts.Hash}
+	n, err := writeEntry(db.out, meta, value, size)
 	if err != nil {
+		// Відкидаємо частково записаний запис
+		_ = db.out.Truncate(db.outOffset)
+		db.log.Warn("write failed", "bucket", req.bucket, "key", key, "offset", db.outOffset, "err", err)
 		return err
 	}
+	if db.opts.Sync == SyncAlways {
+		if err := db.out.Sync(); err != nil {
+			_ = db.out.Truncate(db.outOffset)
+			return fmt.Errorf("sync failed: %w", err)
+		}
+	}
+
+	db.log.Debug("record written", "op", req.kind.String(), "bucket", req.bucket, "key", key,
+		"segment", filepath.Base(db.out.Name()), "offset", db.outOffset, "seq", seq)
 
-	db.index[key] = recordRef{
+	ref := recordRef{
 		file:   db.out.Name(),
 		offset: db.outOffset,
+		size:   n,
+		seq:    seq,
 	}
-	db.outOffset += int64(n)
+	db.indexLock.Lock()
+	db.updateIndex(meta, ref)
+	db.replLog.append(logEntry{ref: ref, kind: req.kind, bucket: req.bucket, key: key})
+	if indexed != nil || req.kind != entryPut {
+		var indexedValue []byte
+		if indexed != nil {
+			indexedValue = indexed.bytes()
+		}
+		db.updateSecondary(meta, indexedValue)
+	}
+	db.outOffset += n
+	db.indexLock.Unlock()
 
 	return nil
 }
 
-func (db *Db) Put(key, value string) error {
-	resp := make(chan error)
-	db.writeChan <- writeRequest{key: key, value: value, resp: resp}
-	err := <-resp
+// updateIndex викликається з утриманим indexLock; попередня версія ключа переходить в історію.
+func (db *Db) updateIndex(meta recordMeta, ref recordRef) {
+	if meta.kind == entryDropBucket {
+		for key := range db.index[meta.bucket] {
+			delete(db.history, bucketKey{meta.bucket, key})
+		}
+		delete(db.index, meta.bucket)
+		delete(db.bucketBytes, meta.bucket)
+		return
+	}
+
+	keys := db.index[meta.bucket]
+	old, exists := keys[meta.key]
+	if exists {
+		db.bucketBytes[meta.bucket] -= old.size
+	}
+	if exists && db.historySize > 0 {
+		hk := bucketKey{meta.bucket, meta.key}
+		versions := append(db.history[hk], old)
+		if len(versions) > db.historySize {
+			versions = versions[len(versions)-db.historySize:]
+		}
+		db.history[hk] = versions
+	}
+	if meta.kind == entryDelete {
+		delete(keys, meta.key)
+		if len(keys) == 0 {
+			delete(db.index, meta.bucket)
+			delete(db.bucketBytes, meta.bucket)
+		}
+		return
+	}
+	db.bucketBytes[meta.bucket] += ref.size
+	if keys == nil {
+		keys = make(hashIndex)
+		db.index[meta.bucket] = keys
+	}
+	keys[meta.key] = ref
+}
+
+// checkQuota перевіряє, чи вміститься в квоту бакета зміна його живих байтів на growth.
+// Викликається з утриманим indexLock.
+func (db *Db) checkQuota(bucket string, growth int64) error {
+	limit := db.opts.Quotas.limit(bucket)
+	if limit == 0 || growth <= 0 {
+		return nil
+	}
+	if usage := db.bucketBytes[bucket] + growth; usage > limit {
+		return fmt.Errorf("%w: bucket %s would use %d of %d bytes", ErrQuotaExceeded, bucket, usage, limit)
+	}
+	return nil
+}
 
+func (db *Db) hasSecondary(bucket string) bool {
+	for _, idx := range db.secondary {
+		if idx.spec.Bucket == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *Db) lookup(bucket, key string) (recordRef, bool) {
 	db.indexLock.RLock()
-	ref, ok := db.index[key]
-	db.indexLock.RUnlock()
-	fmt.Printf("PUT DONE: key=%s, ok=%v, file=%s, offset=%d\n", key, ok, ref.file, ref.offset)
+	defer db.indexLock.RUnlock()
+	ref, ok := db.index[bucket][key]
+	return ref, ok
+}
 
-	return err
+// write передає запит writerLoop і чекає на результат. Якщо ctx завершився, коли запит
+// вже потрапив до writerLoop, запис все одно може бути застосований.
+func (db *Db) write(ctx context.Context, req writeRequest) error {
+	start := time.Now()
+	switch {
+	case req.batch != nil:
+		defer db.metrics.batches.observe(start)
+	case req.kind == entryPut:
+		defer db.metrics.puts.observe(start)
+	default:
+		defer db.metrics.deletes.observe(start)
+	}
+
+	req.ctx = ctx
+	req.resp = make(chan error, 1)
+	if err := db.enqueue(req); err != nil {
+		return err
+	}
+
+	// writerLoop відповідає на кожен прийнятий запит, навіть під час Close
+	select {
+	case err := <-req.resp:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (db *Db) enqueue(req writeRequest) error {
+	db.closeLock.RLock()
+	defer db.closeLock.RUnlock()
+	if db.closing {
+		return ErrClosed
+	}
+
+	db.metrics.pending.Add(1)
+	defer db.metrics.pending.Add(-1)
+	select {
+	case db.writeChan <- req:
+		return nil
+	case <-req.ctx.Done():
+		return req.ctx.Err()
+	}
+}
+
+func (db *Db) closed() bool {
+	db.closeLock.RLock()
+	defer db.closeLock.RUnlock()
+	return db.closing
+}
+
+// checkRead повертає помилку, якщо читання вже не має сенсу: ctx завершився або базу закрито.
+func (db *Db) checkRead(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if db.closed() {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (db *Db) Put(key, value string) error {
+	return db.Bucket(DefaultBucket).Put(key, value)
+}
+
+// PutContext записує значення у бакет за замовчуванням, припиняючи очікування, коли завершується ctx.
+func (db *Db) PutContext(ctx context.Context, key, value string) error {
+	return db.Bucket(DefaultBucket).PutContext(ctx, key, value)
+}
+
+func (db *Db) PutStream(key string, value io.Reader, size int64) error {
+	return db.Bucket(DefaultBucket).PutStream(key, value, size)
+}
+
+func (db *Db) Delete(key string) error {
+	return db.Bucket(DefaultBucket).Delete(key)
 }
 
 func (db *Db) Get(key string) (string, error) {
+	value, _, err := db.GetWithSeq(key)
+	return value, err
+}
+
+func (db *Db) GetContext(ctx context.Context, key string) (string, error) {
+	return db.Bucket(DefaultBucket).GetContext(ctx, key)
+}
+
+// GetWithSeq повертає значення разом з номером послідовності запису, яким його було збережено.
+func (db *Db) GetWithSeq(key string) (string, uint64, error) {
+	return db.Bucket(DefaultBucket).GetWithSeq(key)
+}
+
+// GetVersion повертає значення ключа, записане з номером послідовності seq, якщо ця версія ще зберігається.
+func (db *Db) GetVersion(key string, seq uint64) (string, error) {
+	return db.Bucket(DefaultBucket).GetVersion(key, seq)
+}
+
+// Versions повертає номери послідовності всіх збережених версій ключа, від найстарішої.
+func (db *Db) Versions(key string) []uint64 {
+	return db.Bucket(DefaultBucket).Versions(key)
+}
+
+func (db *Db) readValue(ref recordRef) (string, error) {
 	db.indexLock.RLock()
-	ref, ok := db.index[key]
+	seg := db.sealed[ref.file]
+	var record entry
+	var err error
+	if seg != nil {
+		record, err = seg.read(ref.offset)
+	}
 	db.indexLock.RUnlock()
 
-	if !ok {
-		fmt.Printf("GET: key=%s NOT FOUND in index\n", key)
-		return "", ErrNotFound
+	if seg == nil {
+		record, err = db.readRecordFromFile(ref)
 	}
-	file, err := os.Open(ref.file)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
 
-	_, err = file.Seek(ref.offset, 0)
-	if err != nil {
+	return db.verifyRecord(record, ref)
+}
+
+func (db *Db) verifyRecord(record entry, ref recordRef) (string, error) {
+	h := newHash(record.hashAlgo)
+	if h != nil {
+		h.Write([]byte(record.value))
+	}
+	if err := verifyChecksum(record.hashAlgo, h, record.hash); err != nil {
+		db.log.Error("hash mismatch", "key", record.key, "segment", filepath.Base(ref.file), "offset", ref.offset)
 		return "", err
 	}
+	return record.value, nil
+}
 
+// GetStream повертає значення як потік; хеш перевіряється, коли потік дочитано до кінця.
+func (db *Db) GetStream(key string) (io.ReadCloser, error) {
+	return db.Bucket(DefaultBucket).GetStream(key)
+}
+
+func (db *Db) openStream(ref recordRef) (io.ReadCloser, error) {
+	file, err := os.Open(ref.file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(ref.offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	r, err := newValueReader(bufio.NewReader(file), file, db.maxRecordSize)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (db *Db) readRecordFromFile(ref recordRef) (entry, error) {
 	var record entry
-	if _, err = record.DecodeFromReader(bufio.NewReader(file)); err != nil {
-		return "", err
+	file, err := os.Open(ref.file)
+	if err != nil {
+		return record, err
 	}
+	defer file.Close()
 
-	hash := sha1.Sum([]byte(record.value))
-	if record.hash != hex.EncodeToString(hash[:]) {
-		return "", fmt.Errorf("data integrity error: hash mismatch")
+	_, err = file.Seek(ref.offset, 0)
+	if err != nil {
+		return record, err
 	}
 
-	return record.value, nil
+	_, err = record.decodeFromReader(bufio.NewReader(file), db.maxRecordSize)
+	return record, err
+}
+
+// mapSegment відображає запечатаний сегмент у пам'ять; якщо це неможливо, читання йде через файл.
+func (db *Db) mapSegment(path string) {
+	seg, err := openSealedSegment(path)
+	if err != nil {
+		db.log.Warn("segment is read through file", "segment", filepath.Base(path), "err", err)
+		return
+	}
+	db.sealed[path] = seg
+}
+
+func (db *Db) unmapSegment(path string) {
+	if seg, ok := db.sealed[path]; ok {
+		_ = seg.close()
+		delete(db.sealed, path)
+	}
 }
 
 func (db *Db) recover() error {
+	// тимчасовий файл компакції, перерваної збоєм, ще не став сегментом
+	if err := os.Remove(filepath.Join(db.dir, compactingFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
 	files, err := filepath.Glob(filepath.Join(db.dir, "segment-*"))
 	if err != nil {
 		return err
 	}
-	sort.Strings(files)
+	files = sortSegments(files)
+	if len(files) > 0 {
+		db.nextSegment = segmentID(files[len(files)-1]) + 1
+	}
 	files = append(files, filepath.Join(db.dir, outFileName))
 
 	for _, file := range files {
@@ -169,46 +613,140 @@ func (db *Db) recover() error {
 		}
 		defer f.Close()
 
-		var offset int64
 		in := bufio.NewReader(f)
+		header, err := readSegmentHeader(in)
+		if err != nil {
+			return fmt.Errorf("segment %s: %w", filepath.Base(file), err)
+		}
+		if header.maxRecordSize > db.maxRecordSize {
+			db.log.Warn("segment was written with a larger max record size", "segment", filepath.Base(file),
+				"max_record_size", header.maxRecordSize)
+		}
+		db.headers[file] = header
+		offset := header.size
+		isOut := filepath.Base(file) == outFileName
+		// записи пакета застосовуються лише після його маркера завершення; batchStart - зсув маркера початку
+		batchStart := int64(-1)
+		var pending []recoveredRecord
 		for {
-			var record entry
-			n, err := record.DecodeFromReader(in)
+			meta, err := decodeMeta(in, db.maxRecordSize)
 			if errors.Is(err, io.EOF) {
 				break
 			}
 			if err != nil {
+				// обірваний запис наприкінці current-data в межах пакета відкидається разом із пакетом
+				if batchStart >= 0 && isOut {
+					break
+				}
 				return err
 			}
-			db.index[record.key] = recordRef{file: file, offset: offset}
-			offset += int64(n)
+			ref := recordRef{file: file, offset: offset, size: meta.size, seq: meta.seq}
+			offset += meta.size
+			switch {
+			case meta.kind == entryBatchBegin:
+				batchStart, pending = ref.offset, pending[:0]
+			case meta.kind == entryBatchCommit:
+				for _, rec := range pending {
+					db.recoverRecord(rec.meta, rec.ref)
+				}
+				batchStart, pending = -1, pending[:0]
+			case batchStart >= 0:
+				pending = append(pending, recoveredRecord{meta: meta, ref: ref})
+			default:
+				db.recoverRecord(meta, ref)
+			}
 		}
-		if filepath.Base(file) != outFileName {
+		if batchStart >= 0 {
+			db.log.Warn("incomplete batch discarded", "segment", filepath.Base(file), "offset", batchStart,
+				"records", len(pending))
+			offset = batchStart
+			if isOut {
+				if err := os.Truncate(file, batchStart); err != nil {
+					return fmt.Errorf("cannot truncate incomplete batch: %w", err)
+				}
+			}
+		}
+		if !isOut {
 			db.segments = append(db.segments, file)
+			db.mapSegment(file)
 		} else {
 			db.outOffset = offset
 		}
 	}
+	// у новий або порожній current-data записуємо заголовок поточної версії
+	if db.outOffset == 0 {
+		return db.startOut()
+	}
+	return nil
+}
+
+type recoveredRecord struct {
+	meta recordMeta
+	ref  recordRef
+}
+
+func (db *Db) recoverRecord(meta recordMeta, ref recordRef) {
+	// Запис старішої версії (наприклад, після компакції) не перекриває новішу
+	if cur, ok := db.index[meta.bucket][meta.key]; !ok || meta.seq == 0 || meta.seq >= cur.seq {
+		db.updateIndex(meta, ref)
+	}
+	if meta.seq > db.seq {
+		db.seq = meta.seq
+	}
+}
+
+// startOut записує заголовок у щойно створений current-data.
+func (db *Db) startOut() error {
+	header := newSegmentHeader(db.opts)
+	if _, err := db.out.Write(header.encode()); err != nil {
+		return fmt.Errorf("cannot write segment header: %w", err)
+	}
+	db.headers[db.out.Name()] = header
+	db.outOffset = header.size
 	return nil
 }
 
+// outEmpty повідомляє, чи в current-data ще немає записів.
+func (db *Db) outEmpty() bool {
+	return db.outOffset <= db.headers[db.out.Name()].size
+}
+
 func (db *Db) rotateSegment() error {
 	if err := db.out.Close(); err != nil {
 		return err
 	}
-	segmentName := fmt.Sprintf("segment-%d", len(db.segments)+1)
-	newPath := filepath.Join(db.dir, segmentName)
-	if err := os.Rename(filepath.Join(db.dir, outFileName), newPath); err != nil {
+	newPath := db.newSegmentPath()
+	outPath := filepath.Join(db.dir, outFileName)
+	if err := os.Rename(outPath, newPath); err != nil {
 		return err
 	}
+	// Записи з current-data тепер лежать у новому сегменті
+	for _, keys := range db.index {
+		for key, ref := range keys {
+			if ref.file == outPath {
+				ref.file = newPath
+				keys[key] = ref
+			}
+		}
+	}
+	for _, versions := range db.history {
+		for i := range versions {
+			if versions[i].file == outPath {
+				versions[i].file = newPath
+			}
+		}
+	}
+	db.replLog.rename(outPath, newPath)
+	db.headers[newPath] = db.headers[outPath]
 	db.segments = append(db.segments, newPath)
+	db.mapSegment(newPath)
+	db.log.Info("segment sealed", "segment", filepath.Base(newPath), "size", db.outOffset)
 	f, err := os.OpenFile(filepath.Join(db.dir, outFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
 	if err != nil {
 		return err
 	}
 	db.out = f
-	db.outOffset = 0
-	return nil
+	return db.startOut()
 }
 
 func (db *Db) Size() (int64, error) {
@@ -219,93 +757,354 @@ func (db *Db) Size() (int64, error) {
 	return info.Size(), nil
 }
 
+// CheckWritable повертає ErrClosed для закритої бази, а інакше перевіряє, що каталог даних
+// приймає записи: створює, синхронізує і видаляє тимчасовий файл поруч із сегментами.
+func (db *Db) CheckWritable() error {
+	if db.closed() {
+		return ErrClosed
+	}
+	f, err := os.CreateTemp(db.dir, ".health-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write([]byte{0})
+	if err == nil {
+		err = f.Sync()
+	}
+	return errors.Join(err, f.Close())
+}
+
+// Close перестає приймати нові записи, дочікується вже прийнятих і фонової компакції,
+// скидає активний файл на диск і звільняє ресурси. Повторний виклик повертає ErrClosed.
 func (db *Db) Close() error {
+	db.closeLock.Lock()
+	if db.closing {
+		db.closeLock.Unlock()
+		return ErrClosed
+	}
+	db.closing = true
+	// після closing ніхто не відправляє у writeChan, тож його можна закрити
+	close(db.writeChan)
+	db.closeLock.Unlock()
+
+	<-db.writerDone
 	close(db.quitChan)
-	return db.out.Close()
+	db.background.Wait()
+	db.closeWatchers()
+
+	db.outLock.Lock()
+	defer db.outLock.Unlock()
+	syncErr := db.out.Sync()
+	closeErr := db.out.Close()
+
+	db.indexLock.Lock()
+	db.unmapAll()
+	db.indexLock.Unlock()
+
+	db.log.Info("datastore closed", "dir", db.dir, "seq", db.seq)
+	return errors.Join(syncErr, closeErr)
+}
+
+func (db *Db) unmapAll() {
+	for path := range db.sealed {
+		db.unmapSegment(path)
+	}
+}
+
+func (db *Db) syncLoop() {
+	defer db.background.Done()
+	ticker := time.NewTicker(db.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			db.outLock.Lock()
+			if err := db.out.Sync(); err != nil {
+				db.log.Warn("periodic sync failed", "err", err)
+			}
+			db.outLock.Unlock()
+		case <-db.quitChan:
+			return
+		}
+	}
+}
+
+func (db *Db) compactionLoop() {
+	defer db.background.Done()
+	ticker := time.NewTicker(db.opts.Compaction.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !db.shouldCompact() {
+				continue
+			}
+			if err := db.Compact(); err != nil {
+				db.log.Error("background compaction failed", "err", err)
+			}
+		case <-db.quitChan:
+			return
+		}
+	}
+}
+
+func (db *Db) shouldCompact() bool {
+	policy := db.opts.Compaction
+	stats := db.Stats()
+	// сегменти старішої версії формату оновлюються за першої нагоди
+	if stats.OutdatedSegments > 0 {
+		return true
+	}
+	sealed := len(stats.Segments) - 1
+	if sealed < 1 || sealed < policy.MinSegments {
+		return false
+	}
+	total := stats.LiveBytes + stats.DeadBytes
+	if total == 0 {
+		return false
+	}
+	return float64(stats.DeadBytes)/float64(total) >= policy.MinDeadRatio
+}
+
+// syncDir фіксує на диску створення, перейменування та видалення файлів у каталозі.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync dir: %w", err)
+	}
+	return nil
+}
+
+func (db *Db) newSegmentPath() string {
+	path := filepath.Join(db.dir, fmt.Sprintf("segment-%d", db.nextSegment))
+	db.nextSegment++
+	return path
+}
+
+func segmentID(path string) int {
+	id, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "segment-"))
+	return id
+}
+
+// sortSegments впорядковує сегменти за номером, а не лексикографічно (segment-10 після segment-9).
+func sortSegments(files []string) []string {
+	var res []string
+	for _, file := range files {
+		if segmentID(file) > 0 {
+			res = append(res, file)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return segmentID(res[i]) < segmentID(res[j])
+	})
+	return res
 }
 
 func (db *Db) Compact() error {
-	tmpPath := filepath.Join(db.dir, "segment-compacting")
+	if db.closed() {
+		return ErrClosed
+	}
+	start := time.Now()
+	defer db.metrics.compactions.observe(start)
+
+	tmpPath := filepath.Join(db.dir, compactingFileName)
 	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o600)
 	if err != nil {
 		return fmt.Errorf("compact: cannot create tmp file: %w", err)
 	}
 	defer tmpFile.Close()
 
-	newIndex := make(hashIndex)
-	var offset int64
+	newIndex := make(map[string]hashIndex)
+	// новий сегмент завжди пишеться в поточній версії формату, тож компакція оновлює старі сегменти
+	header := newSegmentHeader(db.opts)
+	offset := header.size
 
+	db.outLock.Lock()
+	defer db.outLock.Unlock()
+	// Close міг завершитися, поки компакція чекала на outLock
+	if db.closed() {
+		return ErrClosed
+	}
 	db.indexLock.Lock()
 	defer db.indexLock.Unlock()
 
-	for key, ref := range db.index {
-		file, err := os.Open(ref.file)
-		if err != nil {
-			continue
-		}
-
-		if _, err := file.Seek(ref.offset, io.SeekStart); err != nil {
-			file.Close()
-			continue
-		}
-
-		var rec entry
-		if _, err := rec.DecodeFromReader(bufio.NewReader(file)); err != nil {
-			file.Close()
-			continue
-		}
-		file.Close()
-
-		data := rec.Encode()
-		if _, err := tmpFile.Write(data); err != nil {
-			return fmt.Errorf("compact: write failed: %w", err)
-		}
+	out := bufio.NewWriter(tmpFile)
+	_, _ = out.Write(header.encode())
+	for bucket, keys := range db.index {
+		newKeys := make(hashIndex, len(keys))
+		for key, ref := range keys {
+			n, err := db.copyRecord(out, ref)
+			if err != nil && n > 0 {
+				return fmt.Errorf("compact: write failed: %w", err)
+			}
+			if err != nil {
+				continue
+			}
 
-		newIndex[key] = recordRef{
-			file:   tmpPath, // Тимчасовий шлях, буде змінено після перейменування
-			offset: offset,
+			newKeys[key] = recordRef{
+				file:   tmpPath, // Тимчасовий шлях, буде змінено після перейменування
+				offset: offset,
+				size:   n,
+				seq:    ref.seq,
+			}
+			offset += n
 		}
-		offset += int64(len(data))
+		newIndex[bucket] = newKeys
 	}
 
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("compact: write failed: %w", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return fmt.Errorf("compact: sync tmp file: %w", err)
+	}
 	if err := tmpFile.Close(); err != nil {
 		return fmt.Errorf("compact: failed to close tmp file: %w", err)
 	}
 
+	// Новий сегмент з'являється на диску раніше, ніж зникають старі. Його номер більший за номери
+	// старих сегментів, тож якщо збій станеться під час видалення, відновлення прочитає його разом
+	// із рештою старих файлів, а номери послідовності залишать у індексі новіші записи.
+	newSegPath := db.newSegmentPath()
+	if err := os.Rename(tmpPath, newSegPath); err != nil {
+		return fmt.Errorf("compact: rename failed: %w", err)
+	}
+	if err := syncDir(db.dir); err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+
 	if err := db.out.Close(); err != nil {
 		return fmt.Errorf("compact: close current-data: %w", err)
 	}
 
-	// Видаляємо всі старі сегменти та current-data
+	// Видаляємо старі сегменти від найстаріших, а current-data останнім: після збою на диску
+	// лишаються лише новіші з них, і відновлення застосує їхні записи у правильному порядку
 	for _, seg := range db.segments {
+		db.unmapSegment(seg)
 		_ = os.Remove(seg)
 	}
 	_ = os.Remove(filepath.Join(db.dir, outFileName))
-
-	// Перейменовуємо тимчасовий файл у новий сегмент
-	newSegName := fmt.Sprintf("segment-%d", len(db.segments)+1)
-	newSegPath := filepath.Join(db.dir, newSegName)
-	if err := os.Rename(tmpPath, newSegPath); err != nil {
-		return fmt.Errorf("compact: rename failed: %w", err)
-	}
+	clear(db.headers)
+	db.headers[newSegPath] = header
 
 	// Оновлюємо індекс з новими шляхами
-	for key, ref := range newIndex {
-		ref.file = newSegPath
-		newIndex[key] = ref
+	for _, keys := range newIndex {
+		for key, ref := range keys {
+			ref.file = newSegPath
+			keys[key] = ref
+		}
 	}
 
 	// Відкриваємо новий current-data
-	out, err := os.OpenFile(filepath.Join(db.dir, outFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	newOut, err := os.OpenFile(filepath.Join(db.dir, outFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
 	if err != nil {
 		return fmt.Errorf("compact: reopen current-data: %w", err)
 	}
 
 	// Оновлюємо стан бази даних
-	db.out = out
-	db.outOffset = 0
+	db.out = newOut
+	if err := db.startOut(); err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+	if err := syncDir(db.dir); err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
 	db.index = newIndex
+	// записи, які не вдалося скопіювати, зникли з індексу, тож розміри бакетів рахуються заново
+	db.bucketBytes = make(map[string]int64, len(newIndex))
+	for bucket, keys := range newIndex {
+		for _, ref := range keys {
+			db.bucketBytes[bucket] += ref.size
+		}
+	}
 	db.segments = []string{newSegPath} // Зберігаємо лише новий компактний сегмент
+	// Старі версії зникають разом зі старими сегментами, а репліки, що відстали, завантажать знімок
+	db.history = make(map[bucketKey][]recordRef)
+	db.replLog.reset(db.replLog.last)
+	db.mapSegment(newSegPath)
+	db.log.Info("compaction finished", "segment", filepath.Base(newSegPath), "size", offset,
+		"duration", time.Since(start))
 
 	return nil
 }
+
+// copyRecord копіює запис як є, без декодування значення. Запис з іншим алгоритмом хешу,
+// зокрема старого формату, перезаписується з алгоритмом з опцій.
+func (db *Db) copyRecord(dst io.Writer, ref recordRef) (int64, error) {
+	file, err := os.Open(ref.file)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(ref.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	in := bufio.NewReader(file)
+	size, err := peekRecordSize(in, db.maxRecordSize)
+	if err != nil {
+		return 0, err
+	}
+	header, err := in.Peek(8)
+	if err != nil {
+		return 0, err
+	}
+	if _, algo := parseKeyField(binary.LittleEndian.Uint32(header[4:])); algo != db.opts.Hash {
+		if n, err := db.rewriteRecord(dst, ref); n > 0 || err == nil {
+			return n, err
+		}
+	}
+	return io.CopyN(dst, in, size)
+}
+
+// rewriteRecord перезаписує запис з алгоритмом хешу db.opts.Hash. Спершу перевіряється старий хеш:
+// пошкоджений запис не перезаписується, щоб нова контрольна сума не приховала пошкодження,
+// і copyRecord копіює його як є.
+func (db *Db) rewriteRecord(dst io.Writer, ref recordRef) (int64, error) {
+	file, err := os.Open(ref.file)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(ref.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	meta, err := decodeMeta(bufio.NewReader(file), db.maxRecordSize)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.checkStream(ref); err != nil {
+		db.log.Warn("record kept in its original format", "key", meta.key,
+			"segment", filepath.Base(ref.file), "offset", ref.offset, "err", err)
+		return 0, err
+	}
+
+	value, err := db.openStream(ref)
+	if err != nil {
+		return 0, err
+	}
+	defer value.Close()
+	meta.hash = db.opts.Hash
+	n, err := writeEntry(dst, meta, value, meta.valueSize)
+	if err != nil {
+		// частину запису вже могло бути записано, тож компакцію треба перервати
+		return recordSize(len(meta.key), meta.valueSize, meta.bucket, meta.hash), err
+	}
+	return n, nil
+}
+
+// checkStream дочитує значення запису, щоб перевірити його хеш.
+func (db *Db) checkStream(ref recordRef) error {
+	r, err := db.openStream(ref)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
@@ -1,7 +1,22 @@
 package datastore
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDb(t *testing.T) {
@@ -99,3 +114,761 @@ func TestDb(t *testing.T) {
 		}
 	})
 }
+
+func TestDb_SealedSegmentsAreMapped(t *testing.T) {
+	db, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for i := 0; i < 10; i++ {
+		if err := db.Put(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.segments) == 0 {
+		t.Fatal("expected at least one sealed segment")
+	}
+	for _, seg := range db.segments {
+		if _, ok := db.sealed[seg]; !ok {
+			t.Errorf("segment %s is not mapped", seg)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		value, err := db.Get(fmt.Sprintf("key-%d", i))
+		if err != nil {
+			t.Errorf("Cannot get key-%d: %s", i, err)
+		}
+		if value != fmt.Sprintf("value-%d", i) {
+			t.Errorf("Bad value for key-%d: %s", i, value)
+		}
+	}
+}
+
+func BenchmarkDb_Get(b *testing.B) {
+	const keys = 1000
+	db, err := Open(b.TempDir(), 64*1024)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_ = db.Close()
+	})
+	value := strings.Repeat("v", 256)
+	for i := 0; i < keys; i++ {
+		if err := db.Put(fmt.Sprintf("key-%d", i), value); err != nil {
+			b.Fatal(err)
+		}
+	}
+	db.indexLock.Lock()
+	if err := db.rotateSegment(); err != nil {
+		b.Fatal(err)
+	}
+	mapped := db.sealed
+	db.indexLock.Unlock()
+
+	b.Run("mmap", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.Get(fmt.Sprintf("key-%d", i%keys)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("file", func(b *testing.B) {
+		db.indexLock.Lock()
+		db.sealed = make(map[string]*sealedSegment)
+		db.indexLock.Unlock()
+		b.Cleanup(func() {
+			db.indexLock.Lock()
+			db.sealed = mapped
+			db.indexLock.Unlock()
+		})
+
+		for i := 0; i < b.N; i++ {
+			if _, err := db.Get(fmt.Sprintf("key-%d", i%keys)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDb_CorruptMappedSegment(t *testing.T) {
+	db, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	for i := 0; i < 10; i++ {
+		if err := db.Put(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// два записи із запечатаних сегментів, які читаються через mmap
+	var refs []recordRef
+	var keys []string
+	for key, ref := range db.index[DefaultBucket] {
+		if _, ok := db.sealed[ref.file]; ok && len(refs) < 2 {
+			refs = append(refs, ref)
+			keys = append(keys, key)
+		}
+	}
+	if len(refs) < 2 {
+		t.Fatalf("expected at least two records in sealed segments, got %d", len(refs))
+	}
+
+	// завеликий розмір запису та довжина ключа, що виходить за межі запису
+	var size, keyLen [4]byte
+	binary.LittleEndian.PutUint32(size[:], 5)
+	binary.LittleEndian.PutUint32(keyLen[:], maxKeyLength)
+	corrupt := []struct {
+		field  []byte
+		offset int64
+	}{{size[:], refs[0].offset}, {keyLen[:], refs[1].offset + 4}}
+	for i, c := range corrupt {
+		f, err := os.OpenFile(refs[i].file, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.WriteAt(c.field, c.offset)
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range keys[:2] {
+		if _, err := db.Get(key); !errors.Is(err, ErrHashMismatch) {
+			t.Errorf("Get %s from corrupt segment: expected ErrHashMismatch, got %v", key, err)
+		}
+	}
+}
+
+func TestDb_Stream(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{SegmentSize: 1 << 20, MaxRecordSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	value := strings.Repeat("0123456789", 50_000)
+
+	t.Run("put/get stream", func(t *testing.T) {
+		if err := db.PutStream("big", strings.NewReader(value), int64(len(value))); err != nil {
+			t.Fatal(err)
+		}
+		r, err := db.GetStream("big")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != value {
+			t.Errorf("streamed value differs: got %d bytes, wanted %d", len(got), len(value))
+		}
+
+		plain, err := db.Get("big")
+		if err != nil || plain != value {
+			t.Errorf("Get after PutStream failed: %v", err)
+		}
+	})
+
+	t.Run("short reader", func(t *testing.T) {
+		before := db.outOffset
+		err := db.PutStream("short", strings.NewReader("abc"), 10)
+		if err == nil {
+			t.Fatal("expected error for short reader")
+		}
+		if db.outOffset != before {
+			t.Errorf("offset moved after failed write: %d -> %d", before, db.outOffset)
+		}
+		if _, err := db.Get("short"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+		if err := db.Put("after", "ok"); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := db.Get("after"); err != nil || v != "ok" {
+			t.Errorf("Get after failed stream = %q, %v", v, err)
+		}
+	})
+
+	t.Run("max record size", func(t *testing.T) {
+		err := db.PutStream("huge", strings.NewReader(""), 2<<20)
+		if !errors.Is(err, ErrRecordTooLarge) {
+			t.Errorf("expected ErrRecordTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		if err := db.Put("corrupt", "value"); err != nil {
+			t.Fatal(err)
+		}
+		ref, _ := db.lookup(DefaultBucket, "corrupt")
+		f, err := os.OpenFile(ref.file, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = f.WriteAt([]byte("V"), ref.offset+4+4+int64(len("corrupt"))+4)
+		_ = f.Close()
+
+		r, err := db.GetStream("corrupt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		if _, err := io.ReadAll(r); !errors.Is(err, ErrHashMismatch) {
+			t.Errorf("expected ErrHashMismatch, got %v", err)
+		}
+	})
+}
+
+func TestDb_Versions(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenWithOptions(dir, Options{SegmentSize: 200, HistorySize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{"v1", "v2", "v3", "v4"} {
+		if err := db.Put("key", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	value, seq, err := db.GetWithSeq("key")
+	if err != nil || value != "v4" || seq != 4 {
+		t.Errorf("GetWithSeq = %q, %d, %v", value, seq, err)
+	}
+	if versions := db.Versions("key"); !reflect.DeepEqual(versions, []uint64{2, 3, 4}) {
+		t.Errorf("unexpected versions %v", versions)
+	}
+	if v, err := db.GetVersion("key", 2); err != nil || v != "v2" {
+		t.Errorf("GetVersion(2) = %q, %v", v, err)
+	}
+	if _, err := db.GetVersion("key", 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected version 1 to be evicted from history, got %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(dir, Options{SegmentSize: 200, HistorySize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if v, err := db.GetVersion("key", 3); err != nil || v != "v3" {
+		t.Errorf("GetVersion(3) after reopen = %q, %v", v, err)
+	}
+	if err := db.Put("other", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, seq, _ := db.GetWithSeq("other"); seq != 5 {
+		t.Errorf("sequence must continue after reopen, got %d", seq)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if versions := db.Versions("key"); !reflect.DeepEqual(versions, []uint64{4}) {
+		t.Errorf("history must be dropped by compaction, got %v", versions)
+	}
+	if _, seq, _ := db.GetWithSeq("key"); seq != 4 {
+		t.Errorf("compaction must keep sequence numbers, got %d", seq)
+	}
+}
+
+func TestDb_RecoverAfterCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		_ = db.Put("key", fmt.Sprintf("old-%d", i))
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		_ = db.Put("key", fmt.Sprintf("new-%d", i))
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if v, err := db.Get("key"); err != nil || v != "new-4" {
+		t.Errorf("Get after reopen = %q, %v", v, err)
+	}
+}
+
+func TestDb_Logger(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	db, err := OpenWithOptions(t.TempDir(), Options{SegmentSize: 1000, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.Put("logged-key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	logs := out.String()
+	for _, want := range []string{"record written", "key=logged-key", "segment=current-data", fmt.Sprintf("offset=%d", segmentHeaderSize), "op=put"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("log output %q does not contain %q", logs, want)
+		}
+	}
+}
+
+func TestDb_Context(t *testing.T) {
+	db, err := Open(t.TempDir(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("put and get", func(t *testing.T) {
+		if err := db.PutContext(context.Background(), "key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := db.GetContext(context.Background(), "key"); err != nil || v != "value" {
+			t.Errorf("GetContext = %q, %v", v, err)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := db.GetContext(ctx, "key"); !errors.Is(err, context.Canceled) {
+			t.Errorf("GetContext with cancelled ctx = %v", err)
+		}
+		if err := db.PutContext(ctx, "cancelled", "value"); !errors.Is(err, context.Canceled) {
+			t.Errorf("PutContext with cancelled ctx = %v", err)
+		}
+		if _, err := db.Get("cancelled"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("cancelled write must not be applied, got %v", err)
+		}
+	})
+
+	t.Run("stuck writer", func(t *testing.T) {
+		// writerLoop зависає на читанні значення, доки тест не допише його в pipe
+		pr, pw := io.Pipe()
+		blocked := make(chan error, 1)
+		go func() {
+			blocked <- db.PutStream("blocked", pr, 2)
+		}()
+		_, _ = pw.Write([]byte("a"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := db.PutContext(ctx, "late", "value")
+		_, _ = pw.Write([]byte("b"))
+		_ = pw.Close()
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("PutContext with stuck writer = %v", err)
+		}
+		if err := <-blocked; err != nil {
+			t.Errorf("Put after writer recovered = %v", err)
+		}
+		if _, err := db.Get("late"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("timed out write must not be applied, got %v", err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put("key", "value"); !errors.Is(err, ErrClosed) {
+			t.Errorf("Put after Close = %v", err)
+		}
+		if _, err := db.GetContext(context.Background(), "key"); !errors.Is(err, ErrClosed) {
+			t.Errorf("GetContext after Close = %v", err)
+		}
+		if err := db.Close(); !errors.Is(err, ErrClosed) {
+			t.Errorf("second Close = %v", err)
+		}
+	})
+}
+
+func TestDb_CloseDrainsWrites(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenWithOptions(dir, Options{SegmentSize: 1000, WriteQueueSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, pw := io.Pipe()
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- db.PutStream("blocked", pr, 2)
+	}()
+	_, _ = pw.Write([]byte("a"))
+
+	const queued = 5
+	results := make(chan error, queued)
+	for i := 0; i < queued; i++ {
+		go func() {
+			results <- db.Put(fmt.Sprintf("queued-%d", i), "value")
+		}()
+	}
+	waitFor(t, func() bool { return len(db.writeChan) == queued })
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- db.Close()
+	}()
+	waitFor(t, db.closed)
+	if err := db.Put("rejected", "value"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Put during Close = %v", err)
+	}
+
+	_, _ = pw.Write([]byte("b"))
+	_ = pw.Close()
+	if err := <-blocked; err != nil {
+		t.Errorf("in-flight Put = %v", err)
+	}
+	for i := 0; i < queued; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("queued Put = %v", err)
+		}
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if v, err := db.Get("blocked"); err != nil || v != "ab" {
+		t.Errorf("Get(blocked) after reopen = %q, %v", v, err)
+	}
+	for i := 0; i < queued; i++ {
+		if _, err := db.Get(fmt.Sprintf("queued-%d", i)); err != nil {
+			t.Errorf("queued-%d lost after Close: %v", i, err)
+		}
+	}
+	if _, err := db.Get("rejected"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("write rejected by Close must not be stored, got %v", err)
+	}
+}
+
+func TestDb_CloseWaitsForCompaction(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{
+		SegmentSize: 100,
+		Compaction:  CompactionPolicy{Interval: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := db.Put("key", fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Compact after Close = %v", err)
+	}
+}
+
+func TestDb_CheckWritable(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckWritable(); err != nil {
+		t.Errorf("open db must be writable: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, ".health-*"))
+	if len(files) != 0 {
+		t.Errorf("probe files must be removed: %v", files)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CheckWritable(); !errors.Is(err, ErrClosed) {
+		t.Errorf("CheckWritable after Close = %v", err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDb_HashMigration(t *testing.T) {
+	dir := t.TempDir()
+	var legacy []byte
+	for i, key := range []string{"a", "b", "broken"} {
+		record := entry{key: key, value: "value-" + key, hashAlgo: hashLegacySHA1, seq: uint64(i + 1)}
+		legacy = append(legacy, record.Encode()...)
+	}
+	// псуємо останній байт значення "broken"
+	legacy[len(legacy)-int(trailerSize(DefaultBucket))-4-checksumSizes[hashLegacySHA1]-1] ^= 1
+	if err := os.WriteFile(filepath.Join(dir, outFileName), legacy, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenWithOptions(dir, Options{Hash: HashCRC32C})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.Get("a"); err != nil || v != "value-a" {
+		t.Errorf("Get legacy record = %q, %v", v, err)
+	}
+	if err := db.Put("c", "value-c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	algos := make(map[string]HashAlgorithm)
+	for _, key := range []string{"a", "b", "c", "broken"} {
+		ref, _ := db.lookup(DefaultBucket, key)
+		record, err := db.readRecordFromFile(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		algos[key] = record.hashAlgo
+	}
+	want := map[string]HashAlgorithm{"a": HashCRC32C, "b": HashCRC32C, "c": HashCRC32C, "broken": hashLegacySHA1}
+	if !reflect.DeepEqual(algos, want) {
+		t.Errorf("expected hash algorithms %v after compaction, got %v", want, algos)
+	}
+	if _, err := db.Get("broken"); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("corrupted record must stay detectable, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenWithOptions(dir, Options{Hash: HashSHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	for _, key := range []string{"a", "b", "c"} {
+		if v, seq, err := db.GetWithSeq(key); err != nil || v != "value-"+key || seq == 0 {
+			t.Errorf("Get %s after reopen = %q, %d, %v", key, v, seq, err)
+		}
+	}
+}
+
+func TestDb_SegmentHeader(t *testing.T) {
+	dir := t.TempDir()
+	db, err := OpenWithOptions(dir, Options{Hash: HashXXHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, outFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := readSegmentHeader(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.version != FormatVersion || header.size != segmentHeaderSize || header.hash != HashXXHash ||
+		header.segmentSize != DefaultSegmentSize || time.Since(header.created) > time.Minute {
+		t.Errorf("unexpected segment header %+v", header)
+	}
+
+	newer := newSegmentHeader(Options{}).encode()
+	binary.LittleEndian.PutUint16(newer[4:], FormatVersion+1)
+	if err := os.WriteFile(filepath.Join(dir, "segment-1"), newer, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(dir, 0)
+	if !errors.Is(err, ErrUnsupportedFormat) || !strings.Contains(err.Error(), "segment-1") {
+		t.Errorf("expected ErrUnsupportedFormat for segment-1, got %v", err)
+	}
+}
+
+func TestDb_UpgradeLegacySegments(t *testing.T) {
+	dir := t.TempDir()
+	for i, file := range []string{"segment-1", outFileName} {
+		record := entry{key: fmt.Sprintf("key-%d", i), value: "value", hashAlgo: hashLegacySHA1, seq: uint64(i + 1)}
+		if err := os.WriteFile(filepath.Join(dir, file), record.Encode(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if n := db.Stats().OutdatedSegments; n != 2 {
+		t.Errorf("expected 2 outdated segments, got %d", n)
+	}
+	if !db.shouldCompact() {
+		t.Error("outdated segments must trigger compaction")
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Stats().OutdatedSegments; n != 0 {
+		t.Errorf("expected no outdated segments after compaction, got %d", n)
+	}
+	segments, err := db.Segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seg := range segments {
+		if seg.Version != FormatVersion || seg.Created == nil {
+			t.Errorf("segment %s was not upgraded: %+v", seg.Name, seg)
+		}
+	}
+	for _, key := range []string{"key-0", "key-1"} {
+		if v, err := db.Get(key); err != nil || v != "value" {
+			t.Errorf("Get %s after upgrade = %q, %v", key, v, err)
+		}
+	}
+}
+
+func TestDb_CompactCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i%7)
+		value := fmt.Sprintf("value-%d", i)
+		if err := db.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = value
+	}
+	for _, key := range []string{"key-1", "key-4"} {
+		if err := db.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+		delete(want, key)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := filepath.Glob(filepath.Join(dir, "*"))
+	oldFiles := make(map[string][]byte)
+	for _, path := range before {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		oldFiles[filepath.Base(path)] = data
+	}
+	if len(oldFiles) < 3 {
+		t.Fatalf("expected several segments, got %v", before)
+	}
+
+	db, err = Open(dir, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	compacted := db.segments[0]
+	compactedData, err := os.ReadFile(compacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// стани каталогу, які може залишити збій посеред компакції
+	old := sortSegments(slices.Collect(maps.Keys(oldFiles)))
+	states := map[string][]string{
+		"tmp file left":          slices.Concat(old, []string{outFileName, compactingFileName}),
+		"renamed, nothing freed": slices.Concat(old, []string{outFileName, filepath.Base(compacted)}),
+		"oldest segment freed":   slices.Concat(old[1:], []string{outFileName, filepath.Base(compacted)}),
+		"segments freed":         {outFileName, filepath.Base(compacted)},
+	}
+	for name, files := range states {
+		t.Run(name, func(t *testing.T) {
+			crashed := t.TempDir()
+			for _, file := range files {
+				data := oldFiles[file]
+				switch file {
+				case filepath.Base(compacted):
+					data = compactedData
+				case compactingFileName:
+					data = compactedData[:len(compactedData)/2]
+				}
+				if err := os.WriteFile(filepath.Join(crashed, file), data, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			db, err := Open(crashed, 150)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			got := make(map[string]string)
+			for _, key := range db.Bucket(DefaultBucket).Keys("") {
+				if got[key], err = db.Get(key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v after recovery, got %v", want, got)
+			}
+			if _, err := os.Stat(filepath.Join(crashed, compactingFileName)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("leftover compaction file must be removed, got %v", err)
+			}
+		})
+	}
+}
@@ -3,29 +3,161 @@ package datastore
 import (
 	"bufio"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"math"
+
+	"github.com/cespare/xxhash/v2"
 )
 
+// Старший байт поля довжини ключа містить ідентифікатор алгоритму контрольної суми (HashAlgorithm + 1).
+// У записах старого формату він нульовий, а поле хешу містить SHA-1 у шістнадцятковому вигляді.
+const maxKeyLength = 1<<24 - 1
+
+// hashLegacySHA1 - алгоритм записів старого формату; для нових записів його вибрати не можна.
+const hashLegacySHA1 HashAlgorithm = -1
+
+// checksumSizes - розмір поля хешу для кожного алгоритму, який вміє читати база.
+var checksumSizes = map[HashAlgorithm]int{
+	hashLegacySHA1: 2 * sha1.Size,
+	HashSHA1:       sha1.Size,
+	HashCRC32C:     crc32.Size,
+	HashXXHash:     8,
+	HashSHA256:     sha256.Size,
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// newHash повертає nil для невідомого алгоритму, наприклад з запису новішої версії бази.
+func newHash(algo HashAlgorithm) hash.Hash {
+	switch algo {
+	case hashLegacySHA1, HashSHA1:
+		return sha1.New()
+	case HashCRC32C:
+		return crc32.New(castagnoli)
+	case HashXXHash:
+		return xxhash.New()
+	case HashSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+func checksum(algo HashAlgorithm, h hash.Hash) string {
+	if algo == hashLegacySHA1 {
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	return string(h.Sum(nil))
+}
+
+// verifyChecksum порівнює збережений хеш з хешем h, обчисленим за newHash(algo).
+func verifyChecksum(algo HashAlgorithm, h hash.Hash, stored string) error {
+	if h == nil {
+		return fmt.Errorf("%w: unknown checksum algorithm %d", ErrHashMismatch, int(algo)+1)
+	}
+	if stored != checksum(algo, h) {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+func keyField(keyLen int, algo HashAlgorithm) uint32 {
+	return uint32(keyLen) | uint32(algo+1)<<24
+}
+
+func parseKeyField(field uint32) (int64, HashAlgorithm) {
+	return int64(field & maxKeyLength), HashAlgorithm(field>>24) - 1
+}
+
+type entryKind byte
+
+const (
+	entryPut entryKind = iota
+	entryDelete
+	entryDropBucket
+	// Маркери початку і завершення пакета: пакет без маркера завершення під час відновлення відкидається.
+	entryBatchBegin
+	entryBatchCommit
+)
+
+func (k entryKind) String() string {
+	switch k {
+	case entryPut:
+		return "put"
+	case entryDelete:
+		return "delete"
+	case entryDropBucket:
+		return "drop-bucket"
+	case entryBatchBegin:
+		return "batch-begin"
+	case entryBatchCommit:
+		return "batch-commit"
+	}
+	return "unknown"
+}
+
+// Мінімальний запис старого формату: чотири поля довжини без даних.
+const minRecordSize = 16
+
 type entry struct {
 	key, value string
 	hash       string
+	// hashAlgo - алгоритм hash; Encode обчислює хеш цим алгоритмом.
+	hashAlgo HashAlgorithm
+	kind     entryKind
+	seq      uint64
+	bucket   string
+}
+
+// recordMeta описує запис без його значення.
+type recordMeta struct {
+	key    string
+	size   int64
+	kind   entryKind
+	seq    uint64
+	bucket string
+	hash   HashAlgorithm
+	// valueSize заповнює лише decodeMeta.
+	valueSize int64
+}
+
+// RecordSize повертає розмір запису put на диску, наприклад щоб розбити дані на пакети,
+// що вміщуються в сегмент.
+func (db *Db) RecordSize(bucket, key string, valueLen int64) int64 {
+	return recordSize(len(key), valueLen, bucket, db.opts.Hash)
+}
+
+func recordSize(keyLen int, valueLen int64, bucket string, algo HashAlgorithm) int64 {
+	return 4 + 4 + int64(keyLen) + 4 + valueLen + 4 + int64(checksumSizes[algo]) + trailerSize(bucket)
+}
+
+// Після хешу запис має хвіст з метаданими: тип запису, номер послідовності та бакет.
+// У записах старого формату хвіст відсутній або коротший, відсутні поля мають нульові значення.
+func trailerSize(bucket string) int64 {
+	if bucket == DefaultBucket {
+		bucket = ""
+	}
+	return 1 + 8 + 4 + int64(len(bucket))
 }
 
 func (e *entry) Encode() []byte {
 	kl, vl := len(e.key), len(e.value)
-	hash := sha1.Sum([]byte(e.value))
-	e.hash = hex.EncodeToString(hash[:])
+	h := newHash(e.hashAlgo)
+	h.Write([]byte(e.value))
+	e.hash = checksum(e.hashAlgo, h)
 	hl := len(e.hash)
 
-	size := 4 + 4 + kl + 4 + vl + 4 + hl
+	size := 4 + 4 + kl + 4 + vl + 4 + hl + int(trailerSize(e.bucket))
 	res := make([]byte, size)
 
 	binary.LittleEndian.PutUint32(res[0:], uint32(size))
-	binary.LittleEndian.PutUint32(res[4:], uint32(kl))
+	binary.LittleEndian.PutUint32(res[4:], keyField(kl, e.hashAlgo))
 	copy(res[8:], e.key)
 
 	binary.LittleEndian.PutUint32(res[8+kl:], uint32(vl))
@@ -34,28 +166,93 @@ func (e *entry) Encode() []byte {
 	binary.LittleEndian.PutUint32(res[12+kl+vl:], uint32(hl))
 	copy(res[16+kl+vl:], []byte(e.hash))
 
+	encodeTrailer(res[16+kl+vl+hl:], e.kind, e.seq, e.bucket)
+
 	return res
 }
 
-func (e *entry) Decode(input []byte) {
-	kl := binary.LittleEndian.Uint32(input[4:])
-	keyStart := 8
-	keyEnd := int(kl) + keyStart
+// Decode розбирає запис, перевіряючи, що довжини полів не виходять за межі input.
+func (e *entry) Decode(input []byte) error {
+	size := int64(len(input))
+	if size < minRecordSize {
+		return fmt.Errorf("%w: record of %d bytes is too short", ErrHashMismatch, size)
+	}
+	kl, algo := parseKeyField(binary.LittleEndian.Uint32(input[4:]))
+	keyStart := int64(8)
+	keyEnd := kl + keyStart
+	if keyEnd+4 > size {
+		return fmt.Errorf("%w: bad key length %d", ErrHashMismatch, kl)
+	}
 
-	vl := binary.LittleEndian.Uint32(input[keyEnd:])
+	vl := int64(binary.LittleEndian.Uint32(input[keyEnd:]))
 	valStart := keyEnd + 4
-	valEnd := valStart + int(vl)
+	valEnd := valStart + vl
+	if valEnd+4 > size {
+		return fmt.Errorf("%w: bad value length %d", ErrHashMismatch, vl)
+	}
 
-	hl := binary.LittleEndian.Uint32(input[valEnd:])
+	hl := int64(binary.LittleEndian.Uint32(input[valEnd:]))
 	hashStart := valEnd + 4
-	hashEnd := hashStart + int(hl)
+	hashEnd := hashStart + hl
+	if hashEnd > size {
+		return fmt.Errorf("%w: bad hash length %d", ErrHashMismatch, hl)
+	}
 
 	e.key = string(input[keyStart:keyEnd])
 	e.value = string(input[valStart:valEnd])
 	e.hash = string(input[hashStart:hashEnd])
+	e.hashAlgo = algo
+	e.decodeTrailer(input[hashEnd:])
+	return nil
+}
+
+func encodeTrailer(res []byte, kind entryKind, seq uint64, bucket string) {
+	// Бакет за замовчуванням не зберігається, як і в записах старого формату
+	if bucket == DefaultBucket {
+		bucket = ""
+	}
+	res[0] = byte(kind)
+	binary.LittleEndian.PutUint64(res[1:], seq)
+	binary.LittleEndian.PutUint32(res[9:], uint32(len(bucket)))
+	copy(res[13:], bucket)
+}
+
+func (e *entry) decodeTrailer(trailer []byte) {
+	e.kind, e.seq, e.bucket = entryPut, 0, DefaultBucket
+	if len(trailer) >= 1 {
+		e.kind = entryKind(trailer[0])
+	}
+	if len(trailer) >= 9 {
+		e.seq = binary.LittleEndian.Uint64(trailer[1:])
+	}
+	if len(trailer) >= 13 {
+		bl := int(binary.LittleEndian.Uint32(trailer[9:]))
+		if bl > 0 && 13+bl <= len(trailer) {
+			e.bucket = string(trailer[13 : 13+bl])
+		}
+	}
 }
 
 func (e *entry) DecodeFromReader(in *bufio.Reader) (int, error) {
+	return e.decodeFromReader(in, DefaultMaxRecordSize)
+}
+
+func (e *entry) decodeFromReader(in *bufio.Reader, limit int64) (int, error) {
+	totalSize, err := peekRecordSize(in, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, totalSize)
+	n, err := io.ReadFull(in, buf)
+	if err != nil {
+		return n, fmt.Errorf("DecodeFromReader, cannot read record: %w", err)
+	}
+
+	return n, e.Decode(buf)
+}
+
+func peekRecordSize(in *bufio.Reader, limit int64) (int64, error) {
 	sizeBuf, err := in.Peek(4)
 	if err != nil {
 		if errors.Is(err, io.EOF) {
@@ -64,13 +261,196 @@ func (e *entry) DecodeFromReader(in *bufio.Reader) (int, error) {
 		return 0, fmt.Errorf("DecodeFromReader, cannot read size: %w", err)
 	}
 
-	totalSize := int(binary.LittleEndian.Uint32(sizeBuf))
-	buf := make([]byte, totalSize)
-	n, err := in.Read(buf)
+	totalSize := int64(binary.LittleEndian.Uint32(sizeBuf))
+	if totalSize < minRecordSize {
+		return 0, fmt.Errorf("DecodeFromReader, bad record size %d", totalSize)
+	}
+	if totalSize > limit {
+		return 0, fmt.Errorf("%w: %d bytes", ErrRecordTooLarge, totalSize)
+	}
+	return totalSize, nil
+}
+
+// decodeMeta читає ключ і метадані запису, пропускаючи значення, щоб не завантажувати його в пам'ять.
+func decodeMeta(in *bufio.Reader, limit int64) (recordMeta, error) {
+	var meta recordMeta
+	totalSize, err := peekRecordSize(in, limit)
 	if err != nil {
-		return n, fmt.Errorf("DecodeFromReader, cannot read record: %w", err)
+		return meta, err
+	}
+	meta.size = totalSize
+
+	var header [8]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil {
+		return meta, fmt.Errorf("decodeMeta, cannot read header: %w", err)
 	}
+	read := int64(8)
+	kl, algo := parseKeyField(binary.LittleEndian.Uint32(header[4:]))
+	if read+kl+4 > totalSize {
+		return meta, fmt.Errorf("decodeMeta, bad key length %d", kl)
+	}
+	key := make([]byte, kl)
+	if _, err := io.ReadFull(in, key); err != nil {
+		return meta, fmt.Errorf("decodeMeta, cannot read key: %w", err)
+	}
+	meta.key = string(key)
+	meta.hash = algo
+	read += kl
+
+	// Пропускаємо значення та хеш, кожне з яких має префікс довжини
+	for i := 0; i < 2; i++ {
+		if _, err := io.ReadFull(in, header[:4]); err != nil {
+			return meta, fmt.Errorf("decodeMeta, cannot read length: %w", err)
+		}
+		l := int64(binary.LittleEndian.Uint32(header[:4]))
+		read += 4 + l
+		if read > totalSize {
+			return meta, fmt.Errorf("decodeMeta, bad field length %d", l)
+		}
+		if _, err := in.Discard(int(l)); err != nil {
+			return meta, fmt.Errorf("decodeMeta, cannot skip field: %w", err)
+		}
+		if i == 0 {
+			meta.valueSize = l
+		}
+	}
+
+	trailer := make([]byte, totalSize-read)
+	if _, err := io.ReadFull(in, trailer); err != nil {
+		return meta, fmt.Errorf("decodeMeta, cannot read trailer: %w", err)
+	}
+	var e entry
+	e.decodeTrailer(trailer)
+	meta.kind, meta.seq, meta.bucket = e.kind, e.seq, e.bucket
+	return meta, nil
+}
+
+// writeEntry потоково записує запис, не тримаючи значення в пам'яті повністю.
+// Хеш обчислюється алгоритмом meta.hash.
+func writeEntry(w io.Writer, meta recordMeta, value io.Reader, valueSize int64) (int64, error) {
+	key := meta.key
+	size := recordSize(len(key), valueSize, meta.bucket, meta.hash)
+	if size > math.MaxUint32 {
+		return 0, fmt.Errorf("%w: %d bytes", ErrRecordTooLarge, size)
+	}
+
+	out := bufio.NewWriter(w)
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(size))
+	binary.LittleEndian.PutUint32(header[4:], keyField(len(key), meta.hash))
+	_, _ = out.Write(header[:])
+	_, _ = out.WriteString(key)
+	binary.LittleEndian.PutUint32(header[0:], uint32(valueSize))
+	_, _ = out.Write(header[:4])
+
+	h := newHash(meta.hash)
+	if _, err := io.CopyN(io.MultiWriter(out, h), value, valueSize); err != nil {
+		return 0, fmt.Errorf("cannot copy value: %w", err)
+	}
+
+	binary.LittleEndian.PutUint32(header[0:], uint32(checksumSizes[meta.hash]))
+	_, _ = out.Write(header[:4])
+	_, _ = out.WriteString(checksum(meta.hash, h))
+	trailer := make([]byte, trailerSize(meta.bucket))
+	encodeTrailer(trailer, meta.kind, meta.seq, meta.bucket)
+	_, _ = out.Write(trailer)
+
+	if err := out.Flush(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// valueReader віддає значення запису частинами і перевіряє хеш, коли значення прочитано до кінця.
+type valueReader struct {
+	closer    io.Closer
+	in        *bufio.Reader
+	remaining int64
+	// hashLimit - скільки байтів запису лишається на поле хешу та хвіст
+	hashLimit int64
+	algo      HashAlgorithm
+	hash      hash.Hash
+	err       error
+}
+
+func newValueReader(in *bufio.Reader, closer io.Closer, limit int64) (*valueReader, error) {
+	totalSize, err := peekRecordSize(in, limit)
+	if err != nil {
+		return nil, err
+	}
+	var header [8]byte
+	if _, err := io.ReadFull(in, header[:]); err != nil {
+		return nil, err
+	}
+	kl, algo := parseKeyField(binary.LittleEndian.Uint32(header[4:]))
+	if 8+kl+4 > totalSize {
+		return nil, fmt.Errorf("bad key length %d", kl)
+	}
+	if _, err := in.Discard(int(kl)); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(in, header[:4]); err != nil {
+		return nil, err
+	}
+	vl := int64(binary.LittleEndian.Uint32(header[:4]))
+	hashLimit := totalSize - (8 + kl + 4 + vl + 4)
+	if hashLimit < 0 {
+		return nil, fmt.Errorf("%w: bad value length %d", ErrHashMismatch, vl)
+	}
+	return &valueReader{
+		closer:    closer,
+		in:        in,
+		remaining: vl,
+		hashLimit: hashLimit,
+		algo:      algo,
+		hash:      newHash(algo),
+	}, nil
+}
+
+func (r *valueReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.remaining == 0 {
+		r.err = r.verify()
+		return 0, r.err
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.in.Read(p)
+	if r.hash != nil {
+		r.hash.Write(p[:n])
+	}
+	r.remaining -= int64(n)
+	if errors.Is(err, io.EOF) && r.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+	}
+	return n, r.err
+}
+
+func (r *valueReader) verify() error {
+	var hl [4]byte
+	if _, err := io.ReadFull(r.in, hl[:]); err != nil {
+		return fmt.Errorf("cannot read hash: %w", err)
+	}
+	l := int64(binary.LittleEndian.Uint32(hl[:]))
+	if l > r.hashLimit {
+		return fmt.Errorf("%w: bad hash length %d", ErrHashMismatch, l)
+	}
+	stored := make([]byte, l)
+	if _, err := io.ReadFull(r.in, stored); err != nil {
+		return fmt.Errorf("cannot read hash: %w", err)
+	}
+	if err := verifyChecksum(r.algo, r.hash, string(stored)); err != nil {
+		return err
+	}
+	return io.EOF
+}
 
-	e.Decode(buf)
-	return n, nil
+func (r *valueReader) Close() error {
+	return r.closer.Close()
 }
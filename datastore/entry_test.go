@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/sha1"
-	"encoding/hex"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 )
 
@@ -23,9 +26,8 @@ func TestEntry_EncodeDecode(t *testing.T) {
 	}
 
 	expectedHash := sha1.Sum([]byte(original.value))
-	expectedHashHex := hex.EncodeToString(expectedHash[:])
-	if decoded.hash != expectedHashHex {
-		t.Errorf("expected hash %q, got %q", expectedHashHex, decoded.hash)
+	if decoded.hash != string(expectedHash[:]) || decoded.hashAlgo != HashSHA1 {
+		t.Errorf("expected sha1 hash %x, got %v %x", expectedHash, decoded.hashAlgo, decoded.hash)
 	}
 }
 
@@ -50,8 +52,125 @@ func TestEntry_DecodeFromReader(t *testing.T) {
 	}
 
 	expectedHash := sha1.Sum([]byte(original.value))
-	expectedHashHex := hex.EncodeToString(expectedHash[:])
-	if decoded.hash != expectedHashHex {
-		t.Errorf("expected hash %q, got %q", expectedHashHex, decoded.hash)
+	if decoded.hash != string(expectedHash[:]) || decoded.hashAlgo != HashSHA1 {
+		t.Errorf("expected sha1 hash %x, got %v %x", expectedHash, decoded.hashAlgo, decoded.hash)
+	}
+}
+
+func TestEntry_DecodeFromReader_LargeRecord(t *testing.T) {
+	original := entry{key: "big", value: strings.Repeat("x", 100_000)}
+	encoded := original.Encode()
+
+	var decoded entry
+	n, err := decoded.DecodeFromReader(bufio.NewReader(bytes.NewReader(encoded)))
+	if err != nil {
+		t.Fatalf("DecodeFromReader error: %v", err)
+	}
+	if n != len(encoded) || decoded.value != original.value {
+		t.Errorf("large record decoded incorrectly: read %d of %d bytes", n, len(encoded))
+	}
+
+	_, err = decoded.decodeFromReader(bufio.NewReader(bytes.NewReader(encoded)), 1000)
+	if !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("expected ErrRecordTooLarge, got %v", err)
+	}
+}
+
+func TestEntry_LegacyRecordWithoutTrailer(t *testing.T) {
+	record := entry{key: "old", value: "value"}
+	encoded := record.Encode()
+	legacy := encoded[:len(encoded)-int(trailerSize(DefaultBucket))]
+	binary.LittleEndian.PutUint32(legacy, uint32(len(legacy)))
+
+	var decoded entry
+	decoded.Decode(legacy)
+	if decoded.key != "old" || decoded.value != "value" || decoded.kind != entryPut {
+		t.Errorf("unexpected legacy decode result %+v", decoded)
+	}
+
+	meta, err := decodeMeta(bufio.NewReader(bytes.NewReader(legacy)), DefaultMaxRecordSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.key != "old" || meta.size != int64(len(legacy)) || meta.kind != entryPut {
+		t.Errorf("unexpected legacy meta %+v", meta)
+	}
+}
+
+func TestEntry_HashAlgorithms(t *testing.T) {
+	for algo := range hashAlgorithmNames {
+		t.Run(algo.String(), func(t *testing.T) {
+			original := entry{key: "key", value: "value", hashAlgo: algo}
+			encoded := original.Encode()
+			if int64(len(encoded)) != recordSize(3, 5, DefaultBucket, algo) {
+				t.Errorf("expected record of %d bytes, got %d", recordSize(3, 5, DefaultBucket, algo), len(encoded))
+			}
+
+			var decoded entry
+			decoded.Decode(encoded)
+			if decoded.key != "key" || decoded.value != "value" || decoded.hashAlgo != algo {
+				t.Errorf("unexpected decode result %+v", decoded)
+			}
+			r, err := newValueReader(bufio.NewReader(bytes.NewReader(encoded)), io.NopCloser(nil), DefaultMaxRecordSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value, err := io.ReadAll(r); err != nil || string(value) != "value" {
+				t.Errorf("stream read %q, %v", value, err)
+			}
+
+			encoded[12+3] ^= 1
+			r, _ = newValueReader(bufio.NewReader(bytes.NewReader(encoded)), io.NopCloser(nil), DefaultMaxRecordSize)
+			if _, err := io.ReadAll(r); !errors.Is(err, ErrHashMismatch) {
+				t.Errorf("expected ErrHashMismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEntry_LegacyHexHash(t *testing.T) {
+	record := entry{key: "old", value: "value", hashAlgo: hashLegacySHA1}
+	encoded := record.Encode()
+	if kl := binary.LittleEndian.Uint32(encoded[4:]); kl != 3 {
+		t.Fatalf("legacy record must keep plain key length, got %#x", kl)
+	}
+
+	var decoded entry
+	decoded.Decode(encoded)
+	if decoded.hashAlgo != hashLegacySHA1 || len(decoded.hash) != 2*sha1.Size {
+		t.Errorf("unexpected legacy hash %v %q", decoded.hashAlgo, decoded.hash)
+	}
+	h := newHash(decoded.hashAlgo)
+	h.Write([]byte(decoded.value))
+	if err := verifyChecksum(decoded.hashAlgo, h, decoded.hash); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEntry_CorruptLengths(t *testing.T) {
+	encoded := (&entry{key: "key", value: "value"}).Encode()
+	hashField := len(encoded) - int(trailerSize(DefaultBucket)) - checksumSizes[HashSHA1] - 4
+
+	for name, corrupt := range map[string]func([]byte){
+		"key":   func(b []byte) { binary.LittleEndian.PutUint32(b[4:], keyField(1000, HashSHA1)) },
+		"value": func(b []byte) { binary.LittleEndian.PutUint32(b[11:], 1000) },
+		"hash":  func(b []byte) { binary.LittleEndian.PutUint32(b[hashField:], 1<<31) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			record := bytes.Clone(encoded)
+			corrupt(record)
+
+			var decoded entry
+			if err := decoded.Decode(record); !errors.Is(err, ErrHashMismatch) {
+				t.Errorf("Decode: expected ErrHashMismatch, got %v", err)
+			}
+			r, err := newValueReader(bufio.NewReader(bytes.NewReader(record)), io.NopCloser(nil), DefaultMaxRecordSize)
+			if err == nil {
+				_, err = io.ReadAll(r)
+			}
+			if err == nil {
+				t.Error("stream read of a corrupt record must fail")
+			}
+		})
 	}
 }
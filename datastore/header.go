@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Кожен файл сегмента починається із заголовка. Файли, створені до появи заголовка, мають версію 0:
+// вони читаються як раніше, а компакція переписує їх у поточну версію.
+const (
+	// FormatVersion - версія формату, у якій пишуться нові сегменти. Версія 2 додала маркери пакетів,
+	// яких попередні версії не розпізнають.
+	FormatVersion = 2
+
+	segmentMagic      = "KVS\xff"
+	segmentHeaderSize = 33
+)
+
+// ErrUnsupportedFormat повертається для сегмента, записаного новішою версією бази або пошкодженого заголовка.
+var ErrUnsupportedFormat = errors.New("unsupported segment format")
+
+// segmentHeader: magic, версія формату, розмір заголовка, час створення та опції, з якими писався сегмент.
+// Розмір заголовка дає змогу новим версіям додавати поля; для файлу версії 0 він нульовий.
+type segmentHeader struct {
+	version       uint16
+	size          int64
+	created       time.Time
+	segmentSize   int64
+	maxRecordSize int64
+	hash          HashAlgorithm
+}
+
+func newSegmentHeader(opts Options) segmentHeader {
+	return segmentHeader{
+		version:       FormatVersion,
+		size:          segmentHeaderSize,
+		created:       time.Now(),
+		segmentSize:   opts.SegmentSize,
+		maxRecordSize: opts.MaxRecordSize,
+		hash:          opts.Hash,
+	}
+}
+
+func (h segmentHeader) encode() []byte {
+	res := make([]byte, segmentHeaderSize)
+	copy(res, segmentMagic)
+	binary.LittleEndian.PutUint16(res[4:], h.version)
+	binary.LittleEndian.PutUint16(res[6:], segmentHeaderSize)
+	binary.LittleEndian.PutUint64(res[8:], uint64(h.created.UnixNano()))
+	binary.LittleEndian.PutUint64(res[16:], uint64(h.segmentSize))
+	binary.LittleEndian.PutUint64(res[24:], uint64(h.maxRecordSize))
+	res[32] = byte(h.hash)
+	return res
+}
+
+// readSegmentHeader читає заголовок і залишає in на першому записі. Файл без magic вважається
+// файлом версії 0, і з нього нічого не читається: його перші байти - розмір запису, який
+// збігся б з magic лише для запису майже в 4 ГіБ.
+func readSegmentHeader(in *bufio.Reader) (segmentHeader, error) {
+	var h segmentHeader
+	magic, err := in.Peek(len(segmentMagic))
+	if err != nil || string(magic) != segmentMagic {
+		return h, nil
+	}
+	var fixed [8]byte
+	if _, err := io.ReadFull(in, fixed[:]); err != nil {
+		return h, fmt.Errorf("%w: cannot read header: %v", ErrUnsupportedFormat, err)
+	}
+	h.version = binary.LittleEndian.Uint16(fixed[4:])
+	h.size = int64(binary.LittleEndian.Uint16(fixed[6:]))
+	if h.version > FormatVersion {
+		return h, fmt.Errorf("%w: format version %d, this build supports versions up to %d",
+			ErrUnsupportedFormat, h.version, FormatVersion)
+	}
+	if h.version == 0 || h.size < segmentHeaderSize {
+		return h, fmt.Errorf("%w: bad header (version %d, size %d)", ErrUnsupportedFormat, h.version, h.size)
+	}
+
+	rest := make([]byte, h.size-int64(len(fixed)))
+	if _, err := io.ReadFull(in, rest); err != nil {
+		return h, fmt.Errorf("%w: cannot read header: %v", ErrUnsupportedFormat, err)
+	}
+	h.created = time.Unix(0, int64(binary.LittleEndian.Uint64(rest[0:])))
+	h.segmentSize = int64(binary.LittleEndian.Uint64(rest[8:]))
+	h.maxRecordSize = int64(binary.LittleEndian.Uint64(rest[16:]))
+	h.hash = HashAlgorithm(rest[24])
+	return h, nil
+}
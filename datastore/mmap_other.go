@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package datastore
+
+import (
+	"errors"
+	"os"
+)
+
+var errMmapUnsupported = errors.New("mmap is not supported on this platform")
+
+func mmapFile(_ *os.File, _ int64) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap(_ []byte) error {
+	return nil
+}
@@ -0,0 +1,227 @@
+package datastore
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+const (
+	DefaultSegmentSize    = 10 * 1024 * 1024
+	DefaultMaxRecordSize  = 1 << 30
+	DefaultMaxKeySize     = 64 * 1024
+	DefaultWriteQueueSize = 0
+	DefaultSyncInterval   = time.Second
+
+	DefaultReplicationLogSize = 4096
+)
+
+var (
+	ErrInvalidOptions = fmt.Errorf("invalid options")
+	ErrKeyTooLarge    = fmt.Errorf("key is too large")
+)
+
+// SyncPolicy визначає, коли записи скидаються на диск через fsync.
+type SyncPolicy int
+
+const (
+	// SyncNever покладається на операційну систему.
+	SyncNever SyncPolicy = iota
+	// SyncAlways робить fsync після кожного запису до відповіді клієнту.
+	SyncAlways
+	// SyncInterval робить fsync у фоні раз на Options.SyncInterval.
+	SyncInterval
+)
+
+var syncPolicyNames = map[SyncPolicy]string{
+	SyncNever:    "never",
+	SyncAlways:   "always",
+	SyncInterval: "interval",
+}
+
+func (p SyncPolicy) String() string {
+	if name, ok := syncPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+func (p SyncPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *SyncPolicy) UnmarshalText(text []byte) error {
+	for policy, name := range syncPolicyNames {
+		if name == string(text) {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sync policy %q", text)
+}
+
+// HashAlgorithm визначає контрольну суму значень у нових записах. Ідентифікатор алгоритму
+// зберігається в заголовку кожного запису, тож записи з різними алгоритмами читаються разом,
+// а значення констант не можна змінювати.
+type HashAlgorithm int
+
+const (
+	HashSHA1 HashAlgorithm = iota
+	HashCRC32C
+	HashXXHash
+	HashSHA256
+)
+
+var hashAlgorithmNames = map[HashAlgorithm]string{
+	HashSHA1:   "sha1",
+	HashCRC32C: "crc32c",
+	HashXXHash: "xxhash",
+	HashSHA256: "sha256",
+}
+
+func (h HashAlgorithm) String() string {
+	if name, ok := hashAlgorithmNames[h]; ok {
+		return name
+	}
+	return fmt.Sprintf("HashAlgorithm(%d)", int(h))
+}
+
+func (h HashAlgorithm) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *HashAlgorithm) UnmarshalText(text []byte) error {
+	for algo, name := range hashAlgorithmNames {
+		if name == string(text) {
+			*h = algo
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown hash algorithm %q", text)
+}
+
+// CompactionPolicy описує фонову компакцію; нульове значення вимикає її.
+type CompactionPolicy struct {
+	// Interval - як часто перевіряти, чи потрібна компакція.
+	Interval time.Duration
+	// MinSegments - мінімальна кількість запечатаних сегментів для компакції.
+	MinSegments int
+	// MinDeadRatio - мінімальна частка мертвих байтів серед усіх байтів на диску.
+	MinDeadRatio float64
+}
+
+// QuotaPolicy обмежує живі байти бакетів; нульове значення знімає обмеження.
+type QuotaPolicy struct {
+	// BucketBytes - обмеження для бакетів, яких немає в Buckets.
+	BucketBytes int64
+	// Buckets задає обмеження окремих бакетів і має пріоритет над BucketBytes; 0 знімає обмеження.
+	Buckets map[string]int64
+}
+
+func (q QuotaPolicy) limit(bucket string) int64 {
+	if limit, ok := q.Buckets[bucket]; ok {
+		return limit
+	}
+	return q.BucketBytes
+}
+
+type Options struct {
+	SegmentSize   int64
+	MaxRecordSize int64
+	// MaxKeySize та MaxValueSize обмежують нові записи; нульовий MaxValueSize означає обмеження лише MaxRecordSize.
+	MaxKeySize   int
+	MaxValueSize int64
+	// HistorySize задає, скільки попередніх версій кожного ключа доступні до наступної компакції.
+	HistorySize int
+	Indexes     []IndexSpec
+	// WriteQueueSize - ємність черги запитів до writerLoop.
+	WriteQueueSize int
+	Sync           SyncPolicy
+	SyncInterval   time.Duration
+	Compaction     CompactionPolicy
+	// Quotas перевіряються лише для локальних записів; записи лідера з призначеним seq застосовуються завжди.
+	Quotas QuotaPolicy
+	// Hash - алгоритм хешу нових записів; Compact перезаписує ним записи з іншим алгоритмом.
+	Hash HashAlgorithm
+	// ReplicationLogSize - скільки останніх записів журналу доступні реплікам через ReadLog.
+	// Репліка, що відстала сильніше або пережила перезапуск лідера, завантажує Snapshot.
+	ReplicationLogSize int
+	// Logger отримує структуровані повідомлення бази; якщо не задано, база нічого не пише.
+	Logger *slog.Logger
+}
+
+// withDefaults перевіряє опції та заповнює незадані поля значеннями за замовчуванням.
+func (o Options) withDefaults() (Options, error) {
+	invalid := func(format string, args ...any) (Options, error) {
+		return o, fmt.Errorf("%w: %s", ErrInvalidOptions, fmt.Sprintf(format, args...))
+	}
+
+	if o.SegmentSize < 0 || o.MaxRecordSize < 0 || o.MaxKeySize < 0 || o.MaxValueSize < 0 {
+		return invalid("sizes must not be negative")
+	}
+	if o.HistorySize < 0 || o.WriteQueueSize < 0 || o.ReplicationLogSize < 0 {
+		return invalid("history, write queue and replication log sizes must not be negative")
+	}
+	if o.ReplicationLogSize == 0 {
+		o.ReplicationLogSize = DefaultReplicationLogSize
+	}
+	if o.SegmentSize == 0 {
+		o.SegmentSize = DefaultSegmentSize
+	}
+	if o.MaxRecordSize == 0 {
+		o.MaxRecordSize = DefaultMaxRecordSize
+	}
+	if o.MaxRecordSize > math.MaxUint32 {
+		return invalid("max record size %d exceeds the record format limit", o.MaxRecordSize)
+	}
+	if o.MaxKeySize == 0 {
+		o.MaxKeySize = DefaultMaxKeySize
+	}
+	if o.MaxKeySize > maxKeyLength {
+		return invalid("max key size %d exceeds the record format limit", o.MaxKeySize)
+	}
+	if int64(o.MaxKeySize) >= o.MaxRecordSize || o.MaxValueSize >= o.MaxRecordSize {
+		return invalid("max key and value sizes must be smaller than max record size")
+	}
+
+	if _, ok := syncPolicyNames[o.Sync]; !ok {
+		return invalid("unknown sync policy %d", o.Sync)
+	}
+	if o.SyncInterval < 0 {
+		return invalid("sync interval must not be negative")
+	}
+	if o.Sync == SyncInterval && o.SyncInterval == 0 {
+		o.SyncInterval = DefaultSyncInterval
+	}
+
+	if o.Compaction.Interval < 0 || o.Compaction.MinSegments < 0 {
+		return invalid("compaction interval and min segments must not be negative")
+	}
+	if o.Compaction.MinDeadRatio < 0 || o.Compaction.MinDeadRatio > 1 {
+		return invalid("compaction dead ratio must be within [0, 1]")
+	}
+
+	if o.Quotas.BucketBytes < 0 {
+		return invalid("bucket quota must not be negative")
+	}
+	for bucket, limit := range o.Quotas.Buckets {
+		if limit < 0 {
+			return invalid("quota of bucket %q must not be negative", bucket)
+		}
+	}
+
+	if _, ok := hashAlgorithmNames[o.Hash]; !ok {
+		return invalid("unknown hash algorithm %d", o.Hash)
+	}
+
+	if o.Logger == nil {
+		o.Logger = slog.New(slog.DiscardHandler)
+	}
+	return o, nil
+}
+
+// Options повертає опції, з якими відкрито базу, після застосування значень за замовчуванням.
+func (db *Db) Options() Options {
+	return db.opts
+}
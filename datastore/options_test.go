@@ -0,0 +1,179 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptions_WithDefaults(t *testing.T) {
+	opts, err := Options{}.withDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.SegmentSize != DefaultSegmentSize || opts.MaxRecordSize != DefaultMaxRecordSize || opts.MaxKeySize != DefaultMaxKeySize {
+		t.Errorf("defaults are not applied: %+v", opts)
+	}
+	if opts.Logger == nil {
+		t.Error("default logger must be set")
+	}
+
+	opts, err = Options{Sync: SyncInterval}.withDefaults()
+	if err != nil || opts.SyncInterval != DefaultSyncInterval {
+		t.Errorf("expected default sync interval, got %v (err: %v)", opts.SyncInterval, err)
+	}
+
+	invalid := []Options{
+		{SegmentSize: -1},
+		{MaxRecordSize: 1 << 33},
+		{MaxRecordSize: 100, MaxKeySize: 100},
+		{MaxRecordSize: 100, MaxValueSize: 200},
+		{MaxKeySize: 1 << 24},
+		{HistorySize: -1},
+		{WriteQueueSize: -1},
+		{ReplicationLogSize: -1},
+		{Sync: SyncPolicy(42)},
+		{SyncInterval: -time.Second},
+		{Compaction: CompactionPolicy{MinDeadRatio: 1.5}},
+		{Compaction: CompactionPolicy{Interval: -time.Second}},
+		{Hash: HashAlgorithm(42)},
+		{Hash: hashLegacySHA1},
+	}
+	for _, o := range invalid {
+		if _, err := o.withDefaults(); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("expected ErrInvalidOptions for %+v, got %v", o, err)
+		}
+	}
+}
+
+func TestSyncPolicy_Text(t *testing.T) {
+	for _, name := range []string{"never", "always", "interval"} {
+		var p SyncPolicy
+		if err := p.UnmarshalText([]byte(name)); err != nil {
+			t.Fatal(err)
+		}
+		if p.String() != name {
+			t.Errorf("round trip of %q gave %q", name, p)
+		}
+	}
+	var p SyncPolicy
+	if err := p.UnmarshalText([]byte("sometimes")); err == nil {
+		t.Error("expected error for unknown sync policy")
+	}
+}
+
+func TestDb_SizeLimits(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{MaxKeySize: 8, MaxValueSize: 16, Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	if err := db.Put("short", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("very-long-key", "value"); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := db.Put("short", strings.Repeat("v", 17)); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("expected ErrRecordTooLarge, got %v", err)
+	}
+}
+
+func TestDb_Quotas(t *testing.T) {
+	dir := t.TempDir()
+	record := recordSize(1, 10, DefaultBucket, HashSHA1)
+	opts := Options{Quotas: QuotaPolicy{BucketBytes: 2 * record, Buckets: map[string]int64{"free": 0}}}
+	db, err := OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := strings.Repeat("v", 10)
+	if err := db.Put("a", value); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("b", value); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("c", value); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+	// перезапис ключа не збільшує розмір бакета
+	if err := db.Put("a", strings.Repeat("w", 10)); err != nil {
+		t.Errorf("overwrite within quota failed: %v", err)
+	}
+	if err := db.Bucket("free").Put("c", strings.Repeat("v", 100)); err != nil {
+		t.Errorf("bucket without quota rejected a write: %v", err)
+	}
+
+	var batch Batch
+	batch.Put(DefaultBucket, "c", value)
+	batch.Delete(DefaultBucket, "b")
+	if err := db.WriteBatch(context.Background(), &batch); err != nil {
+		t.Errorf("batch that frees space was rejected: %v", err)
+	}
+	batch = Batch{}
+	batch.Put(DefaultBucket, "d", value)
+	if err := db.WriteBatch(context.Background(), &batch); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded for batch, got %v", err)
+	}
+	if err := db.Apply(context.Background(), LogRecord{Seq: 100, Type: EventPut, Bucket: DefaultBucket, Key: "d", Value: []byte(value)}); err != nil {
+		t.Errorf("replicated writes must not be limited: %v", err)
+	}
+	if err := db.Bucket(DefaultBucket).CheckQuota("e", 10); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded from CheckQuota, got %v", err)
+	}
+	if err := db.Delete("d"); err != nil {
+		t.Fatal(err)
+	}
+
+	// після перезапуску розміри бакетів відновлюються з сегментів
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if got := db.Bucket(DefaultBucket).Stats(); got.LiveBytes != 2*record || got.Quota != 2*record {
+		t.Errorf("unexpected bucket stats after reopen %+v", got)
+	}
+	if err := db.Put("e", value); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded after reopen, got %v", err)
+	}
+}
+
+func TestDb_BackgroundCompaction(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{
+		SegmentSize: 100,
+		Compaction:  CompactionPolicy{Interval: 10 * time.Millisecond, MinSegments: 2, MinDeadRatio: 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for i := 0; i < 10; i++ {
+		_ = db.Put("key", "value")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for db.Stats().Compactions.Count == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("background compaction did not run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v, err := db.Get("key"); err != nil || v != "value" {
+		t.Errorf("Get after background compaction = %q, %v", v, err)
+	}
+}
@@ -0,0 +1,238 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// ErrLogTruncated означає, що запитаної позиції вже немає у журналі реплікації.
+var ErrLogTruncated = fmt.Errorf("replication log is truncated")
+
+// LogRecord - запис журналу бази у порядку, в якому його застосував writerLoop.
+type LogRecord struct {
+	Seq    uint64    `json:"seq"`
+	Type   EventType `json:"type"`
+	Bucket string    `json:"bucket"`
+	Key    string    `json:"key,omitempty"`
+	Value  []byte    `json:"value,omitempty"`
+}
+
+type logEntry struct {
+	ref    recordRef
+	kind   entryKind
+	bucket string
+	key    string
+}
+
+// replicationLog - вікно останніх записів журналу, з якого читають репліки.
+// Усі методи викликаються з утриманим indexLock.
+type replicationLog struct {
+	entries []logEntry
+	limit   int
+	// floor - номер послідовності, після якого журнал повний
+	floor  uint64
+	last   uint64
+	notify chan struct{}
+}
+
+func newReplicationLog(limit int) replicationLog {
+	return replicationLog{limit: limit, notify: make(chan struct{})}
+}
+
+func (l *replicationLog) append(e logEntry) {
+	l.entries = append(l.entries, e)
+	if len(l.entries) > l.limit {
+		l.floor = l.entries[0].ref.seq
+		l.entries = l.entries[1:]
+	}
+	l.last = max(l.last, e.ref.seq)
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// reset забуває записи до seq включно, наприклад коли компакція видалила старі сегменти.
+func (l *replicationLog) reset(seq uint64) {
+	l.entries = nil
+	l.floor = seq
+	l.last = seq
+}
+
+func (l *replicationLog) rename(from, to string) {
+	for i := range l.entries {
+		if l.entries[i].ref.file == from {
+			l.entries[i].ref.file = to
+		}
+	}
+}
+
+// LastSeq повертає номер послідовності останнього застосованого запису.
+func (db *Db) LastSeq() uint64 {
+	db.indexLock.RLock()
+	defer db.indexLock.RUnlock()
+	return db.replLog.last
+}
+
+// ReadLog повертає до limit записів журналу з номерами після after. Якщо нових записів ще
+// немає, чекає на них, доки не завершиться ctx. Якщо записів після after вже немає у
+// журналі, повертає ErrLogTruncated, і репліка має почати з Snapshot.
+func (db *Db) ReadLog(ctx context.Context, after uint64, limit int) ([]LogRecord, error) {
+	for {
+		db.indexLock.RLock()
+		replLog := &db.replLog
+		if floor, last := replLog.floor, replLog.last; after < floor || after > last {
+			db.indexLock.RUnlock()
+			return nil, fmt.Errorf("%w: seq %d is outside of (%d, %d]", ErrLogTruncated, after, floor, last)
+		}
+		if after == replLog.last {
+			wait := replLog.notify
+			db.indexLock.RUnlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-db.quitChan:
+				return nil, ErrClosed
+			}
+		}
+
+		start := sort.Search(len(replLog.entries), func(i int) bool {
+			return replLog.entries[i].ref.seq > after
+		})
+		end := min(len(replLog.entries), start+limit)
+		batch := append([]logEntry(nil), replLog.entries[start:end]...)
+		db.indexLock.RUnlock()
+
+		return db.readLogEntries(batch)
+	}
+}
+
+func (db *Db) readLogEntries(batch []logEntry) ([]LogRecord, error) {
+	res := make([]LogRecord, 0, len(batch))
+	for _, e := range batch {
+		rec := LogRecord{Seq: e.ref.seq, Type: EventPut, Bucket: e.bucket, Key: e.key}
+		switch e.kind {
+		case entryDelete:
+			rec.Type = EventDelete
+		case entryDropBucket:
+			rec.Type = EventDropBucket
+		default:
+			value, err := db.readValue(e.ref)
+			if err != nil {
+				// компакція могла видалити сегмент, поки значення читалося
+				db.indexLock.RLock()
+				truncated := e.ref.seq <= db.replLog.floor
+				db.indexLock.RUnlock()
+				if truncated {
+					return nil, fmt.Errorf("%w: seq %d", ErrLogTruncated, e.ref.seq)
+				}
+				return nil, err
+			}
+			rec.Value = []byte(value)
+		}
+		res = append(res, rec)
+	}
+	return res, nil
+}
+
+// Snapshot викликає fn для кожного живого ключа всіх бакетів, впорядкованих за бакетом і ключем,
+// і повертає номер послідовності, на якому знімок узгоджений. Записи, зроблені під час
+// обходу, у знімок не потрапляють і доступні через ReadLog після цього номера.
+func (db *Db) Snapshot(fn func(LogRecord) error) (uint64, error) {
+	type item struct {
+		bucket, key string
+		ref         recordRef
+	}
+	var items []item
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+
+	// Під outLock ні ротація, ні компакція не змінять файли, поки вони відкриваються;
+	// далі відкриті дескриптори лишаються чинними, навіть якщо компакція видалить файли.
+	db.outLock.Lock()
+	db.indexLock.RLock()
+	seq := db.replLog.last
+	for bucket, keys := range db.index {
+		for key, ref := range keys {
+			items = append(items, item{bucket, key, ref})
+		}
+	}
+	db.indexLock.RUnlock()
+	var err error
+	for _, it := range items {
+		if _, ok := files[it.ref.file]; ok {
+			continue
+		}
+		var f *os.File
+		if f, err = os.Open(it.ref.file); err != nil {
+			break
+		}
+		files[it.ref.file] = f
+	}
+	db.outLock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].bucket != items[j].bucket {
+			return items[i].bucket < items[j].bucket
+		}
+		return items[i].key < items[j].key
+	})
+	for _, it := range items {
+		var record entry
+		section := io.NewSectionReader(files[it.ref.file], it.ref.offset, it.ref.size)
+		if _, err := record.decodeFromReader(bufio.NewReader(section), db.maxRecordSize); err != nil {
+			return 0, err
+		}
+		value, err := db.verifyRecord(record, it.ref)
+		if err != nil {
+			return 0, err
+		}
+		err = fn(LogRecord{Seq: it.ref.seq, Type: EventPut, Bucket: it.bucket, Key: it.key, Value: []byte(value)})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// Apply записує запис журналу лідера з його номером послідовності. Видалення відсутнього
+// ключа чи бакета не вважається помилкою, тож повторне застосування запису безпечне.
+func (db *Db) Apply(ctx context.Context, rec LogRecord) error {
+	if !ValidBucketName(rec.Bucket) {
+		return ErrInvalidBucket
+	}
+	if rec.Seq == 0 {
+		return fmt.Errorf("cannot apply record without a sequence number")
+	}
+	req := writeRequest{bucket: rec.Bucket, key: rec.Key, seq: rec.Seq}
+	switch rec.Type {
+	case EventPut:
+		req.kind = entryPut
+		req.value, req.size = bytes.NewReader(rec.Value), int64(len(rec.Value))
+	case EventDelete:
+		req.kind = entryDelete
+	case EventDropBucket:
+		req.kind = entryDropBucket
+	default:
+		return fmt.Errorf("cannot apply %s record", rec.Type)
+	}
+
+	err := db.write(ctx, req)
+	if errors.Is(err, ErrNotFound) && req.kind != entryPut {
+		return nil
+	}
+	return err
+}
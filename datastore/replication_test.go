@@ -0,0 +1,155 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDb_ReadLog(t *testing.T) {
+	db, err := OpenWithOptions(t.TempDir(), Options{SegmentSize: 100, ReplicationLogSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	_ = db.Put("k1", "v1")
+	_ = db.Bucket("users").Put("alice", "admin")
+	_ = db.Delete("k1")
+	_ = db.DropBucket("users")
+
+	recs, err := db.ReadLog(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LogRecord{
+		{Seq: 1, Type: EventPut, Bucket: DefaultBucket, Key: "k1", Value: []byte("v1")},
+		{Seq: 2, Type: EventPut, Bucket: "users", Key: "alice", Value: []byte("admin")},
+		{Seq: 3, Type: EventDelete, Bucket: DefaultBucket, Key: "k1"},
+		{Seq: 4, Type: EventDropBucket, Bucket: "users"},
+	}
+	if !reflect.DeepEqual(recs, want) {
+		t.Errorf("ReadLog = %+v, want %+v", recs, want)
+	}
+	if recs, _ := db.ReadLog(context.Background(), 1, 2); len(recs) != 2 || recs[0].Seq != 2 {
+		t.Errorf("ReadLog(after=1, limit=2) = %+v", recs)
+	}
+
+	t.Run("waits for new records", func(t *testing.T) {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			_ = db.Put("k2", "v2")
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		recs, err := db.ReadLog(ctx, db.LastSeq(), 10)
+		if err != nil || len(recs) != 1 || recs[0].Key != "k2" {
+			t.Errorf("ReadLog at the end = %+v, %v", recs, err)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, err := db.ReadLog(ctx, db.LastSeq(), 10); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("ReadLog without new records = %v", err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			_ = db.Put(fmt.Sprintf("key-%d", i), "value")
+		}
+		if _, err := db.ReadLog(context.Background(), 1, 10); !errors.Is(err, ErrLogTruncated) {
+			t.Errorf("ReadLog behind the window = %v", err)
+		}
+		last := db.LastSeq()
+		if err := db.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ReadLog(context.Background(), last-1, 10); !errors.Is(err, ErrLogTruncated) {
+			t.Errorf("ReadLog after compaction = %v", err)
+		}
+		_ = db.Put("after-compaction", "value")
+		if recs, err := db.ReadLog(context.Background(), last, 10); err != nil || len(recs) != 1 {
+			t.Errorf("ReadLog after compaction from the last seq = %+v, %v", recs, err)
+		}
+	})
+}
+
+func TestDb_SnapshotAndApply(t *testing.T) {
+	leader, err := Open(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = leader.Close()
+	})
+	followerDir := t.TempDir()
+	follower, err := Open(followerDir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = leader.Put("k1", "v1")
+	_ = leader.Put("k2", "v2")
+	_ = leader.Bucket("users").Put("alice", "admin")
+	_ = leader.Put("k1", "v1.1")
+
+	seq, err := leader.Snapshot(func(rec LogRecord) error {
+		return follower.Apply(context.Background(), rec)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 4 {
+		t.Errorf("snapshot seq = %d, want 4", seq)
+	}
+
+	_ = leader.Delete("k2")
+	recs, err := leader.ReadLog(context.Background(), seq, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		if err := follower.Apply(context.Background(), rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// повторне застосування видалення не є помилкою
+	if err := follower.Apply(context.Background(), recs[0]); err != nil {
+		t.Errorf("re-applying delete = %v", err)
+	}
+
+	check := func(db *Db) {
+		t.Helper()
+		if v, seq, err := db.GetWithSeq("k1"); err != nil || v != "v1.1" || seq != 4 {
+			t.Errorf("k1 = %q (seq %d), %v", v, seq, err)
+		}
+		if _, err := db.Get("k2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("k2 must be deleted, got %v", err)
+		}
+		if v, err := db.Bucket("users").Get("alice"); err != nil || v != "admin" {
+			t.Errorf("users/alice = %q, %v", v, err)
+		}
+		if db.LastSeq() != 5 {
+			t.Errorf("LastSeq = %d, want 5", db.LastSeq())
+		}
+	}
+	check(follower)
+
+	if err := follower.Close(); err != nil {
+		t.Fatal(err)
+	}
+	follower, err = Open(followerDir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = follower.Close()
+	})
+	check(follower)
+}
@@ -0,0 +1,220 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Значення, більші за цей розмір, не розбираються як JSON і не потрапляють у вторинні індекси.
+const maxIndexedValueSize = 1 << 20
+
+var ErrUnknownIndex = fmt.Errorf("unknown index")
+
+// IndexSpec оголошує вторинний індекс за полем JSON-значень бакета.
+// Path - шлях через крапку, наприклад "user.address.city" або "tags.0".
+type IndexSpec struct {
+	Name   string `json:"name"`
+	Bucket string `json:"bucket"`
+	Path   string `json:"path"`
+}
+
+type secondaryIndex struct {
+	spec    IndexSpec
+	path    []string
+	entries map[string]map[string]struct{}
+	byKey   map[string]string
+}
+
+func newSecondaryIndex(spec IndexSpec) (*secondaryIndex, error) {
+	if spec.Bucket == "" {
+		spec.Bucket = DefaultBucket
+	}
+	if spec.Name == "" || spec.Path == "" {
+		return nil, fmt.Errorf("index must have a name and a path")
+	}
+	if !ValidBucketName(spec.Bucket) {
+		return nil, ErrInvalidBucket
+	}
+	return &secondaryIndex{
+		spec:    spec,
+		path:    strings.Split(spec.Path, "."),
+		entries: make(map[string]map[string]struct{}),
+		byKey:   make(map[string]string),
+	}, nil
+}
+
+// extract повертає значення поля у вигляді рядка; об'єкти та масиви не індексуються.
+func (idx *secondaryIndex) extract(value []byte) (string, bool) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return "", false
+	}
+	for _, part := range idx.path {
+		switch node := doc.(type) {
+		case map[string]any:
+			next, ok := node[part]
+			if !ok {
+				return "", false
+			}
+			doc = next
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			doc = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch v := doc.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "null", true
+	}
+	return "", false
+}
+
+func (idx *secondaryIndex) remove(key string) {
+	old, ok := idx.byKey[key]
+	if !ok {
+		return
+	}
+	delete(idx.byKey, key)
+	keys := idx.entries[old]
+	delete(keys, key)
+	if len(keys) == 0 {
+		delete(idx.entries, old)
+	}
+}
+
+func (idx *secondaryIndex) put(key string, value []byte) {
+	idx.remove(key)
+	field, ok := idx.extract(value)
+	if !ok {
+		return
+	}
+	keys := idx.entries[field]
+	if keys == nil {
+		keys = make(map[string]struct{})
+		idx.entries[field] = keys
+	}
+	keys[key] = struct{}{}
+	idx.byKey[key] = field
+}
+
+func (idx *secondaryIndex) clear() {
+	idx.entries = make(map[string]map[string]struct{})
+	idx.byKey = make(map[string]string)
+}
+
+// updateSecondary викликається з утриманим indexLock після того, як запис потрапив у журнал.
+// value дорівнює nil, якщо значення завелике для індексування.
+func (db *Db) updateSecondary(meta recordMeta, value []byte) {
+	for _, idx := range db.secondary {
+		if idx.spec.Bucket != meta.bucket {
+			continue
+		}
+		switch {
+		case meta.kind == entryDropBucket:
+			idx.clear()
+		case meta.kind == entryDelete || value == nil:
+			idx.remove(meta.key)
+		default:
+			idx.put(meta.key, value)
+		}
+	}
+}
+
+// rebuildSecondary заново будує вторинні індекси з живих записів після відновлення.
+func (db *Db) rebuildSecondary() error {
+	for _, idx := range db.secondary {
+		idx.clear()
+		for key, ref := range db.index[idx.spec.Bucket] {
+			if ref.size > maxIndexedValueSize {
+				continue
+			}
+			value, err := db.readValue(ref)
+			// пошкоджений запис лише не потрапляє в індекс; його читання й далі повертає помилку
+			if errors.Is(err, ErrHashMismatch) {
+				db.log.Warn("corrupt record skipped in index rebuild", "index", idx.spec.Name,
+					"key", key, "segment", filepath.Base(ref.file), "offset", ref.offset, "err", err)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("rebuild index %s: %w", idx.spec.Name, err)
+			}
+			idx.put(key, []byte(value))
+		}
+	}
+	return nil
+}
+
+// Indexes повертає оголошені вторинні індекси.
+func (db *Db) Indexes() []IndexSpec {
+	res := make([]IndexSpec, 0, len(db.secondary))
+	for _, idx := range db.secondary {
+		res = append(res, idx.spec)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Lookup повертає відсортовані ключі, для яких поле індексу дорівнює eq.
+func (db *Db) Lookup(index, eq string) ([]string, error) {
+	idx, ok := db.secondary[index]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIndex, index)
+	}
+
+	db.indexLock.RLock()
+	defer db.indexLock.RUnlock()
+
+	res := make([]string, 0, len(idx.entries[eq]))
+	for key := range idx.entries[eq] {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// cappedBuffer накопичує значення для індексування, поки воно не перевищить limit.
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if !b.overflow {
+		if b.buf.Len()+len(p) > b.limit {
+			b.overflow = true
+			b.buf.Reset()
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) bytes() []byte {
+	if b.overflow {
+		return nil
+	}
+	return b.buf.Bytes()
+}
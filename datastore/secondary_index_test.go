@@ -0,0 +1,150 @@
+package datastore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSecondaryIndex_Extract(t *testing.T) {
+	idx, err := newSecondaryIndex(IndexSpec{Name: "city", Path: "address.city"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, _ := newSecondaryIndex(IndexSpec{Name: "tag", Path: "tags.1"})
+	age, _ := newSecondaryIndex(IndexSpec{Name: "age", Path: "age"})
+
+	tests := []struct {
+		idx   *secondaryIndex
+		value string
+		want  string
+		ok    bool
+	}{
+		{idx, `{"address":{"city":"Kyiv"}}`, "Kyiv", true},
+		{idx, `{"address":{"street":"Main"}}`, "", false},
+		{idx, `{"address":{"city":{"name":"Kyiv"}}}`, "", false},
+		{idx, `not json`, "", false},
+		{tags, `{"tags":["a","b"]}`, "b", true},
+		{tags, `{"tags":["a"]}`, "", false},
+		{age, `{"age":42}`, "42", true},
+		{age, `{"age":true}`, "true", true},
+	}
+	for _, tc := range tests {
+		got, ok := tc.idx.extract([]byte(tc.value))
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("extract(%s, %s) = %q, %v; wanted %q, %v", tc.idx.spec.Path, tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestDb_SecondaryIndex(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		SegmentSize: 300,
+		Indexes: []IndexSpec{
+			{Name: "city", Path: "city"},
+			{Name: "team", Bucket: "people", Path: "team"},
+		},
+	}
+	db, err := OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = db.Put("u1", `{"city":"Kyiv"}`)
+	_ = db.Put("u2", `{"city":"Lviv"}`)
+	_ = db.Put("u3", `{"city":"Kyiv"}`)
+	_ = db.Put("u4", `plain text`)
+	_ = db.Bucket("people").Put("p1", `{"team":"dreamteam"}`)
+	_ = db.Bucket("people").Put("p2", `{"team":"dreamteam"}`)
+
+	assertLookup := func(t *testing.T, index, eq string, want []string) {
+		t.Helper()
+		got, err := db.Lookup(index, eq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Lookup(%s, %s) = %v, wanted %v", index, eq, got, want)
+		}
+	}
+
+	assertLookup(t, "city", "Kyiv", []string{"u1", "u3"})
+	assertLookup(t, "team", "dreamteam", []string{"p1", "p2"})
+
+	_ = db.Put("u1", `{"city":"Lviv"}`)
+	_ = db.Delete("u3")
+	assertLookup(t, "city", "Kyiv", []string{})
+	assertLookup(t, "city", "Lviv", []string{"u1", "u2"})
+
+	if _, err := db.Lookup("missing", "x"); !errors.Is(err, ErrUnknownIndex) {
+		t.Errorf("expected ErrUnknownIndex, got %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	assertLookup(t, "city", "Lviv", []string{"u1", "u2"})
+	assertLookup(t, "team", "dreamteam", []string{"p1", "p2"})
+
+	if err := db.DropBucket("people"); err != nil {
+		t.Fatal(err)
+	}
+	assertLookup(t, "team", "dreamteam", []string{})
+}
+
+func TestDb_SecondaryIndexSkipsCorruptRecords(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Indexes: []IndexSpec{{Name: "city", Path: "city"}}}
+	db, err := OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("alice", `{"city": "Kyiv"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("bob", `{"city": "Lviv"}`); err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := db.lookup(DefaultBucket, "bob")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// псуємо байт значення "bob", не змінюючи довжин полів
+	f, err := os.OpenFile(filepath.Join(dir, outFileName), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte("X"), ref.offset+8+int64(len("bob"))+4+2)
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatalf("a corrupt indexed record must not prevent opening: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if keys, err := db.Lookup("city", "Kyiv"); err != nil || !reflect.DeepEqual(keys, []string{"alice"}) {
+		t.Errorf("Lookup Kyiv = %v, %v", keys, err)
+	}
+	if keys, _ := db.Lookup("city", "Lviv"); len(keys) != 0 {
+		t.Errorf("corrupt record must not be indexed, got %v", keys)
+	}
+	if _, err := db.Get("bob"); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("expected ErrHashMismatch for the corrupt record, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// sealedSegment тримає відображений у пам'ять вміст сегмента, який більше не змінюється.
+type sealedSegment struct {
+	path string
+	data []byte
+}
+
+func openSealedSegment(path string) (*sealedSegment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	seg := &sealedSegment{path: path}
+	if info.Size() == 0 {
+		return seg, nil
+	}
+	data, err := mmapFile(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("mmap %s: %w", path, err)
+	}
+	seg.data = data
+	return seg, nil
+}
+
+func (s *sealedSegment) read(offset int64) (entry, error) {
+	var record entry
+	if offset < 0 || offset+4 > int64(len(s.data)) {
+		return record, fmt.Errorf("segment %s: offset %d out of range", s.path, offset)
+	}
+	size := int64(binary.LittleEndian.Uint32(s.data[offset:]))
+	if size < minRecordSize {
+		return record, fmt.Errorf("segment %s: %w: bad record size %d at %d", s.path, ErrHashMismatch, size, offset)
+	}
+	if offset+size > int64(len(s.data)) {
+		return record, fmt.Errorf("segment %s: %w: record at %d is truncated", s.path, ErrHashMismatch, offset)
+	}
+	if err := record.Decode(s.data[offset : offset+size]); err != nil {
+		return record, fmt.Errorf("segment %s: record at %d: %w", s.path, offset, err)
+	}
+	return record, nil
+}
+
+func (s *sealedSegment) close() error {
+	if s.data == nil {
+		return nil
+	}
+	err := munmap(s.data)
+	s.data = nil
+	return err
+}
@@ -0,0 +1,223 @@
+package datastore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+type LatencyStats struct {
+	Count uint64        `json:"count"`
+	Total time.Duration `json:"total_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+type SegmentStats struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// SegmentInfo - докладні відомості про сегмент. LiveKeyRatio - частка записів сегмента,
+// на які посилається індекс; решту прибере компакція.
+type SegmentInfo struct {
+	Name         string  `json:"name"`
+	Size         int64   `json:"size"`
+	Records      int     `json:"records"`
+	LiveKeys     int     `json:"live_keys"`
+	LiveBytes    int64   `json:"live_bytes"`
+	LiveKeyRatio float64 `json:"live_key_ratio"`
+	// Version - версія формату сегмента; Created відомий лише для сегментів із заголовком.
+	Version int        `json:"version"`
+	Created *time.Time `json:"created,omitempty"`
+}
+
+type Stats struct {
+	Keys      int   `json:"keys"`
+	Buckets   int   `json:"buckets"`
+	LiveBytes int64 `json:"live_bytes"`
+	DeadBytes int64 `json:"dead_bytes"`
+	// Segments містить запечатані сегменти та активний файл current-data останнім.
+	Segments    []SegmentStats `json:"segments"`
+	Puts        LatencyStats   `json:"puts"`
+	Deletes     LatencyStats   `json:"deletes"`
+	Gets        LatencyStats   `json:"gets"`
+	Compactions LatencyStats   `json:"compactions"`
+	Batches     LatencyStats   `json:"batches"`
+	WriteQueue  int64          `json:"write_queue"`
+	// OutdatedSegments - кількість сегментів у старішій версії формату; компакція перепише їх.
+	OutdatedSegments int `json:"outdated_segments"`
+}
+
+type latency struct {
+	count atomic.Uint64
+	total atomic.Int64
+	max   atomic.Int64
+}
+
+func (l *latency) observe(start time.Time) {
+	d := int64(time.Since(start))
+	l.count.Add(1)
+	l.total.Add(d)
+	for {
+		cur := l.max.Load()
+		if d <= cur || l.max.CompareAndSwap(cur, d) {
+			return
+		}
+	}
+}
+
+func (l *latency) snapshot() LatencyStats {
+	return LatencyStats{
+		Count: l.count.Load(),
+		Total: time.Duration(l.total.Load()),
+		Max:   time.Duration(l.max.Load()),
+	}
+}
+
+type metrics struct {
+	puts        latency
+	deletes     latency
+	gets        latency
+	compactions latency
+	batches     latency
+	// pending - кількість запитів, що чекають на writerLoop у writeChan
+	pending atomic.Int64
+}
+
+func (db *Db) Stats() Stats {
+	stats := Stats{
+		Puts:        db.metrics.puts.snapshot(),
+		Deletes:     db.metrics.deletes.snapshot(),
+		Gets:        db.metrics.gets.snapshot(),
+		Compactions: db.metrics.compactions.snapshot(),
+		Batches:     db.metrics.batches.snapshot(),
+		WriteQueue:  db.metrics.pending.Load() + int64(len(db.writeChan)),
+	}
+
+	// набір файлів знімається під indexLock, а розміри сегментів читаються вже без блокувань,
+	// щоб не чекати на запис, що копіює велике значення під outLock
+	db.indexLock.RLock()
+	stats.Buckets = len(db.index)
+	for _, keys := range db.index {
+		stats.Keys += len(keys)
+		for _, ref := range keys {
+			stats.LiveBytes += ref.size
+		}
+	}
+
+	// заголовки сегментів не належать жодному запису і компакцією не прибираються
+	var total int64
+	for _, header := range db.headers {
+		total -= header.size
+		if header.version < FormatVersion {
+			stats.OutdatedSegments++
+		}
+	}
+	segments := slices.Clone(db.segments)
+	outOffset := db.outOffset
+	db.indexLock.RUnlock()
+
+	for _, seg := range segments {
+		info, err := os.Stat(seg)
+		if err != nil {
+			continue
+		}
+		stats.Segments = append(stats.Segments, SegmentStats{Name: filepath.Base(seg), Size: info.Size()})
+		total += info.Size()
+	}
+	stats.Segments = append(stats.Segments, SegmentStats{Name: outFileName, Size: outOffset})
+	total += outOffset
+	stats.DeadBytes = total - stats.LiveBytes
+
+	return stats
+}
+
+// Segments повертає відомості про запечатані сегменти та активний файл current-data останнім.
+// Записи рахуються читанням файлів поза блокуваннями, тож сегмент, видалений компакцією
+// під час підрахунку, пропускається.
+func (db *Db) Segments() ([]SegmentInfo, error) {
+	if db.closed() {
+		return nil, ErrClosed
+	}
+
+	db.indexLock.RLock()
+	paths := append(append([]string(nil), db.segments...), filepath.Join(db.dir, outFileName))
+	infos := make([]SegmentInfo, len(paths))
+	byPath := make(map[string]*SegmentInfo, len(paths))
+	for i, path := range paths {
+		infos[i].Name = filepath.Base(path)
+		byPath[path] = &infos[i]
+	}
+	// current-data читається лише до поточного зсуву, щоб не побачити запис, що пишеться
+	infos[len(infos)-1].Size = db.outOffset
+	for _, keys := range db.index {
+		for _, ref := range keys {
+			if info, ok := byPath[ref.file]; ok {
+				info.LiveKeys++
+				info.LiveBytes += ref.size
+			}
+		}
+	}
+	db.indexLock.RUnlock()
+
+	res := infos[:0]
+	for i, path := range paths {
+		info := infos[i]
+		if err := db.countRecords(path, &info); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("segment %s: %w", info.Name, err)
+		}
+		if info.Records > 0 {
+			info.LiveKeyRatio = float64(info.LiveKeys) / float64(info.Records)
+		}
+		res = append(res, info)
+	}
+	return res, nil
+}
+
+// countRecords проходить записи файлу за їхніми розмірами, не читаючи значень.
+// Для запечатаних сегментів розмір береться з файлу, для current-data - з info.Size.
+func (db *Db) countRecords(path string, info *SegmentInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if filepath.Base(path) != outFileName {
+		st, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		info.Size = st.Size()
+	}
+
+	in := bufio.NewReader(io.LimitReader(f, info.Size))
+	header, err := readSegmentHeader(in)
+	if err != nil {
+		return err
+	}
+	info.Version = int(header.version)
+	if header.version > 0 {
+		info.Created = &header.created
+	}
+	for {
+		meta, err := decodeMeta(in, db.maxRecordSize)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// маркери пакетів не є записами
+		if meta.kind != entryBatchBegin && meta.kind != entryBatchCommit {
+			info.Records++
+		}
+	}
+}
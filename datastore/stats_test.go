@@ -0,0 +1,155 @@
+package datastore
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDb_Stats(t *testing.T) {
+	db, err := Open(t.TempDir(), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for i := 0; i < 5; i++ {
+		_ = db.Put("key", "value")
+	}
+	_ = db.Bucket("other").Put("key", "value")
+	_, _ = db.Get("key")
+	_, _ = db.Get("missing")
+	_ = db.Delete("key")
+
+	stats := db.Stats()
+	if stats.Keys != 1 || stats.Buckets != 1 {
+		t.Errorf("unexpected key count %d in %d buckets", stats.Keys, stats.Buckets)
+	}
+	if stats.Puts.Count != 6 || stats.Gets.Count != 2 || stats.Deletes.Count != 1 {
+		t.Errorf("unexpected operation counters: %+v %+v %+v", stats.Puts, stats.Gets, stats.Deletes)
+	}
+	if stats.Puts.Total <= 0 || stats.Puts.Max <= 0 {
+		t.Errorf("put latency is not recorded: %+v", stats.Puts)
+	}
+	if len(stats.Segments) < 2 || stats.Segments[len(stats.Segments)-1].Name != outFileName {
+		t.Errorf("unexpected segments %+v", stats.Segments)
+	}
+	if stats.LiveBytes <= 0 || stats.DeadBytes <= 0 {
+		t.Errorf("unexpected live/dead bytes: %d/%d", stats.LiveBytes, stats.DeadBytes)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	stats = db.Stats()
+	if stats.Compactions.Count != 1 {
+		t.Errorf("expected one compaction, got %+v", stats.Compactions)
+	}
+	if stats.DeadBytes != 0 {
+		t.Errorf("expected no dead bytes after compaction, got %d", stats.DeadBytes)
+	}
+	if stats.WriteQueue != 0 {
+		t.Errorf("expected empty write queue, got %d", stats.WriteQueue)
+	}
+}
+
+func TestDb_Segments(t *testing.T) {
+	db, err := Open(t.TempDir(), 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	for i := 0; i < 6; i++ {
+		_ = db.Put("key", "value")
+	}
+	_ = db.Put("other", "value")
+
+	segments, err := db.Segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 2 || segments[len(segments)-1].Name != outFileName {
+		t.Fatalf("unexpected segments %+v", segments)
+	}
+	records, live := 0, 0
+	for _, seg := range segments {
+		if seg.Size <= 0 || seg.Records == 0 {
+			t.Errorf("segment %s has no records: %+v", seg.Name, seg)
+		}
+		if seg.LiveKeyRatio < 0 || seg.LiveKeyRatio > 1 {
+			t.Errorf("segment %s has ratio %f", seg.Name, seg.LiveKeyRatio)
+		}
+		records += seg.Records
+		live += seg.LiveKeys
+	}
+	if records != 7 || live != 2 {
+		t.Errorf("expected 7 records with 2 live keys, got %d and %d", records, live)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	segments, err = db.Segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 || segments[0].LiveKeyRatio != 1 || segments[1].Records != 0 {
+		t.Errorf("unexpected segments after compaction %+v", segments)
+	}
+}
+
+func TestDb_StatsDuringSlowUpload(t *testing.T) {
+	db, err := Open(t.TempDir(), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, pw := io.Pipe()
+	uploaded := make(chan error, 1)
+	go func() {
+		uploaded <- db.PutStream("upload", pr, 100)
+	}()
+	if _, err := pw.Write([]byte(strings.Repeat("x", 50))); err != nil {
+		t.Fatal(err)
+	}
+	// половина значення вже прочитана, тож писач утримує outLock до кінця завантаження
+	if db.outLock.TryLock() {
+		db.outLock.Unlock()
+		t.Fatal("writer must hold outLock while copying the value")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = db.Stats()
+		_, _ = db.Segments()
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Error("Stats and Segments must not wait for an upload in progress")
+	}
+
+	if _, err := pw.Write([]byte(strings.Repeat("x", 50))); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-uploaded; err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if stats := db.Stats(); stats.Keys != 2 {
+		t.Errorf("expected 2 keys after the upload, got %d", stats.Keys)
+	}
+}
@@ -0,0 +1,133 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const watchBufferSize = 256
+
+type EventType int
+
+const (
+	EventPut EventType = iota
+	EventDelete
+	EventDropBucket
+	// EventDropped означає, що підписник не встигав читати і пропустив Dropped подій.
+	EventDropped
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventDropBucket:
+		return "drop-bucket"
+	case EventDropped:
+		return "dropped"
+	}
+	return "unknown"
+}
+
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *EventType) UnmarshalText(text []byte) error {
+	for _, candidate := range []EventType{EventPut, EventDelete, EventDropBucket, EventDropped} {
+		if candidate.String() == string(text) {
+			*t = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event type %q", text)
+}
+
+type Event struct {
+	Type    EventType
+	Bucket  string
+	Key     string
+	Seq     uint64
+	Dropped uint64
+}
+
+type watcher struct {
+	bucket  string
+	prefix  string
+	ch      chan Event
+	dropped uint64
+}
+
+type watchers struct {
+	lock sync.Mutex
+	list map[<-chan Event]*watcher
+}
+
+// Watch повертає канал з подіями про записи бакета за замовчуванням, ключі яких починаються з prefix.
+// Повільний підписник не блокує запис: події, що не вмістилися в буфер, відкидаються,
+// а підписник отримує EventDropped з кількістю пропущених подій.
+func (db *Db) Watch(prefix string) <-chan Event {
+	return db.watch(DefaultBucket, prefix)
+}
+
+func (db *Db) watch(bucket, prefix string) <-chan Event {
+	w := &watcher{bucket: bucket, prefix: prefix, ch: make(chan Event, watchBufferSize)}
+
+	db.watchers.lock.Lock()
+	defer db.watchers.lock.Unlock()
+	if db.watchers.list == nil {
+		close(w.ch)
+		return w.ch
+	}
+	db.watchers.list[w.ch] = w
+	return w.ch
+}
+
+// Unwatch припиняє підписку і закриває канал.
+func (db *Db) Unwatch(ch <-chan Event) {
+	db.watchers.lock.Lock()
+	defer db.watchers.lock.Unlock()
+	if w, ok := db.watchers.list[ch]; ok {
+		delete(db.watchers.list, ch)
+		close(w.ch)
+	}
+}
+
+func (db *Db) publish(ev Event) {
+	db.watchers.lock.Lock()
+	defer db.watchers.lock.Unlock()
+	for _, w := range db.watchers.list {
+		if ev.Bucket != w.bucket {
+			continue
+		}
+		if ev.Type != EventDropBucket && !strings.HasPrefix(ev.Key, w.prefix) {
+			continue
+		}
+		if w.dropped > 0 {
+			select {
+			case w.ch <- Event{Type: EventDropped, Bucket: w.bucket, Seq: ev.Seq, Dropped: w.dropped}:
+				w.dropped = 0
+			default:
+				w.dropped++
+				continue
+			}
+		}
+		select {
+		case w.ch <- ev:
+		default:
+			w.dropped++
+		}
+	}
+}
+
+func (db *Db) closeWatchers() {
+	db.watchers.lock.Lock()
+	defer db.watchers.lock.Unlock()
+	for _, w := range db.watchers.list {
+		close(w.ch)
+	}
+	db.watchers.list = nil
+}
@@ -0,0 +1,117 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func TestDb_Watch(t *testing.T) {
+	db, err := Open(t.TempDir(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	events := db.Watch("user:")
+	defer db.Unwatch(events)
+
+	_ = db.Put("other", "x")
+	_ = db.Put("user:1", "a")
+	_ = db.Delete("user:1")
+
+	put := receive(t, events)
+	if put.Type != EventPut || put.Key != "user:1" {
+		t.Errorf("unexpected event %+v", put)
+	}
+	del := receive(t, events)
+	if del.Type != EventDelete || del.Key != "user:1" {
+		t.Errorf("unexpected event %+v", del)
+	}
+	if del.Seq <= put.Seq {
+		t.Errorf("sequence numbers must grow: put=%d, delete=%d", put.Seq, del.Seq)
+	}
+}
+
+func TestDb_WatchDropsForSlowConsumer(t *testing.T) {
+	db, err := Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	events := db.Watch("")
+	total := watchBufferSize + 10
+	for i := 0; i < total; i++ {
+		if err := db.Put(fmt.Sprintf("k%d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < watchBufferSize; i++ {
+		receive(t, events)
+	}
+
+	_ = db.Put("last", "v")
+	dropped := receive(t, events)
+	if dropped.Type != EventDropped || dropped.Dropped != 10 {
+		t.Errorf("expected dropped event for 10 events, got %+v", dropped)
+	}
+	if last := receive(t, events); last.Key != "last" {
+		t.Errorf("expected event for last key, got %+v", last)
+	}
+
+	db.Unwatch(events)
+	if _, ok := <-events; ok {
+		t.Error("channel must be closed after Unwatch")
+	}
+}
+
+func TestDb_Delete(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = db.Put("k1", "v1")
+	_ = db.Put("k2", "v2")
+	if err := db.Delete("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for missing key, got %v", err)
+	}
+	if _, err := db.Get("k1"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	_ = db.Close()
+
+	db, err = Open(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if _, err := db.Get("k1"); err != ErrNotFound {
+		t.Errorf("deleted key came back after reopen: %v", err)
+	}
+	if v, err := db.Get("k2"); err != nil || v != "v2" {
+		t.Errorf("Get(k2) = %q, %v", v, err)
+	}
+}
@@ -0,0 +1,67 @@
+package dbclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	OpGet    = "get"
+	OpPut    = "put"
+	OpDelete = "delete"
+)
+
+// Op - одна операція пакета.
+type Op struct {
+	Type   string `json:"op"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+}
+
+// Result - результат операції пакета з тим самим індексом; Value і Version заповнюються лише для get.
+type Result struct {
+	Value   string
+	Version uint64
+	Err     error
+}
+
+// Batch надсилає операції одним запитом /db-batch. Усі put і delete записуються атомарно: якщо одна з
+// них неможлива, її Err пояснює причину, а Err решти записів - ErrAborted. Get виконуються після
+// запису і бачать зміни пакета. Сама Batch повертає помилку, лише якщо запит не вдався.
+func (c *Client) Batch(ctx context.Context, ops []Op) ([]Result, error) {
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	_, data, err := c.do(ctx, http.MethodPost, "/db-batch", header, body)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Results []struct {
+			Status  int    `json:"status"`
+			Value   string `json:"value"`
+			Version uint64 `json:"version"`
+			Code    string `json:"code"`
+			Error   string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Results) != len(ops) {
+		return nil, fmt.Errorf("dbclient: expected %d batch results, got %d", len(ops), len(resp.Results))
+	}
+	results := make([]Result, len(ops))
+	for i, r := range resp.Results {
+		results[i] = Result{Value: r.Value, Version: r.Version}
+		if r.Status >= 300 {
+			results[i].Err = &StatusError{Code: r.Status, Reason: r.Code, Message: r.Error}
+		}
+	}
+	return results, nil
+}
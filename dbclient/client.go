@@ -0,0 +1,332 @@
+// Package dbclient - клієнт HTTP API сервісу cmd/db.
+package dbclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultBucket = "default"
+
+const (
+	DefaultMaxAttempts  = 3
+	DefaultBackoff      = 100 * time.Millisecond
+	DefaultMaxBackoff   = 2 * time.Second
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxIdleConns = 32
+
+	// maxErrorBody обмежує, скільки тексту помилки читається з відповіді
+	maxErrorBody = 4096
+
+	reasonCorrupted = "data_corrupted"
+)
+
+var (
+	ErrNotFound        = errors.New("dbclient: key not found")
+	ErrVersionMismatch = errors.New("dbclient: version does not match")
+	ErrTooLarge        = errors.New("dbclient: value is too large")
+	ErrUnavailable     = errors.New("dbclient: db is unavailable")
+	// ErrAborted - запис пакета скасовано через помилку іншої операції того ж пакета
+	ErrAborted = errors.New("dbclient: batch aborted")
+	// ErrUnauthorized - токен не задано або він невірний; ErrForbidden - токен не має прав на ключ
+	ErrUnauthorized = errors.New("dbclient: invalid or missing token")
+	ErrForbidden    = errors.New("dbclient: access denied")
+	// ErrRateLimited - перевищено частоту записів; клієнт повторює запит після Retry-After
+	ErrRateLimited = errors.New("dbclient: rate limit exceeded")
+	// ErrQuotaExceeded - бакет досяг квоти; повтор не допоможе, доки місце не звільнять
+	ErrQuotaExceeded = errors.New("dbclient: bucket quota exceeded")
+	// ErrCorrupted - збережене значення не пройшло перевірку цілісності на сервері
+	ErrCorrupted = errors.New("dbclient: stored value is corrupted")
+)
+
+// StatusError - відповідь з неочікуваним статусом. errors.Is зіставляє її з ErrNotFound,
+// ErrVersionMismatch, ErrTooLarge, ErrUnavailable, ErrAborted, ErrUnauthorized, ErrForbidden, ErrRateLimited
+// та ErrQuotaExceeded за кодом, а з ErrCorrupted - за Reason.
+type StatusError struct {
+	Code int
+	// Reason - поле code з тіла помилки, наприклад "data_corrupted"
+	Reason  string
+	Message string
+	// RetryAfter - пауза із заголовка Retry-After, якщо сервер її вказав
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("dbclient: %d %s", e.Code, http.StatusText(e.Code))
+	}
+	return fmt.Sprintf("dbclient: %d %s", e.Code, e.Message)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Code == http.StatusNotFound
+	case ErrVersionMismatch:
+		return e.Code == http.StatusPreconditionFailed
+	case ErrTooLarge:
+		return e.Code == http.StatusRequestEntityTooLarge
+	case ErrUnavailable:
+		return e.Code == http.StatusServiceUnavailable
+	case ErrAborted:
+		return e.Code == http.StatusFailedDependency
+	case ErrUnauthorized:
+		return e.Code == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.Code == http.StatusForbidden
+	case ErrRateLimited:
+		return e.Code == http.StatusTooManyRequests
+	case ErrQuotaExceeded:
+		return e.Code == http.StatusInsufficientStorage
+	case ErrCorrupted:
+		return e.Reason == reasonCorrupted
+	}
+	return false
+}
+
+type Options struct {
+	// HTTPClient замінює клієнт за замовчуванням; тоді MaxIdleConns не використовується.
+	HTTPClient *http.Client
+	// MaxIdleConns - скільки з'єднань з db тримається відкритими між запитами.
+	MaxIdleConns int
+	// Timeout обмежує одну спробу запиту; Watch не обмежується.
+	Timeout time.Duration
+	// MaxAttempts - кількість спроб при мережевих помилках, відповідях 5xx і 429; 1 вимикає повтори.
+	MaxAttempts int
+	// Backoff - пауза перед другою спробою; далі вона подвоюється до MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Token надсилається в заголовку Authorization: Bearer, якщо db вимагає автентифікації.
+	Token string
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultMaxAttempts
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = DefaultBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = DefaultMaxBackoff
+	}
+	return o
+}
+
+// Client безпечний для одночасного використання з кількох горутин.
+type Client struct {
+	base string
+	http *http.Client
+	opts Options
+}
+
+// New створює клієнт для db за адресою baseURL, наприклад http://db:8081.
+func New(baseURL string, opts Options) *Client {
+	opts = opts.withDefaults()
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = opts.MaxIdleConns
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConns
+		httpClient = &http.Client{Transport: transport}
+	}
+	return &Client{base: strings.TrimSuffix(baseURL, "/"), http: httpClient, opts: opts}
+}
+
+// Item - значення ключа разом з його версією (номером послідовності запису).
+type Item struct {
+	Bucket  string `json:"bucket"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Version uint64 `json:"version"`
+}
+
+func keyPath(bucket, key string) string {
+	return "/db/" + url.PathEscape(bucket) + "/" + url.PathEscape(key)
+}
+
+func (c *Client) Get(ctx context.Context, bucket, key string) (Item, error) {
+	var item Item
+	_, body, err := c.do(ctx, http.MethodGet, keyPath(bucket, key), nil, nil)
+	if err != nil {
+		return item, err
+	}
+	err = json.Unmarshal(body, &item)
+	return item, err
+}
+
+func (c *Client) Put(ctx context.Context, bucket, key, value string) error {
+	return c.put(ctx, bucket, key, value, nil)
+}
+
+// CompareAndSwap записує значення, лише якщо поточна версія ключа дорівнює version; version 0
+// означає, що ключа ще не має бути. Інакше повертає помилку, для якої errors.Is(err, ErrVersionMismatch).
+// Повтор після втраченої відповіді на успішний запис теж поверне ErrVersionMismatch.
+func (c *Client) CompareAndSwap(ctx context.Context, bucket, key string, version uint64, value string) error {
+	header := http.Header{}
+	if version == 0 {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", strconv.Quote(strconv.FormatUint(version, 10)))
+	}
+	return c.put(ctx, bucket, key, value, header)
+}
+
+func (c *Client) put(ctx context.Context, bucket, key, value string, header http.Header) error {
+	body, err := json.Marshal(map[string]string{"value": value})
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/json")
+	_, _, err = c.do(ctx, http.MethodPost, keyPath(bucket, key), header, body)
+	return err
+}
+
+// Delete видаляє ключ. Повтор після втраченої відповіді на успішне видалення поверне ErrNotFound.
+func (c *Client) Delete(ctx context.Context, bucket, key string) error {
+	_, _, err := c.do(ctx, http.MethodDelete, keyPath(bucket, key), nil, nil)
+	return err
+}
+
+// List повертає відсортовані ключі бакета, що починаються з prefix.
+func (c *Client) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	path := "/db/" + url.PathEscape(bucket) + "/?" + url.Values{"prefix": {prefix}}.Encode()
+	_, body, err := c.do(ctx, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Keys []string `json:"keys"`
+	}
+	err = json.Unmarshal(body, &list)
+	return list.Keys, err
+}
+
+// do виконує запит і повертає тіло відповіді. Кожна спроба обмежена Options.Timeout.
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body []byte) (int, []byte, error) {
+	return c.retry(ctx, func() (int, []byte, error) {
+		ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+		if err != nil {
+			return 0, nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := c.send(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		return resp.StatusCode, data, nil
+	})
+}
+
+// retry повторює attempt з експоненційною паузою при мережевих помилках і 5xx.
+// Відповіді зі статусом 4xx і пошкодження даних повертаються як *StatusError без повторів.
+func (c *Client) retry(ctx context.Context, attempt func() (int, []byte, error)) (int, []byte, error) {
+	backoff := c.opts.Backoff
+	for n := 1; ; n++ {
+		status, data, err := attempt()
+		if err == nil {
+			return status, data, nil
+		}
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && !retryable(statusErr) {
+			return status, nil, err
+		}
+		if ctx.Err() != nil {
+			return 0, nil, ctx.Err()
+		}
+		if n >= c.opts.MaxAttempts {
+			return 0, nil, err
+		}
+
+		// випадковий розкид не дає клієнтам повторювати запити одночасно
+		pause := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if statusErr != nil {
+			pause = max(pause, statusErr.RetryAfter)
+		}
+		select {
+		case <-time.After(pause):
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+		backoff = min(2*backoff, c.opts.MaxBackoff)
+	}
+}
+
+// retryable повідомляє, чи може повтор запиту дати інший результат. Пошкоджене значення
+// і вичерпана квота не зміняться від повтору, а 429 означає лише, що треба почекати.
+func retryable(err *StatusError) bool {
+	switch {
+	case err.Code == http.StatusTooManyRequests:
+		return true
+	case err.Code < 500, err.Code == http.StatusInsufficientStorage, err.Reason == reasonCorrupted:
+		return false
+	}
+	return true
+}
+
+// send виконує один запит; статус від 300 і вище закриває відповідь і повертається як *StatusError.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		statusErr := &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			statusErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		// db відповідає {"code": ..., "message": ...}; інші сервери можуть відповісти текстом
+		var body struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(msg, &body) == nil && body.Code != "" {
+			statusErr.Reason, statusErr.Message = body.Code, body.Message
+		}
+		return nil, statusErr
+	}
+	return resp, nil
+}
@@ -0,0 +1,327 @@
+package dbclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDb - db у пам'яті з версіями ключів і умовними записами, як у cmd/db.
+type fakeDb struct {
+	lock    sync.Mutex
+	seq     uint64
+	data    map[string]Item
+	failing atomic.Int32
+	calls   atomic.Int32
+}
+
+func startFakeDb(t *testing.T) (*fakeDb, *Client) {
+	t.Helper()
+	f := &fakeDb{data: make(map[string]Item)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, New(srv.URL, Options{Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+}
+
+func (f *fakeDb) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.calls.Add(1)
+	if f.failing.Add(-1) >= 0 {
+		http.Error(w, "datastore is closed", http.StatusServiceUnavailable)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.URL.Path == "/db-batch" {
+		f.batch(w, r)
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/db/"), "/")
+	if key == "" {
+		keys := []string{}
+		for _, item := range f.data {
+			if item.Bucket == bucket && strings.HasPrefix(item.Key, r.URL.Query().Get("prefix")) {
+				keys = append(keys, item.Key)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"bucket": bucket, "keys": keys})
+		return
+	}
+
+	id := bucket + "/" + key
+	item, exists := f.data[id]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(item)
+	case http.MethodPost:
+		if r.Header.Get("If-None-Match") == "*" && exists ||
+			r.Header.Get("If-Match") != "" && r.Header.Get("If-Match") != fmt.Sprintf("%q", fmt.Sprint(item.Version)) {
+			http.Error(w, "version does not match", http.StatusPreconditionFailed)
+			return
+		}
+		var req struct {
+			Value string `json:"value"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.seq++
+		f.data[id] = Item{Bucket: bucket, Key: key, Value: req.Value, Version: f.seq}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		delete(f.data, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// batch спрощено повторює /db-batch: записи застосовуються, лише якщо всі видалення знаходять ключ.
+func (f *fakeDb) batch(w http.ResponseWriter, r *http.Request) {
+	var ops []Op
+	_ = json.NewDecoder(r.Body).Decode(&ops)
+	results := make([]map[string]any, len(ops))
+	committed := true
+	for i, op := range ops {
+		results[i] = map[string]any{"status": http.StatusNoContent}
+		if _, ok := f.data[op.Bucket+"/"+op.Key]; op.Type == OpDelete && !ok {
+			committed = false
+			results[i] = map[string]any{"status": http.StatusNotFound, "error": "record does not exist"}
+		}
+	}
+	for i, op := range ops {
+		id := op.Bucket + "/" + op.Key
+		switch {
+		case op.Type == OpGet:
+			item, ok := f.data[id]
+			results[i] = map[string]any{"status": http.StatusOK, "value": item.Value, "version": item.Version}
+			if !ok {
+				results[i] = map[string]any{"status": http.StatusNotFound}
+			}
+		case !committed:
+			if results[i]["status"] == http.StatusNoContent {
+				results[i] = map[string]any{"status": http.StatusFailedDependency, "error": "batch aborted"}
+			}
+		case op.Type == OpPut:
+			f.seq++
+			f.data[id] = Item{Bucket: op.Bucket, Key: op.Key, Value: op.Value, Version: f.seq}
+		case op.Type == OpDelete:
+			delete(f.data, id)
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"committed": committed, "results": results})
+}
+
+func TestClient_CRUD(t *testing.T) {
+	_, c := startFakeDb(t)
+	ctx := context.Background()
+
+	_, err := c.Get(ctx, DefaultBucket, "k")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, c.Put(ctx, DefaultBucket, "k", "v"))
+	require.NoError(t, c.Put(ctx, "users", "a b/c", "alice"))
+	item, err := c.Get(ctx, DefaultBucket, "k")
+	require.NoError(t, err)
+	assert.Equal(t, "v", item.Value)
+	assert.Positive(t, item.Version)
+
+	item, err = c.Get(ctx, "users", "a b/c")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", item.Value, "keys must be escaped")
+
+	keys, err := c.List(ctx, "users", "a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a b/c"}, keys)
+
+	require.NoError(t, c.Delete(ctx, DefaultBucket, "k"))
+	assert.ErrorIs(t, c.Delete(ctx, DefaultBucket, "k"), ErrNotFound)
+}
+
+func TestClient_CompareAndSwap(t *testing.T) {
+	_, c := startFakeDb(t)
+	ctx := context.Background()
+
+	require.NoError(t, c.CompareAndSwap(ctx, DefaultBucket, "k", 0, "first"))
+	assert.ErrorIs(t, c.CompareAndSwap(ctx, DefaultBucket, "k", 0, "again"), ErrVersionMismatch)
+
+	item, err := c.Get(ctx, DefaultBucket, "k")
+	require.NoError(t, err)
+	require.NoError(t, c.CompareAndSwap(ctx, DefaultBucket, "k", item.Version, "second"))
+	assert.ErrorIs(t, c.CompareAndSwap(ctx, DefaultBucket, "k", item.Version, "stale"), ErrVersionMismatch)
+
+	item, err = c.Get(ctx, DefaultBucket, "k")
+	require.NoError(t, err)
+	assert.Equal(t, "second", item.Value)
+}
+
+func TestClient_Retry(t *testing.T) {
+	f, c := startFakeDb(t)
+	ctx := context.Background()
+
+	f.failing.Store(2)
+	require.NoError(t, c.Put(ctx, DefaultBucket, "k", "v"), "two failures fit into three attempts")
+	assert.EqualValues(t, 3, f.calls.Load())
+
+	f.calls.Store(0)
+	f.failing.Store(5)
+	err := c.Put(ctx, DefaultBucket, "k", "v")
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.EqualValues(t, 3, f.calls.Load())
+	f.failing.Store(0)
+
+	f.calls.Store(0)
+	_, err = c.Get(ctx, DefaultBucket, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.EqualValues(t, 1, f.calls.Load(), "4xx must not be retried")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprint(w, `{"code": "data_corrupted", "message": "stored value failed the integrity check"}`)
+	}))
+	defer srv.Close()
+	_, err = New(srv.URL, Options{Backoff: time.Millisecond}).Get(ctx, DefaultBucket, "k")
+	assert.ErrorIs(t, err, ErrCorrupted)
+	assert.ErrorContains(t, err, "integrity check")
+
+	down := New("http://127.0.0.1:1", Options{MaxAttempts: 2, Backoff: time.Millisecond})
+	_, err = down.Get(ctx, DefaultBucket, "k")
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestClient_Token(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	err := New(srv.URL, Options{}).Put(ctx, DefaultBucket, "k", "v")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.NoError(t, New(srv.URL, Options{Token: "secret"}).Put(ctx, DefaultBucket, "k", "v"))
+}
+
+func TestClient_Limits(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/db/default/full":
+			calls.Add(1)
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusInsufficientStorage)
+		case calls.Add(1) == 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL, Options{Backoff: time.Millisecond})
+	ctx := context.Background()
+
+	start := time.Now()
+	require.NoError(t, c.Put(ctx, DefaultBucket, "k", "v"))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "client must wait for Retry-After")
+
+	calls.Store(0)
+	err := c.Put(ctx, DefaultBucket, "full", "v")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.EqualValues(t, 1, calls.Load(), "quota errors must not be retried")
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, time.Minute, statusErr.RetryAfter)
+}
+
+func TestClient_ContextCancel(t *testing.T) {
+	f, _ := startFakeDb(t)
+	f.failing.Store(1000)
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	c := New(srv.URL, Options{MaxAttempts: 100, Backoff: 50 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.Put(ctx, DefaultBucket, "k", "v")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestClient_Batch(t *testing.T) {
+	f, c := startFakeDb(t)
+	ctx := context.Background()
+
+	results, err := c.Batch(ctx, []Op{
+		{Type: OpPut, Bucket: DefaultBucket, Key: "a", Value: "1"},
+		{Type: OpGet, Bucket: DefaultBucket, Key: "a"},
+		{Type: OpGet, Bucket: DefaultBucket, Key: "missing"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, "1", results[1].Value)
+	assert.Positive(t, results[1].Version)
+	assert.ErrorIs(t, results[2].Err, ErrNotFound)
+	assert.EqualValues(t, 1, f.calls.Load(), "batch must take a single request")
+
+	results, err = c.Batch(ctx, []Op{
+		{Type: OpPut, Bucket: DefaultBucket, Key: "b", Value: "2"},
+		{Type: OpDelete, Bucket: DefaultBucket, Key: "missing"},
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, ErrAborted)
+	assert.ErrorIs(t, results[1].Err, ErrNotFound)
+	_, err = c.Get(ctx, DefaultBucket, "b")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestClient_Watch(t *testing.T) {
+	sent := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "users", r.URL.Query().Get("bucket"))
+		assert.Equal(t, "a", r.URL.Query().Get("prefix"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "id: 1\nevent: put\ndata: {\"bucket\":\"users\",\"key\":\"alice\",\"seq\":1}\n\n")
+		_, _ = fmt.Fprint(w, "id: 3\nevent: dropped\ndata: {\"bucket\":\"users\",\"seq\":3,\"dropped\":2}\n\n")
+		w.(http.Flusher).Flush()
+		<-sent
+	}))
+	defer srv.Close()
+	defer close(sent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := New(srv.URL, Options{}).Watch(ctx, "users", "a")
+	require.NoError(t, err)
+
+	assert.Equal(t, Event{Type: "put", Bucket: "users", Key: "alice", Seq: 1}, <-events)
+	assert.Equal(t, Event{Type: "dropped", Bucket: "users", Seq: 3, Dropped: 2}, <-events)
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("events channel must be closed after cancel")
+	}
+}
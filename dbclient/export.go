@@ -0,0 +1,78 @@
+package dbclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ImportMode визначає, що робить Import з ключами, які вже є в db.
+type ImportMode string
+
+const (
+	ImportOverwrite    ImportMode = "overwrite"
+	ImportSkipExisting ImportMode = "skip"
+)
+
+// ImportResult - скільки записів Import записав і скільки пропустив у режимі ImportSkipExisting.
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// Export записує у w ключі бакета з префіксом prefix у форматі JSON Lines, по об'єкту
+// {"bucket", "key", "value", "version"} на рядок; ключ і значення, що не є UTF-8, передаються
+// в base64 з полем "encoding": "base64". Порожній bucket експортує всі бакети.
+// Повтори з Options застосовуються лише до встановлення з'єднання.
+func (c *Client) Export(ctx context.Context, bucket, prefix string, w io.Writer) error {
+	query := url.Values{}
+	if bucket != "" {
+		query.Set("bucket", bucket)
+		query.Set("prefix", prefix)
+	}
+	u := c.base + "/db-export?" + query.Encode()
+
+	var resp *http.Response
+	_, _, err := c.retry(ctx, func() (int, []byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return 0, nil, err
+		}
+		resp, err = c.send(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		return resp.StatusCode, nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("%w: export interrupted: %v", ErrUnavailable, err)
+	}
+	return nil
+}
+
+// Import надсилає JSON Lines у форматі Export; db записує їх пакетами. Тіло читається потоком,
+// тож запит не повторюється і не обмежується Options.Timeout. Якщо db зупинила імпорт на помилці,
+// попередні пакети вже записані, а повідомлення помилки вказує номер запису.
+func (c *Client) Import(ctx context.Context, r io.Reader, mode ImportMode) (ImportResult, error) {
+	var res ImportResult
+	u := c.base + "/db-import?" + url.Values{"mode": {string(mode)}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, r)
+	if err != nil {
+		return res, err
+	}
+	req.Header.Set("Content-Type", "application/jsonl")
+	resp, err := c.send(req)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	return res, err
+}
@@ -0,0 +1,81 @@
+package dbclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Event - зміна ключа з потоку /db-watch. Type - "put", "delete", "drop-bucket" або "dropped",
+// якщо клієнт не встигав читати і Dropped подій було втрачено.
+type Event struct {
+	Type    string `json:"-"`
+	Bucket  string `json:"bucket"`
+	Key     string `json:"key,omitempty"`
+	Seq     uint64 `json:"seq"`
+	Dropped uint64 `json:"dropped,omitempty"`
+}
+
+// Watch підписується на зміни ключів бакета з префіксом prefix. Канал закривається, коли ctx
+// скасовано або db розірвала з'єднання; повторне підключення - справа того, хто викликає.
+// Повтори з Options застосовуються лише до встановлення з'єднання.
+func (c *Client) Watch(ctx context.Context, bucket, prefix string) (<-chan Event, error) {
+	u := c.base + "/db-watch?" + url.Values{"bucket": {bucket}, "prefix": {prefix}}.Encode()
+
+	var resp *http.Response
+	_, _, err := c.retry(ctx, func() (int, []byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return 0, nil, err
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err = c.send(req)
+		if err != nil {
+			return 0, nil, err
+		}
+		return resp.StatusCode, nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		readEvents(ctx, resp.Body, events)
+	}()
+	return events, nil
+}
+
+// readEvents розбирає потік server-sent events; поле id не потрібне, бо Seq є в даних.
+func readEvents(ctx context.Context, r io.Reader, events chan<- Event) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	var typ, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			typ = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		case line == "" && data != "":
+			ev := Event{Type: typ}
+			err := json.Unmarshal([]byte(data), &ev)
+			typ, data = "", ""
+			if err != nil {
+				continue
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -2,7 +2,10 @@ module github.com/bohdanbulakh/kpi-lab5
 
 go 1.24
 
-require github.com/stretchr/testify v1.10.0
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/stretchr/testify v1.10.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -0,0 +1,421 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inmemNetwork доставляє RPC прямими викликами і вміє ізолювати вузли.
+type inmemNetwork struct {
+	lock     sync.Mutex
+	nodes    map[string]*Node
+	isolated map[string]bool
+}
+
+func newInmemNetwork() *inmemNetwork {
+	return &inmemNetwork{nodes: make(map[string]*Node), isolated: make(map[string]bool)}
+}
+
+func (net *inmemNetwork) target(from, to string) (*Node, error) {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	n, ok := net.nodes[to]
+	if !ok || net.isolated[from] || net.isolated[to] {
+		return nil, fmt.Errorf("%s is unreachable from %s", to, from)
+	}
+	return n, nil
+}
+
+func (net *inmemNetwork) setIsolated(id string, isolated bool) {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	net.isolated[id] = isolated
+}
+
+type inmemTransport struct {
+	net  *inmemNetwork
+	from string
+}
+
+func (t inmemTransport) RequestVote(ctx context.Context, target string, req RequestVoteRequest) (RequestVoteResponse, error) {
+	n, err := t.net.target(t.from, target)
+	if err != nil {
+		return RequestVoteResponse{}, err
+	}
+	return n.HandleRequestVote(req), nil
+}
+
+func (t inmemTransport) AppendEntries(ctx context.Context, target string, req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	n, err := t.net.target(t.from, target)
+	if err != nil {
+		return AppendEntriesResponse{}, err
+	}
+	return n.HandleAppendEntries(req), nil
+}
+
+func (t inmemTransport) InstallSnapshot(ctx context.Context, target string, req InstallSnapshotRequest) (InstallSnapshotResponse, error) {
+	n, err := t.net.target(t.from, target)
+	if err != nil {
+		return InstallSnapshotResponse{}, err
+	}
+	return n.HandleInstallSnapshot(req)
+}
+
+// kvMachine - автомат з командами виду key=value. Поки failures > 0, Apply імітує збій диска.
+type kvMachine struct {
+	lock     sync.Mutex
+	data     map[string]string
+	failures int
+}
+
+func newKVMachine() *kvMachine {
+	return &kvMachine{data: make(map[string]string)}
+}
+
+func (m *kvMachine) Apply(e Entry) (error, error) {
+	key, value, ok := strings.Cut(string(e.Data), "=")
+	if !ok {
+		return fmt.Errorf("bad command %q", e.Data), nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.failures > 0 {
+		m.failures--
+		return nil, errors.New("disk failure")
+	}
+	m.data[key] = value
+	return nil, nil
+}
+
+func (m *kvMachine) Snapshot(w io.Writer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return json.NewEncoder(w).Encode(m.data)
+}
+
+func (m *kvMachine) Restore(r io.Reader) error {
+	data := make(map[string]string)
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.data = data
+	return nil
+}
+
+func (m *kvMachine) get(key string) (string, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok := m.data[key]
+	return v, ok
+}
+
+type testNode struct {
+	node    *Node
+	sm      *kvMachine
+	storage *FileStorage
+	dir     string
+}
+
+// testCluster - кластер у межах одного процесу з файловими сховищами у тимчасових теках.
+type testCluster struct {
+	t         *testing.T
+	net       *inmemNetwork
+	threshold uint64
+	nodes     map[string]*testNode
+}
+
+func newTestCluster(t *testing.T, size int, threshold uint64) *testCluster {
+	c := &testCluster{t: t, net: newInmemNetwork(), threshold: threshold, nodes: make(map[string]*testNode)}
+	var members []string
+	for i := 1; i <= size; i++ {
+		members = append(members, fmt.Sprintf("node-%d", i))
+	}
+	for _, id := range members {
+		c.start(id, t.TempDir(), members)
+	}
+	t.Cleanup(func() {
+		for id := range c.nodes {
+			c.stop(id)
+		}
+	})
+	return c
+}
+
+func (c *testCluster) start(id, dir string, members []string) *testNode {
+	c.t.Helper()
+	storage, err := NewFileStorage(dir)
+	require.NoError(c.t, err)
+	sm := newKVMachine()
+	node, err := New(Config{
+		ID:                id,
+		Members:           members,
+		ElectionTimeout:   100 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+		SnapshotThreshold: c.threshold,
+		Storage:           storage,
+		Transport:         inmemTransport{net: c.net, from: id},
+		StateMachine:      sm,
+	})
+	require.NoError(c.t, err)
+
+	tn := &testNode{node: node, sm: sm, storage: storage, dir: dir}
+	c.net.lock.Lock()
+	c.net.nodes[id] = node
+	c.net.lock.Unlock()
+	c.nodes[id] = tn
+	return tn
+}
+
+func (c *testCluster) stop(id string) {
+	tn := c.nodes[id]
+	c.net.lock.Lock()
+	delete(c.net.nodes, id)
+	c.net.lock.Unlock()
+	tn.node.Shutdown()
+	_ = tn.storage.Close()
+	delete(c.nodes, id)
+}
+
+// leader чекає, доки серед неізольованих вузлів з'явиться рівно один лідер останнього терму.
+func (c *testCluster) leader(except ...string) *Node {
+	c.t.Helper()
+	var leader *Node
+	require.Eventually(c.t, func() bool {
+		leader = nil
+		var term uint64
+		for id, tn := range c.nodes {
+			if contains(except, id) {
+				continue
+			}
+			st := tn.node.Status()
+			if st.State != Leader.String() || st.Term < term {
+				continue
+			}
+			if st.Term == term && leader != nil {
+				return false
+			}
+			leader, term = tn.node, st.Term
+		}
+		return leader != nil
+	}, 5*time.Second, 10*time.Millisecond, "no leader elected")
+	return leader
+}
+
+func (c *testCluster) propose(leader *Node, key, value string) {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(c.t, leader.Propose(ctx, []byte(key+"="+value)))
+}
+
+func (c *testCluster) waitValue(id, key, value string) {
+	c.t.Helper()
+	require.Eventually(c.t, func() bool {
+		v, ok := c.nodes[id].sm.get(key)
+		return ok && v == value
+	}, 5*time.Second, 10*time.Millisecond, "%s did not apply %s=%s", id, key, value)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCluster_SingleNode(t *testing.T) {
+	c := newTestCluster(t, 1, 0)
+	leader := c.leader()
+	c.propose(leader, "k", "v")
+	v, _ := c.nodes["node-1"].sm.get("k")
+	assert.Equal(t, "v", v)
+}
+
+func TestCluster_Replication(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	leader := c.leader()
+	c.propose(leader, "k1", "v1")
+	c.propose(leader, "k2", "v2")
+	for id := range c.nodes {
+		c.waitValue(id, "k2", "v2")
+	}
+
+	for id, tn := range c.nodes {
+		if id == leader.ID() {
+			continue
+		}
+		err := tn.node.Propose(context.Background(), []byte("k=v"))
+		var notLeader *NotLeaderError
+		require.True(t, errors.As(err, &notLeader), "expected NotLeaderError, got %v", err)
+		assert.Equal(t, leader.ID(), notLeader.Leader)
+		assert.ErrorIs(t, err, ErrNotLeader)
+	}
+}
+
+func TestCluster_LeaderFailover(t *testing.T) {
+	c := newTestCluster(t, 3, 0)
+	old := c.leader()
+	c.propose(old, "k", "v1")
+
+	c.net.setIsolated(old.ID(), true)
+	leader := c.leader(old.ID())
+	require.NotEqual(t, old.ID(), leader.ID())
+	c.propose(leader, "k", "v2")
+
+	// ізольований лідер не збере більшості
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.Error(t, old.Propose(ctx, []byte("k=lost")))
+
+	c.net.setIsolated(old.ID(), false)
+	c.waitValue(old.ID(), "k", "v2")
+	require.Eventually(t, func() bool {
+		return old.Status().State == Follower.String()
+	}, 5*time.Second, 10*time.Millisecond)
+	v, _ := c.nodes[old.ID()].sm.get("k")
+	assert.Equal(t, "v2", v, "uncommitted entry of the old leader must be discarded")
+}
+
+func TestCluster_SnapshotCatchUp(t *testing.T) {
+	c := newTestCluster(t, 3, 5)
+	leader := c.leader()
+	var lagging string
+	for id := range c.nodes {
+		if id != leader.ID() {
+			lagging = id
+			break
+		}
+	}
+
+	c.net.setIsolated(lagging, true)
+	for i := 0; i < 20; i++ {
+		c.propose(leader, fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+	require.Eventually(t, func() bool {
+		return leader.Status().SnapshotIndex > 0
+	}, 5*time.Second, 10*time.Millisecond, "leader did not compact its log")
+
+	c.net.setIsolated(lagging, false)
+	c.waitValue(lagging, "k19", "v19")
+	for i := 0; i < 20; i++ {
+		v, _ := c.nodes[lagging].sm.get(fmt.Sprintf("k%d", i))
+		assert.Equal(t, fmt.Sprintf("v%d", i), v)
+	}
+	assert.Positive(t, c.nodes[lagging].node.Status().SnapshotIndex)
+}
+
+func TestCluster_Membership(t *testing.T) {
+	c := newTestCluster(t, 3, 5)
+	leader := c.leader()
+	for i := 0; i < 10; i++ {
+		c.propose(leader, fmt.Sprintf("k%d", i), "v")
+	}
+
+	c.start("node-4", t.TempDir(), nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, leader.AddMember(ctx, "node-4"))
+	c.propose(leader, "after-join", "v")
+	c.waitValue("node-4", "after-join", "v")
+	c.waitValue("node-4", "k0", "v")
+	assert.Len(t, c.nodes["node-4"].node.Status().Members, 4)
+
+	var removed string
+	for id := range c.nodes {
+		if id != leader.ID() && id != "node-4" {
+			removed = id
+			break
+		}
+	}
+	require.NoError(t, leader.RemoveMember(ctx, removed))
+	assert.NotContains(t, leader.Status().Members, removed)
+	c.stop(removed)
+	c.propose(leader, "after-remove", "v")
+	c.waitValue("node-4", "after-remove", "v")
+
+	// лідер, що вилучив себе, передає керування решті кластера
+	require.NoError(t, leader.RemoveMember(ctx, leader.ID()))
+	next := c.leader(leader.ID())
+	assert.NotEqual(t, leader.ID(), next.ID())
+	c.propose(next, "after-leader-removal", "v")
+}
+
+func TestCluster_ApplyFailure(t *testing.T) {
+	c := newTestCluster(t, 3, 3)
+	leader := c.leader()
+	var failing *testNode
+	for id, tn := range c.nodes {
+		if id != leader.ID() {
+			failing = tn
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := leader.Propose(ctx, []byte("bad"))
+	assert.ErrorContains(t, err, "bad command", "rejection must reach the proposer")
+
+	failing.sm.lock.Lock()
+	failing.sm.failures = 5
+	failing.sm.lock.Unlock()
+	for i := 0; i < 10; i++ {
+		c.propose(leader, fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+
+	// запис, на якому автомат збоїть, не пропускається: вузол повторює його і догоняє решту
+	id := failing.node.ID()
+	c.waitValue(id, "k9", "v9")
+	for i := 0; i < 10; i++ {
+		v, _ := failing.sm.get(fmt.Sprintf("k%d", i))
+		assert.Equal(t, fmt.Sprintf("v%d", i), v)
+	}
+	require.Eventually(t, func() bool {
+		return failing.node.Status().LastApplied == leader.Status().LastApplied
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCluster_Restart(t *testing.T) {
+	c := newTestCluster(t, 3, 4)
+	leader := c.leader()
+	for i := 0; i < 10; i++ {
+		c.propose(leader, fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+	for id := range c.nodes {
+		c.waitValue(id, "k9", "v9")
+	}
+
+	dirs := make(map[string]string)
+	for id, tn := range c.nodes {
+		dirs[id] = tn.dir
+	}
+	members := leader.Status().Members
+	for id := range dirs {
+		c.stop(id)
+	}
+	for id, dir := range dirs {
+		c.start(id, dir, members)
+	}
+
+	leader = c.leader()
+	c.propose(leader, "after-restart", "v")
+	for id := range c.nodes {
+		c.waitValue(id, "after-restart", "v")
+		c.waitValue(id, "k9", "v9")
+		c.waitValue(id, "k3", "v3")
+	}
+}
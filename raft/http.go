@@ -0,0 +1,100 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	voteRPCPath     = "/raft/vote"
+	appendRPCPath   = "/raft/append"
+	snapshotRPCPath = "/raft/install-snapshot"
+)
+
+// HTTPTransport надсилає RPC як POST з JSON на адресу вузла; ID вузлів - їхні базові URL.
+type HTTPTransport struct {
+	client *http.Client
+}
+
+func NewHTTPTransport(client *http.Client) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPTransport{client: client}
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, target string, req RequestVoteRequest) (RequestVoteResponse, error) {
+	var resp RequestVoteResponse
+	err := t.call(ctx, target+voteRPCPath, req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, target string, req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	var resp AppendEntriesResponse
+	err := t.call(ctx, target+appendRPCPath, req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, target string, req InstallSnapshotRequest) (InstallSnapshotResponse, error) {
+	var resp InstallSnapshotResponse
+	err := t.call(ctx, target+snapshotRPCPath, req, &resp)
+	return resp, err
+}
+
+func (t *HTTPTransport) call(ctx context.Context, url string, req, resp any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := t.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", url, httpResp.Status)
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// Handler обслуговує RPC, які надсилає HTTPTransport інших вузлів.
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(voteRPCPath, rpcHandler(func(req RequestVoteRequest) (RequestVoteResponse, error) {
+		return n.HandleRequestVote(req), nil
+	}))
+	mux.HandleFunc(appendRPCPath, rpcHandler(func(req AppendEntriesRequest) (AppendEntriesResponse, error) {
+		return n.HandleAppendEntries(req), nil
+	}))
+	mux.HandleFunc(snapshotRPCPath, rpcHandler(n.HandleInstallSnapshot))
+	return mux
+}
+
+func rpcHandler[Req, Resp any](handle func(Req) (Resp, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req Req
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+		resp, err := handle(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,724 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultElectionTimeout   = 300 * time.Millisecond
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+	DefaultSnapshotThreshold = 1024
+	DefaultMaxAppendEntries  = 64
+)
+
+var (
+	ErrNotLeader          = errors.New("not the leader")
+	ErrShutdown           = errors.New("raft node is shut down")
+	ErrLeadershipLost     = errors.New("leadership lost before the entry was committed")
+	ErrMembershipChanging = errors.New("another membership change is in progress")
+)
+
+// NotLeaderError повертається з Propose на вузлі, що не є лідером; Leader - відомий лідер або "".
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "not the leader, leader is unknown"
+	}
+	return fmt.Sprintf("not the leader, leader is %s", e.Leader)
+}
+
+func (e *NotLeaderError) Is(target error) bool {
+	return target == ErrNotLeader
+}
+
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return "unknown"
+}
+
+// StateMachine отримує закомічені команди у порядку журналу.
+type StateMachine interface {
+	// Apply застосовує команду. rejected - детермінована відмова, однакова на всіх вузлах: запис
+	// вважається застосованим, а відмова повертається тому, хто викликав Propose. err - збій автомата
+	// (наприклад, помилка диска): запис не вважається застосованим, і вузол повторює його пізніше.
+	Apply(entry Entry) (rejected, err error)
+	// Snapshot записує повний стан автомата; після нього журнал до цього запису відкидається.
+	Snapshot(w io.Writer) error
+	// Restore замінює стан автомата знімком, записаним Snapshot.
+	Restore(r io.Reader) error
+}
+
+type Config struct {
+	// ID - адреса вузла, за якою його знаходить Transport.
+	ID string
+	// Members - початковий склад кластера. Вузол, що приєднується до існуючого кластера,
+	// стартує з порожнім складом і чекає, доки лідер не додасть його через AddMember.
+	Members           []string
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	// SnapshotThreshold - скільки застосованих записів накопичується до наступного знімка.
+	SnapshotThreshold uint64
+	MaxAppendEntries  int
+	Storage           Storage
+	Transport         Transport
+	StateMachine      StateMachine
+	Logger            *slog.Logger
+}
+
+func (c Config) withDefaults() (Config, error) {
+	if c.ID == "" || c.Storage == nil || c.Transport == nil || c.StateMachine == nil {
+		return c, fmt.Errorf("raft: ID, Storage, Transport and StateMachine are required")
+	}
+	if c.ElectionTimeout == 0 {
+		c.ElectionTimeout = DefaultElectionTimeout
+	}
+	if c.HeartbeatInterval == 0 {
+		c.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if c.HeartbeatInterval >= c.ElectionTimeout {
+		return c, fmt.Errorf("raft: heartbeat interval must be shorter than election timeout")
+	}
+	if c.SnapshotThreshold == 0 {
+		c.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+	if c.MaxAppendEntries == 0 {
+		c.MaxAppendEntries = DefaultMaxAppendEntries
+	}
+	if c.Logger == nil {
+		c.Logger = slog.New(slog.DiscardHandler)
+	}
+	return c, nil
+}
+
+type Status struct {
+	ID            string   `json:"id"`
+	State         string   `json:"state"`
+	Term          uint64   `json:"term"`
+	Leader        string   `json:"leader"`
+	Members       []string `json:"members"`
+	CommitIndex   uint64   `json:"commit_index"`
+	LastApplied   uint64   `json:"last_applied"`
+	LastIndex     uint64   `json:"last_index"`
+	SnapshotIndex uint64   `json:"snapshot_index"`
+}
+
+type waiter struct {
+	term uint64
+	ch   chan error
+}
+
+type peer struct {
+	id      string
+	next    uint64
+	match   uint64
+	trigger chan struct{}
+	stop    chan struct{}
+}
+
+// Node - учасник кластера Raft. Записи приймає лише лідер; закомічені записи
+// кожен вузол застосовує до свого StateMachine.
+type Node struct {
+	cfg Config
+	log *slog.Logger
+
+	mu    sync.Mutex
+	state State
+	term  uint64
+	vote  string
+	// leader - відомий лідер поточного терму
+	leader      string
+	lastContact time.Time
+	// entries[0] - заглушка з індексом і термом останнього знімка
+	entries          []Entry
+	snapMembers      []string
+	members          []string
+	commitIndex      uint64
+	lastApplied      uint64
+	electionDeadline time.Time
+	peers            map[string]*peer
+	waiters          map[uint64]waiter
+	stopped          bool
+
+	// applyMu не дає застосовувати записи, поки автомат знімається чи відновлюється зі знімка
+	applyMu     sync.Mutex
+	applyNotify chan struct{}
+	shutdown    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// New відновлює стан вузла зі сховища і запускає його.
+func New(cfg Config) (*Node, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		cfg:         cfg,
+		log:         cfg.Logger.With("node", cfg.ID),
+		peers:       make(map[string]*peer),
+		waiters:     make(map[uint64]waiter),
+		applyNotify: make(chan struct{}, 1),
+		shutdown:    make(chan struct{}),
+		snapMembers: slices.Clone(cfg.Members),
+	}
+
+	hs, err := cfg.Storage.State()
+	if err != nil {
+		return nil, err
+	}
+	n.term, n.vote = hs.Term, hs.VotedFor
+
+	sentinel := Entry{}
+	meta, data, err := cfg.Storage.Snapshot()
+	switch {
+	case err == nil:
+		err = cfg.StateMachine.Restore(data)
+		data.Close()
+		if err != nil {
+			return nil, fmt.Errorf("raft: restore snapshot: %w", err)
+		}
+		sentinel = Entry{Index: meta.Index, Term: meta.Term}
+		n.snapMembers = meta.Members
+		n.commitIndex, n.lastApplied = meta.Index, meta.Index
+	case !errors.Is(err, ErrNoSnapshot):
+		return nil, err
+	}
+
+	entries, err := cfg.Storage.Entries()
+	if err != nil {
+		return nil, err
+	}
+	n.entries = append([]Entry{sentinel}, entries...)
+	n.refreshMembers()
+	n.resetElectionTimer()
+
+	n.wg.Add(2)
+	go n.ticker()
+	go n.applier()
+	n.log.Info("raft node started", "term", n.term, "last_index", n.lastIndex(), "members", n.members)
+	return n, nil
+}
+
+// Shutdown зупиняє фонові горутини; сховище закриває власник.
+func (n *Node) Shutdown() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.shutdown)
+	n.stopPeers()
+	n.failWaiters(0, ErrShutdown)
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+func (n *Node) ID() string {
+	return n.cfg.ID
+}
+
+// Leader повертає відомого лідера або "", якщо його ще не обрано.
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:            n.cfg.ID,
+		State:         n.state.String(),
+		Term:          n.term,
+		Leader:        n.leader,
+		Members:       slices.Clone(n.members),
+		CommitIndex:   n.commitIndex,
+		LastApplied:   n.lastApplied,
+		LastIndex:     n.lastIndex(),
+		SnapshotIndex: n.snapIndex(),
+	}
+}
+
+// Propose додає команду до журналу і чекає, доки більшість вузлів її збереже, а цей вузол
+// застосує. Якщо ctx завершився раніше, запис все одно може бути закомічений.
+func (n *Node) Propose(ctx context.Context, data []byte) error {
+	return n.propose(ctx, Entry{Type: EntryCommand, Data: data})
+}
+
+// AddMember додає вузол до кластера. Одночасно можлива лише одна зміна складу.
+func (n *Node) AddMember(ctx context.Context, id string) error {
+	return n.changeMembers(ctx, func(members []string) []string {
+		if slices.Contains(members, id) {
+			return nil
+		}
+		return append(slices.Clone(members), id)
+	})
+}
+
+// RemoveMember вилучає вузол з кластера. Лідер, що вилучив сам себе, складає повноваження
+// після коміту нового складу.
+func (n *Node) RemoveMember(ctx context.Context, id string) error {
+	return n.changeMembers(ctx, func(members []string) []string {
+		if !slices.Contains(members, id) {
+			return nil
+		}
+		return slices.DeleteFunc(slices.Clone(members), func(m string) bool { return m == id })
+	})
+}
+
+func (n *Node) changeMembers(ctx context.Context, change func([]string) []string) error {
+	n.mu.Lock()
+	if n.configIndex() > n.commitIndex {
+		n.mu.Unlock()
+		return ErrMembershipChanging
+	}
+	members := change(n.members)
+	n.mu.Unlock()
+	if members == nil {
+		return nil
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return err
+	}
+	return n.propose(ctx, Entry{Type: EntryConfig, Data: data})
+}
+
+func (n *Node) propose(ctx context.Context, e Entry) error {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return ErrShutdown
+	}
+	if n.state != Leader {
+		leader := n.leader
+		n.mu.Unlock()
+		return &NotLeaderError{Leader: leader}
+	}
+	if err := n.appendLocal(e); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	index := n.lastIndex()
+	ch := make(chan error, 1)
+	n.waiters[index] = waiter{term: n.term, ch: ch}
+	n.triggerPeers()
+	n.advanceCommit()
+	n.mu.Unlock()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Далі методи з утриманим mu.
+
+func (n *Node) snapIndex() uint64 {
+	return n.entries[0].Index
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.entries[len(n.entries)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.entries[len(n.entries)-1].Term
+}
+
+// termAt повертає терм запису; false, якщо запису немає в журналі чи він уже у знімку.
+func (n *Node) termAt(index uint64) (uint64, bool) {
+	if index < n.snapIndex() || index > n.lastIndex() {
+		return 0, false
+	}
+	return n.entries[index-n.snapIndex()].Term, true
+}
+
+func (n *Node) slice(from, to uint64) []Entry {
+	return slices.Clone(n.entries[from-n.snapIndex() : to-n.snapIndex()+1])
+}
+
+func (n *Node) configIndex() uint64 {
+	for i := len(n.entries) - 1; i > 0; i-- {
+		if n.entries[i].Type == EntryConfig {
+			return n.entries[i].Index
+		}
+	}
+	return 0
+}
+
+// membersAt повертає склад кластера, чинний на записі index: склад набуває сили,
+// щойно його запис з'являється в журналі, ще до коміту.
+func (n *Node) membersAt(index uint64) []string {
+	for i := len(n.entries) - 1; i > 0; i-- {
+		e := n.entries[i]
+		if e.Index > index || e.Type != EntryConfig {
+			continue
+		}
+		var members []string
+		if err := json.Unmarshal(e.Data, &members); err == nil {
+			return members
+		}
+	}
+	return slices.Clone(n.snapMembers)
+}
+
+func (n *Node) refreshMembers() {
+	n.members = n.membersAt(n.lastIndex())
+	if n.state == Leader {
+		n.updatePeers()
+	}
+}
+
+func (n *Node) isMember(id string) bool {
+	return slices.Contains(n.members, id)
+}
+
+func (n *Node) quorum() int {
+	return len(n.members)/2 + 1
+}
+
+func (n *Node) resetElectionTimer() {
+	timeout := n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+func (n *Node) persistState() error {
+	err := n.cfg.Storage.SetState(HardState{Term: n.term, VotedFor: n.vote})
+	if err != nil {
+		n.log.Error("failed to persist raft state", "err", err)
+	}
+	return err
+}
+
+// appendLocal призначає запису індекс і терм, зберігає його і одразу враховує зміну складу.
+func (n *Node) appendLocal(e Entry) error {
+	e.Index, e.Term = n.lastIndex()+1, n.term
+	if err := n.cfg.Storage.Append([]Entry{e}); err != nil {
+		return err
+	}
+	n.entries = append(n.entries, e)
+	if e.Type == EntryConfig {
+		n.refreshMembers()
+	}
+	return nil
+}
+
+func (n *Node) becomeFollower(term uint64) {
+	if term > n.term {
+		n.term, n.vote = term, ""
+		_ = n.persistState()
+		n.leader = ""
+	}
+	if n.state == Leader {
+		n.log.Info("stepping down", "term", n.term)
+		n.stopPeers()
+		// закомічені записи ще застосує applier, тож їхніх очікувачів не чіпаємо
+		n.failWaiters(n.commitIndex+1, ErrLeadershipLost)
+	}
+	n.state = Follower
+}
+
+func (n *Node) becomeLeader() {
+	n.state = Leader
+	n.leader = n.cfg.ID
+	n.log.Info("became leader", "term", n.term, "members", n.members)
+	n.updatePeers()
+	// порожній запис поточного терму дозволяє закомітити записи попередніх термів
+	if err := n.appendLocal(Entry{Type: EntryNoop}); err != nil {
+		n.log.Error("failed to append noop entry", "err", err)
+	}
+	n.triggerPeers()
+	n.advanceCommit()
+}
+
+func (n *Node) startElection() {
+	n.state = Candidate
+	n.term++
+	n.vote = n.cfg.ID
+	n.leader = ""
+	n.resetElectionTimer()
+	if err := n.persistState(); err != nil {
+		return
+	}
+	term := n.term
+	n.log.Debug("starting election", "term", term)
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	req := RequestVoteRequest{Term: term, CandidateID: n.cfg.ID, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm()}
+	for _, id := range n.members {
+		if id == n.cfg.ID {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+			defer cancel()
+			resp, err := n.cfg.Transport.RequestVote(ctx, id, req)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.term {
+				n.becomeFollower(resp.Term)
+				return
+			}
+			if n.state != Candidate || n.term != term || !resp.VoteGranted {
+				return
+			}
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}()
+	}
+}
+
+// advanceCommit комітить найбільший індекс поточного терму, збережений більшістю складу.
+func (n *Node) advanceCommit() {
+	if n.state != Leader {
+		return
+	}
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if term, _ := n.termAt(index); term != n.term {
+			break
+		}
+		count := 0
+		for _, id := range n.members {
+			if id == n.cfg.ID || (n.peers[id] != nil && n.peers[id].match >= index) {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.setCommitIndex(index)
+			break
+		}
+	}
+	if !n.isMember(n.cfg.ID) && n.commitIndex >= n.configIndex() {
+		n.log.Info("removed from the cluster")
+		n.becomeFollower(n.term)
+		n.leader = ""
+	}
+}
+
+func (n *Node) setCommitIndex(index uint64) {
+	if index <= n.commitIndex {
+		return
+	}
+	n.commitIndex = index
+	select {
+	case n.applyNotify <- struct{}{}:
+	default:
+	}
+}
+
+// failWaiters завершує очікування записів з індексом від from.
+func (n *Node) failWaiters(from uint64, err error) {
+	for index, w := range n.waiters {
+		if index >= from {
+			w.ch <- err
+			delete(n.waiters, index)
+		}
+	}
+}
+
+func (n *Node) ticker() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.shutdown:
+			return
+		case <-ticker.C:
+		}
+		n.mu.Lock()
+		if n.state != Leader && n.isMember(n.cfg.ID) && time.Now().After(n.electionDeadline) {
+			n.startElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// applier застосовує закомічені записи. Після збою автомата застосування повторюється через
+// HeartbeatInterval, а знімок не робиться, доки запис не застосовано.
+func (n *Node) applier() {
+	defer n.wg.Done()
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-n.shutdown:
+			return
+		case <-n.applyNotify:
+		case <-retry:
+		}
+		retry = nil
+		n.applyMu.Lock()
+		err := n.applyCommitted()
+		if err == nil {
+			n.maybeSnapshot()
+		}
+		n.applyMu.Unlock()
+		if err != nil {
+			retry = time.After(n.cfg.HeartbeatInterval)
+		}
+	}
+}
+
+// applyCommitted викликається з утриманим applyMu. Помилка - збій автомата на записі lastApplied+1.
+func (n *Node) applyCommitted() error {
+	for {
+		n.mu.Lock()
+		if n.lastApplied >= n.commitIndex || n.stopped {
+			n.mu.Unlock()
+			return nil
+		}
+		from := n.lastApplied + 1
+		to := min(n.commitIndex, from+uint64(n.cfg.MaxAppendEntries)-1)
+		batch := n.slice(from, to)
+		n.mu.Unlock()
+
+		for _, e := range batch {
+			var rejected error
+			if e.Type == EntryCommand {
+				var err error
+				if rejected, err = n.cfg.StateMachine.Apply(e); err != nil {
+					n.log.Error("apply failed, will retry", "index", e.Index, "err", err)
+					return err
+				}
+			}
+			n.mu.Lock()
+			n.lastApplied = e.Index
+			if w, ok := n.waiters[e.Index]; ok {
+				delete(n.waiters, e.Index)
+				if w.term != e.Term {
+					rejected = ErrLeadershipLost
+				}
+				w.ch <- rejected
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// maybeSnapshot знімає автомат і відкидає застосовану частину журналу; викликається з утриманим applyMu.
+func (n *Node) maybeSnapshot() {
+	n.mu.Lock()
+	if n.lastApplied-n.snapIndex() < n.cfg.SnapshotThreshold {
+		n.mu.Unlock()
+		return
+	}
+	index := n.lastApplied
+	term, _ := n.termAt(index)
+	meta := SnapshotMeta{Index: index, Term: term, Members: n.membersAt(index)}
+	n.mu.Unlock()
+
+	if err := n.writeSnapshot(meta); err != nil {
+		n.log.Error("snapshot failed", "index", index, "err", err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if index <= n.snapIndex() {
+		return
+	}
+	rest := slices.Clone(n.entries[index-n.snapIndex()+1:])
+	if err := n.cfg.Storage.Replace(rest); err != nil {
+		n.log.Error("log compaction failed", "index", index, "err", err)
+		return
+	}
+	n.entries = append([]Entry{{Index: index, Term: term}}, rest...)
+	n.snapMembers = meta.Members
+	n.log.Info("log compacted", "index", index, "entries", len(rest))
+}
+
+func (n *Node) writeSnapshot(meta SnapshotMeta) error {
+	sink, err := n.cfg.Storage.CreateSnapshot(meta)
+	if err != nil {
+		return err
+	}
+	if err := n.cfg.StateMachine.Snapshot(sink); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (n *Node) stopPeers() {
+	for id, p := range n.peers {
+		close(p.stop)
+		delete(n.peers, id)
+	}
+}
+
+// updatePeers запускає реплікацію на нових членів кластера і зупиняє на вилучених.
+func (n *Node) updatePeers() {
+	for id, p := range n.peers {
+		if !n.isMember(id) {
+			close(p.stop)
+			delete(n.peers, id)
+		}
+	}
+	if n.stopped {
+		return
+	}
+	for _, id := range n.members {
+		if id == n.cfg.ID || n.peers[id] != nil {
+			continue
+		}
+		p := &peer{
+			id:      id,
+			next:    n.lastIndex() + 1,
+			trigger: make(chan struct{}, 1),
+			stop:    make(chan struct{}),
+		}
+		n.peers[id] = p
+		n.wg.Add(1)
+		go n.replicate(p, n.term)
+	}
+}
+
+func (n *Node) triggerPeers() {
+	for _, p := range n.peers {
+		select {
+		case p.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
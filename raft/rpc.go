@@ -0,0 +1,345 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"time"
+)
+
+type EntryType uint8
+
+const (
+	EntryCommand EntryType = iota
+	// EntryNoop додає новий лідер на початку свого терму.
+	EntryNoop
+	// EntryConfig містить новий склад кластера як JSON-масив ID.
+	EntryConfig
+)
+
+type Entry struct {
+	Index uint64    `json:"index"`
+	Term  uint64    `json:"term"`
+	Type  EntryType `json:"type"`
+	Data  []byte    `json:"data,omitempty"`
+}
+
+type RequestVoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type RequestVoteResponse struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+type AppendEntriesRequest struct {
+	Term         uint64  `json:"term"`
+	LeaderID     string  `json:"leader_id"`
+	PrevLogIndex uint64  `json:"prev_log_index"`
+	PrevLogTerm  uint64  `json:"prev_log_term"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit uint64  `json:"leader_commit"`
+}
+
+type AppendEntriesResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// ConflictIndex - з якого індексу лідеру варто повторити відправку після відмови
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+// InstallSnapshotRequest передає знімок цілком; для великих баз його варто розбивати на частини.
+type InstallSnapshotRequest struct {
+	Term              uint64   `json:"term"`
+	LeaderID          string   `json:"leader_id"`
+	LastIncludedIndex uint64   `json:"last_included_index"`
+	LastIncludedTerm  uint64   `json:"last_included_term"`
+	Members           []string `json:"members"`
+	Data              []byte   `json:"data"`
+}
+
+type InstallSnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+// Transport доставляє RPC іншим вузлам за їхніми ID.
+type Transport interface {
+	RequestVote(ctx context.Context, target string, req RequestVoteRequest) (RequestVoteResponse, error)
+	AppendEntries(ctx context.Context, target string, req AppendEntriesRequest) (AppendEntriesResponse, error)
+	InstallSnapshot(ctx context.Context, target string, req InstallSnapshotRequest) (InstallSnapshotResponse, error)
+}
+
+func (n *Node) HandleRequestVote(req RequestVoteRequest) RequestVoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// Поки лідер на зв'язку, голосування ігнорується: так вилучений вузол не зриває роботу кластера
+	if n.state == Follower && n.leader != "" && time.Since(n.lastContact) < n.cfg.ElectionTimeout {
+		return RequestVoteResponse{Term: n.term}
+	}
+	if req.Term < n.term {
+		return RequestVoteResponse{Term: n.term}
+	}
+	if req.Term > n.term {
+		n.becomeFollower(req.Term)
+	}
+
+	upToDate := req.LastLogTerm > n.lastTerm() ||
+		(req.LastLogTerm == n.lastTerm() && req.LastLogIndex >= n.lastIndex())
+	if (n.vote != "" && n.vote != req.CandidateID) || !upToDate {
+		return RequestVoteResponse{Term: n.term}
+	}
+	n.vote = req.CandidateID
+	if err := n.persistState(); err != nil {
+		return RequestVoteResponse{Term: n.term}
+	}
+	n.resetElectionTimer()
+	return RequestVoteResponse{Term: n.term, VoteGranted: true}
+}
+
+func (n *Node) HandleAppendEntries(req AppendEntriesRequest) AppendEntriesResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return AppendEntriesResponse{Term: n.term}
+	}
+	n.acceptLeader(req.Term, req.LeaderID)
+
+	prevIndex, prevTerm, entries := req.PrevLogIndex, req.PrevLogTerm, req.Entries
+	// записи, що вже увійшли до знімка, закомічені і збігаються з лідером
+	if prevIndex < n.snapIndex() {
+		skip := n.snapIndex() - prevIndex
+		if skip >= uint64(len(entries)) {
+			return AppendEntriesResponse{Term: n.term, Success: true}
+		}
+		entries = entries[skip:]
+		prevIndex, prevTerm = n.snapIndex(), n.entries[0].Term
+	}
+	if prevIndex > n.lastIndex() {
+		return AppendEntriesResponse{Term: n.term, ConflictIndex: n.lastIndex() + 1}
+	}
+	if term, _ := n.termAt(prevIndex); term != prevTerm {
+		// пропускаємо весь конфліктний терм одразу, а не по одному запису
+		conflict := prevIndex
+		for conflict > n.snapIndex()+1 {
+			if t, _ := n.termAt(conflict - 1); t != term {
+				break
+			}
+			conflict--
+		}
+		return AppendEntriesResponse{Term: n.term, ConflictIndex: conflict}
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.lastIndex() {
+			if term, _ := n.termAt(e.Index); term == e.Term {
+				continue
+			}
+			kept := n.entries[:e.Index-n.snapIndex()]
+			if err := n.cfg.Storage.Replace(kept[1:]); err != nil {
+				n.log.Error("failed to truncate log", "index", e.Index, "err", err)
+				return AppendEntriesResponse{Term: n.term}
+			}
+			n.entries = kept
+			n.failWaiters(e.Index, ErrLeadershipLost)
+		}
+		if err := n.cfg.Storage.Append(entries[i:]); err != nil {
+			n.log.Error("failed to append entries", "err", err)
+			return AppendEntriesResponse{Term: n.term}
+		}
+		n.entries = append(n.entries, entries[i:]...)
+		break
+	}
+	n.refreshMembers()
+
+	lastNew := prevIndex + uint64(len(entries))
+	n.setCommitIndex(min(req.LeaderCommit, lastNew))
+	return AppendEntriesResponse{Term: n.term, Success: true}
+}
+
+func (n *Node) HandleInstallSnapshot(req InstallSnapshotRequest) (InstallSnapshotResponse, error) {
+	n.mu.Lock()
+	if req.Term < n.term {
+		defer n.mu.Unlock()
+		return InstallSnapshotResponse{Term: n.term}, nil
+	}
+	n.acceptLeader(req.Term, req.LeaderID)
+	if req.LastIncludedIndex <= n.snapIndex() {
+		defer n.mu.Unlock()
+		return InstallSnapshotResponse{Term: n.term}, nil
+	}
+	n.mu.Unlock()
+
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	meta := SnapshotMeta{Index: req.LastIncludedIndex, Term: req.LastIncludedTerm, Members: req.Members}
+	sink, err := n.cfg.Storage.CreateSnapshot(meta)
+	if err != nil {
+		return InstallSnapshotResponse{}, err
+	}
+	if _, err := sink.Write(req.Data); err != nil {
+		_ = sink.Cancel()
+		return InstallSnapshotResponse{}, err
+	}
+	if err := sink.Close(); err != nil {
+		return InstallSnapshotResponse{}, err
+	}
+	if err := n.cfg.StateMachine.Restore(bytes.NewReader(req.Data)); err != nil {
+		return InstallSnapshotResponse{}, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var rest []Entry
+	if term, ok := n.termAt(meta.Index); ok && term == meta.Term {
+		rest = slices.Clone(n.entries[meta.Index-n.snapIndex()+1:])
+	}
+	if err := n.cfg.Storage.Replace(rest); err != nil {
+		return InstallSnapshotResponse{}, err
+	}
+	n.entries = append([]Entry{{Index: meta.Index, Term: meta.Term}}, rest...)
+	n.snapMembers = meta.Members
+	n.refreshMembers()
+	n.commitIndex = max(n.commitIndex, meta.Index)
+	n.lastApplied = meta.Index
+	n.log.Info("snapshot installed", "index", meta.Index, "leader", req.LeaderID)
+	return InstallSnapshotResponse{Term: n.term}, nil
+}
+
+// acceptLeader викликається з утриманим mu на запит від лідера терму term.
+func (n *Node) acceptLeader(term uint64, leader string) {
+	if term > n.term || n.state != Follower {
+		n.becomeFollower(term)
+	}
+	n.leader = leader
+	n.lastContact = time.Now()
+	n.resetElectionTimer()
+}
+
+// replicate надсилає записи одному вузлу, доки цей вузол лідер у терміні term.
+func (n *Node) replicate(p *peer, term uint64) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		for n.sendTo(p, term) {
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-n.shutdown:
+			return
+		case <-ticker.C:
+		case <-p.trigger:
+		}
+	}
+}
+
+// sendTo надсилає одну порцію записів або знімок; true означає, що варто одразу надіслати наступну.
+func (n *Node) sendTo(p *peer, term uint64) bool {
+	n.mu.Lock()
+	if n.state != Leader || n.term != term || n.stopped {
+		n.mu.Unlock()
+		return false
+	}
+	if p.next <= n.snapIndex() {
+		n.mu.Unlock()
+		return n.sendSnapshot(p, term)
+	}
+	prev := p.next - 1
+	prevTerm, _ := n.termAt(prev)
+	var entries []Entry
+	if p.next <= n.lastIndex() {
+		entries = n.slice(p.next, min(n.lastIndex(), p.next+uint64(n.cfg.MaxAppendEntries)-1))
+	}
+	req := AppendEntriesRequest{
+		Term:         term,
+		LeaderID:     n.cfg.ID,
+		PrevLogIndex: prev,
+		PrevLogTerm:  prevTerm,
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	defer cancel()
+	resp, err := n.cfg.Transport.AppendEntries(ctx, p.id, req)
+	if err != nil {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term)
+		return false
+	}
+	if n.state != Leader || n.term != term {
+		return false
+	}
+	if !resp.Success {
+		if resp.ConflictIndex > 0 {
+			p.next = min(resp.ConflictIndex, prev)
+		} else {
+			p.next = prev
+		}
+		p.next = max(p.next, 1)
+		return true
+	}
+	p.match = max(p.match, prev+uint64(len(entries)))
+	p.next = p.match + 1
+	n.advanceCommit()
+	return p.next <= n.lastIndex()
+}
+
+func (n *Node) sendSnapshot(p *peer, term uint64) bool {
+	meta, data, err := n.cfg.Storage.Snapshot()
+	if err != nil {
+		n.log.Error("cannot read snapshot for peer", "peer", p.id, "err", err)
+		return false
+	}
+	buf, err := io.ReadAll(data)
+	data.Close()
+	if err != nil {
+		n.log.Error("cannot read snapshot for peer", "peer", p.id, "err", err)
+		return false
+	}
+
+	req := InstallSnapshotRequest{
+		Term:              term,
+		LeaderID:          n.cfg.ID,
+		LastIncludedIndex: meta.Index,
+		LastIncludedTerm:  meta.Term,
+		Members:           meta.Members,
+		Data:              buf,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*n.cfg.ElectionTimeout)
+	defer cancel()
+	resp, err := n.cfg.Transport.InstallSnapshot(ctx, p.id, req)
+	if err != nil {
+		n.log.Warn("install snapshot failed", "peer", p.id, "err", err)
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if resp.Term > n.term {
+		n.becomeFollower(resp.Term)
+		return false
+	}
+	if n.state != Leader || n.term != term {
+		return false
+	}
+	p.match = max(p.match, meta.Index)
+	p.next = p.match + 1
+	n.advanceCommit()
+	n.log.Info("snapshot sent", "peer", p.id, "index", meta.Index)
+	return true
+}
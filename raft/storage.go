@@ -0,0 +1,280 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrNoSnapshot = errors.New("no snapshot")
+
+type HardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for"`
+}
+
+type SnapshotMeta struct {
+	Index   uint64   `json:"index"`
+	Term    uint64   `json:"term"`
+	Members []string `json:"members"`
+}
+
+// SnapshotSink приймає дані знімка; знімок стає чинним лише після Close.
+type SnapshotSink interface {
+	io.Writer
+	Close() error
+	Cancel() error
+}
+
+// Storage зберігає стан вузла, що має пережити перезапуск. Кожен метод повертає
+// керування лише після того, як дані потрапили на диск.
+type Storage interface {
+	State() (HardState, error)
+	SetState(HardState) error
+	// Entries повертає записи журналу після останнього знімка.
+	Entries() ([]Entry, error)
+	Append([]Entry) error
+	// Replace замінює весь журнал після знімка на entries.
+	Replace(entries []Entry) error
+	Snapshot() (SnapshotMeta, io.ReadCloser, error)
+	CreateSnapshot(SnapshotMeta) (SnapshotSink, error)
+}
+
+const (
+	stateFileName    = "state.json"
+	logFileName      = "log.jsonl"
+	snapshotFileName = "snapshot"
+)
+
+// FileStorage зберігає журнал як JSON Lines, а знімок - одним файлом, перший рядок якого - SnapshotMeta.
+type FileStorage struct {
+	dir string
+
+	lock sync.Mutex
+	log  *os.File
+}
+
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir, log: f}, nil
+}
+
+func (s *FileStorage) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.log.Close()
+}
+
+func (s *FileStorage) State() (HardState, error) {
+	var hs HardState
+	data, err := os.ReadFile(filepath.Join(s.dir, stateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return hs, nil
+	}
+	if err != nil {
+		return hs, err
+	}
+	err = json.Unmarshal(data, &hs)
+	return hs, err
+}
+
+func (s *FileStorage) SetState(hs HardState) error {
+	data, err := json.Marshal(hs)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, stateFileName), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Entries читає журнал; недописаний останній рядок після аварії відкидається.
+func (s *FileStorage) Entries() ([]Entry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	var good int64
+	in := bufio.NewReader(s.log)
+	for {
+		line, err := in.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			break
+		}
+		entries = append(entries, e)
+		good += int64(len(line))
+	}
+	if err := s.log.Truncate(good); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *FileStorage) Append(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	w := bufio.NewWriter(s.log)
+	if err := encodeEntries(w, entries); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+func (s *FileStorage) Replace(entries []Entry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	path := filepath.Join(s.dir, logFileName)
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		return encodeEntries(w, entries)
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_ = s.log.Close()
+	s.log = f
+	return nil
+}
+
+func (s *FileStorage) Snapshot() (SnapshotMeta, io.ReadCloser, error) {
+	var meta SnapshotMeta
+	f, err := os.Open(filepath.Join(s.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return meta, nil, ErrNoSnapshot
+	}
+	if err != nil {
+		return meta, nil, err
+	}
+	in := bufio.NewReader(f)
+	header, err := in.ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(header, &meta)
+	}
+	if err != nil {
+		f.Close()
+		return meta, nil, fmt.Errorf("read snapshot header: %w", err)
+	}
+	return meta, struct {
+		io.Reader
+		io.Closer
+	}{in, f}, nil
+}
+
+func (s *FileStorage) CreateSnapshot(meta SnapshotMeta) (SnapshotSink, error) {
+	path := filepath.Join(s.dir, snapshotFileName)
+	f, err := os.CreateTemp(s.dir, snapshotFileName+"-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	sink := &fileSink{f: f, path: path, w: bufio.NewWriter(f)}
+	header, err := json.Marshal(meta)
+	if err == nil {
+		_, err = sink.w.Write(append(header, '\n'))
+	}
+	if err != nil {
+		_ = sink.Cancel()
+		return nil, err
+	}
+	return sink, nil
+}
+
+type fileSink struct {
+	f    *os.File
+	path string
+	w    *bufio.Writer
+}
+
+func (s *fileSink) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *fileSink) Close() error {
+	if err := s.w.Flush(); err != nil {
+		_ = s.Cancel()
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		_ = s.Cancel()
+		return err
+	}
+	if err := s.f.Close(); err != nil {
+		_ = os.Remove(s.f.Name())
+		return err
+	}
+	return os.Rename(s.f.Name(), s.path)
+}
+
+func (s *fileSink) Cancel() error {
+	_ = s.f.Close()
+	return os.Remove(s.f.Name())
+}
+
+func encodeEntries(w io.Writer, entries []Entry) error {
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic записує файл через тимчасовий файл і rename, тож після аварії лишається стара або нова версія.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}